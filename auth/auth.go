@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("not allowed")
+)
+
+type contextKey struct{}
+
+// WithEmail returns a context carrying the email of the authenticated user
+func WithEmail(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, contextKey{}, email)
+}
+
+// ForContext returns the email of the authenticated user, if any
+func ForContext(ctx context.Context) (string, bool) {
+	email, found := ctx.Value(contextKey{}).(string)
+	return email, found && email != ""
+}
+
+// ProxyHeader trusts the email set in header by an authenticating reverse proxy,
+// the proxy must strip this header from the incoming requests
+func ProxyHeader(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if email := strings.TrimSpace(r.Header.Get(header)); email != "" {
+			r = r.WithContext(WithEmail(r.Context(), email))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
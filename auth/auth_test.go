@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForContext(t *testing.T) {
+	_, found := ForContext(context.Background())
+	assert.False(t, found)
+
+	email, found := ForContext(WithEmail(context.Background(), "test@domain.com"))
+	assert.True(t, found)
+	assert.Equal(t, "test@domain.com", email)
+}
+
+func TestProxyHeader(t *testing.T) {
+	var email string
+	var found bool
+	handler := ProxyHeader("X-Forwarded-Email", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, found = ForContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, found)
+
+	req.Header.Set("X-Forwarded-Email", " test@domain.com ")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, found)
+	assert.Equal(t, "test@domain.com", email)
+}
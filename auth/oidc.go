@@ -0,0 +1,485 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+)
+
+// OIDCConfig is the registration of the server as client of the identity provider
+type OIDCConfig struct {
+	Issuer   string
+	ClientID string
+	// Empty for a public client, the code is then only protected by PKCE
+	ClientSecret string
+	// Registered at the identity provider, served by CallbackHandler
+	RedirectURL string
+}
+
+const stateCookie = "whosdriving_oidc_state"
+
+// Logins must complete within this period
+const loginTimeout = 10 * time.Minute
+
+// Accepted clock difference with the identity provider
+const clockSkew = time.Minute
+
+// The keys of the identity provider are fetched again for an unknown key id, at most this often
+const jwksRefreshInterval = time.Minute
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	redirect string
+	expire   time.Time
+}
+
+// OIDC logs the users in with the authorization code flow of an OpenID Connect provider
+type OIDC struct {
+	config    OIDCConfig
+	db        *sql.DB
+	client    *http.Client
+	discovery discovery
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	pending     map[string]pendingLogin
+}
+
+// NewOIDC discovers the endpoints of the issuer
+func NewOIDC(ctx context.Context, config OIDCConfig, db *sql.DB, client *http.Client) (*OIDC, error) {
+	o := &OIDC{
+		config:  config,
+		db:      db,
+		client:  client,
+		keys:    make(map[string]*rsa.PublicKey),
+		pending: make(map[string]pendingLogin),
+	}
+
+	configurationURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := o.getJSON(ctx, configurationURL, &o.discovery); err != nil {
+		return nil, fmt.Errorf("couldn't discover %s - %w", config.Issuer, err)
+	}
+	if o.discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("issuer %s discovered instead of %s", o.discovery.Issuer, config.Issuer)
+	}
+	if o.discovery.AuthorizationEndpoint == "" || o.discovery.TokenEndpoint == "" || o.discovery.JwksURI == "" {
+		return nil, fmt.Errorf("incomplete discovery of %s", config.Issuer)
+	}
+	return o, nil
+}
+
+func (o *OIDC) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s - %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func randomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// codeChallenge is the S256 PKCE challenge of the verifier (RFC 7636 4.2)
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// localRedirect keeps the users on this server after login
+func localRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	return redirect
+}
+
+func (o *OIDC) secureCookies() bool {
+	return strings.HasPrefix(o.config.RedirectURL, "https://")
+}
+
+// LoginHandler sends the user to the identity provider, back to the redirect parameter once logged in
+func (o *OIDC) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizationURL, err := url.Parse(o.discovery.AuthorizationEndpoint)
+		if err != nil {
+			log.Printf("Error: Invalid authorization endpoint - %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		login := pendingLogin{redirect: localRedirect(r.URL.Query().Get("redirect")), expire: time.Now().Add(loginTimeout)}
+		state, err := randomString()
+		if err == nil {
+			login.nonce, err = randomString()
+		}
+		if err == nil {
+			login.verifier, err = randomString()
+		}
+		if err != nil {
+			log.Printf("Error: Couldn't start login - %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		o.mu.Lock()
+		now := time.Now()
+		for pendingState, pending := range o.pending {
+			if now.After(pending.expire) {
+				delete(o.pending, pendingState)
+			}
+		}
+		o.pending[state] = login
+		o.mu.Unlock()
+
+		query := authorizationURL.Query()
+		query.Set("response_type", "code")
+		query.Set("client_id", o.config.ClientID)
+		query.Set("redirect_uri", o.config.RedirectURL)
+		query.Set("scope", "openid email profile")
+		query.Set("state", state)
+		query.Set("nonce", login.nonce)
+		query.Set("code_challenge", codeChallenge(login.verifier))
+		query.Set("code_challenge_method", "S256")
+		authorizationURL.RawQuery = query.Encode()
+
+		// Binds the login to the browser which started it
+		http.SetCookie(w, &http.Cookie{
+			Name:     stateCookie,
+			Value:    state,
+			Path:     "/",
+			MaxAge:   int(loginTimeout.Seconds()),
+			HttpOnly: true,
+			Secure:   o.secureCookies(),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authorizationURL.String(), http.StatusFound)
+	})
+}
+
+// CallbackHandler completes the login: the user of the email claim is found or created and gets a session
+func (o *OIDC) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		state := query.Get("state")
+		cookie, err := r.Cookie(stateCookie)
+		if err != nil || state == "" || cookie.Value != state {
+			http.Error(w, "invalid login state", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+
+		o.mu.Lock()
+		login, found := o.pending[state]
+		delete(o.pending, state)
+		o.mu.Unlock()
+		if !found || time.Now().After(login.expire) {
+			http.Error(w, "login expired", http.StatusBadRequest)
+			return
+		}
+
+		if loginError := query.Get("error"); loginError != "" {
+			log.Printf("Login refused by the identity provider - %s %s", loginError, query.Get("error_description"))
+			http.Error(w, "login refused", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := o.exchange(r.Context(), query.Get("code"), login)
+		if err != nil {
+			log.Printf("Error: Login failed - %s", err)
+			http.Error(w, "login failed", http.StatusUnauthorized)
+			return
+		}
+
+		token, err := o.openSession(r.Context(), claims)
+		if err != nil {
+			log.Printf("Error: Couldn't open session - %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     SessionCookie,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(SessionDuration.Seconds()),
+			HttpOnly: true,
+			Secure:   o.secureCookies(),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, login.redirect, http.StatusFound)
+	})
+}
+
+func (o *OIDC) openSession(ctx context.Context, claims *idClaims) (string, error) {
+	tx, err := o.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: o.db, Tx: tx}
+	newUser := model.NewUser{Email: claims.Email}
+	if claims.GivenName != "" {
+		newUser.FirstName = &claims.GivenName
+	}
+	if claims.FamilyName != "" {
+		newUser.LastName = &claims.FamilyName
+	}
+	if _, err := data_interface.FindOrCreateUser(ctx, &lCtx, &newUser); err != nil {
+		return "", err
+	}
+
+	token, err := data_interface.CreateSession(ctx, &lCtx, claims.Email, SessionDuration)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s logged in", claims.Email)
+	return token, nil
+}
+
+// exchange redeems the authorization code for the ID token of the user
+func (o *OIDC) exchange(ctx context.Context, code string, login pendingLogin) (*idClaims, error) {
+	if code == "" {
+		return nil, errors.New("no authorization code")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.config.RedirectURL},
+		"client_id":     {o.config.ClientID},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.config.ClientSecret != "" {
+		// client_secret_basic (RFC 6749 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+	}
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response - %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed - %s %s %s", res.Status, tokens.Error, tokens.ErrorDescription)
+	}
+	return o.verify(ctx, tokens.IDToken, login.nonce)
+}
+
+// audience is a single string or an array in the ID tokens
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+type idClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+// verify checks the signature and the claims of the ID token (OpenID Connect Core 3.1.3.7)
+func (o *OIDC) verify(ctx context.Context, idToken string, nonce string) (*idClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header - %w", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Algorithm)
+	}
+	key, err := o.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature - %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("invalid ID token signature - %w", err)
+	}
+
+	claims := new(idClaims)
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims - %w", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != o.config.Issuer:
+		return nil, fmt.Errorf("ID token issued by %s", claims.Issuer)
+	case !claims.Audience.contains(o.config.ClientID):
+		return nil, fmt.Errorf("ID token not issued for %s", o.config.ClientID)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != o.config.ClientID:
+		return nil, fmt.Errorf("ID token authorized for %s", claims.AuthorizedBy)
+	case now.Add(-clockSkew).After(time.Unix(claims.Expiry, 0)):
+		return nil, errors.New("ID token expired")
+	case now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, errors.New("ID token issued in the future")
+	case claims.Nonce != nonce:
+		return nil, errors.New("ID token nonce mismatch")
+	case claims.Email == "":
+		return nil, errors.New("ID token without email claim")
+	case claims.EmailVerified != nil && !*claims.EmailVerified:
+		return nil, fmt.Errorf("email %s not verified", claims.Email)
+	}
+	return claims, nil
+}
+
+// key returns the signing key of the identity provider, the keys are fetched again when unknown
+func (o *OIDC) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if key := o.findKey(keyID); key != nil {
+		return key, nil
+	}
+	if time.Since(o.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, o.discovery.JwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("couldn't fetch signing keys - %w", err)
+	}
+	o.keysFetched = time.Now()
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			log.Printf("Ignore malformed signing key %q", jwk.KeyID)
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	o.keys = keys
+
+	if key := o.findKey(keyID); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", keyID)
+}
+
+// findKey looks the key up by id, tokens without id need the provider to have a single key
+func (o *OIDC) findKey(keyID string) *rsa.PublicKey {
+	if keyID == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key
+		}
+	}
+	return o.keys[keyID]
+}
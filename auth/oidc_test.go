@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"whosdriving-be/data_interface"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testClientID = "whosdriving"
+const testClientSecret = "s3cret"
+const testRedirectURL = "http://whosdriving.test/auth/callback"
+
+// stubIdP is a minimal OpenID provider issuing the claims set by the test for any login
+type stubIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	// Code challenges and nonces of the authorization requests, by code
+	challenges map[string]string
+	nonces     map[string]string
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Couldn't generate key - %s", err)
+	}
+	idp := &stubIdP{key: key, challenges: make(map[string]string), nonces: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	// Logs any user in straight away
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := "code-" + query.Get("state")
+		idp.challenges[code] = query.Get("code_challenge")
+		idp.nonces[code] = query.Get("nonce")
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		code := r.PostFormValue("code")
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if clientID != testClientID || clientSecret != testClientSecret || r.PostFormValue("redirect_uri") != testRedirectURL ||
+			idp.challenges[code] != base64.RawURLEncoding.EncodeToString(verifier[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]interface{}{"nonce": idp.nonces[code]}
+		for name, value := range idp.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token_type": "Bearer", "id_token": idp.sign(t, claims)})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *stubIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Couldn't sign - %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (idp *stubIdP) validClaims() map[string]interface{} {
+	now := time.Now().Unix()
+	return map[string]interface{}{
+		"iss":            idp.server.URL,
+		"sub":            "42",
+		"aud":            testClientID,
+		"exp":            now + 300,
+		"iat":            now,
+		"email":          "john.doe@domain.com",
+		"email_verified": true,
+		"given_name":     "John",
+		"family_name":    "Doe",
+	}
+}
+
+// login runs the browser side of the flow, returns the response of the callback
+func login(t *testing.T, o *OIDC, client *http.Client) *httptest.ResponseRecorder {
+	start := httptest.NewRecorder()
+	o.LoginHandler().ServeHTTP(start, httptest.NewRequest(http.MethodGet, "/auth/login?redirect=/rotations", nil))
+	assert.Equal(t, http.StatusFound, start.Code)
+
+	authorizationURL, err := url.Parse(start.Header().Get("Location"))
+	assert.Nil(t, err)
+	query := authorizationURL.Query()
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, testClientID, query.Get("client_id"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Contains(t, query.Get("scope"), "openid")
+
+	res, err := client.Get(authorizationURL.String())
+	assert.Nil(t, err)
+	res.Body.Close()
+	callbackURL, err := url.Parse(res.Header.Get("Location"))
+	assert.Nil(t, err)
+
+	callback := httptest.NewRequest(http.MethodGet, "/auth/callback?"+callbackURL.RawQuery, nil)
+	for _, cookie := range start.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	done := httptest.NewRecorder()
+	o.CallbackHandler().ServeHTTP(done, callback)
+	return done
+}
+
+func sessionCookie(res *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == SessionCookie && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDC(t *testing.T) {
+	const dbPath = "../test_oidc.sqlite3"
+	os.Remove(dbPath)
+
+	db, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	if err := data_interface.Migrate("../assets/ddl.whosdriving-core", db); err != nil {
+		t.Fatalf("Migration error - %s", err)
+	}
+
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+
+	// Don't follow the redirection of the identity provider to the callback
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	ctx := context.Background()
+	o, err := NewOIDC(ctx, OIDCConfig{Issuer: idp.server.URL, ClientID: testClientID, ClientSecret: testClientSecret, RedirectURL: testRedirectURL}, db, client)
+	if err != nil {
+		t.Fatalf("Discovery error - %s", err)
+	}
+	_, err = NewOIDC(ctx, OIDCConfig{Issuer: idp.server.URL + "/other", ClientID: testClientID}, db, client)
+	assert.NotNil(t, err, "Unknown issuer")
+
+	// Successful login creates the user and opens a session
+	idp.claims = idp.validClaims()
+	res := login(t, o, client)
+	assert.Equal(t, http.StatusFound, res.Code)
+	assert.Equal(t, "/rotations", res.Header().Get("Location"))
+	cookie := sessionCookie(res)
+	if cookie == nil {
+		t.Fatal("No session cookie")
+	}
+	assert.True(t, cookie.HttpOnly)
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	email := "john.doe@domain.com"
+	user, err := data_interface.FindUser(ctx, &lCtx, &email)
+	assert.Nil(t, err)
+	assert.Equal(t, "John", *user.FirstName)
+	assert.Equal(t, "Doe", *user.LastName)
+	tx.Rollback()
+
+	// The session authenticates the requests until logout
+	var authenticated string
+	handler := Session(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticated, _ = ForContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.AddCookie(cookie)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, email, authenticated)
+
+	logout := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	logout.AddCookie(cookie)
+	loggedOut := httptest.NewRecorder()
+	LogoutHandler(db).ServeHTTP(loggedOut, logout)
+	assert.Equal(t, http.StatusNoContent, loggedOut.Code)
+
+	authenticated = ""
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "", authenticated)
+
+	// Invalid ID tokens are refused
+	for name, change := range map[string]func(claims map[string]interface{}){
+		"issuer":     func(claims map[string]interface{}) { claims["iss"] = "https://evil.test" },
+		"audience":   func(claims map[string]interface{}) { claims["aud"] = "other" },
+		"azp":        func(claims map[string]interface{}) { claims["aud"] = []string{testClientID, "other"} },
+		"expired":    func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"nonce":      func(claims map[string]interface{}) { claims["nonce"] = "replayed" },
+		"email":      func(claims map[string]interface{}) { delete(claims, "email") },
+		"unverified": func(claims map[string]interface{}) { claims["email_verified"] = false },
+	} {
+		idp.claims = idp.validClaims()
+		change(idp.claims)
+		res := login(t, o, client)
+		assert.Equal(t, http.StatusUnauthorized, res.Code, name)
+		assert.Nil(t, sessionCookie(res), name)
+	}
+
+	// Forged signature
+	_, err = o.verify(ctx, strings.Join(strings.Split(idp.sign(t, idp.validClaims()), ".")[:2], ".")+".Zm9yZ2Vk", "")
+	assert.NotNil(t, err)
+
+	// The callback must come from the browser which started the login
+	start := httptest.NewRecorder()
+	o.LoginHandler().ServeHTTP(start, httptest.NewRequest(http.MethodGet, "/auth/login?redirect=//evil.test", nil))
+	state, _ := url.Parse(start.Header().Get("Location"))
+	callback := httptest.NewRecorder()
+	o.CallbackHandler().ServeHTTP(callback, httptest.NewRequest(http.MethodGet, "/auth/callback?code=x&state="+state.Query().Get("state"), nil))
+	assert.Equal(t, http.StatusBadRequest, callback.Code)
+	assert.Equal(t, "/", localRedirect("//evil.test"))
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+	"whosdriving-be/data_interface"
+)
+
+const SessionCookie = "whosdriving_session"
+
+// Users log in again once their session expires
+const SessionDuration = 7 * 24 * time.Hour
+
+// Session authenticates the requests carrying the cookie of an open session, other ones go through unchanged
+func Session(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(SessionCookie)
+		if err == nil && cookie.Value != "" {
+			email, err := findSessionUser(r.Context(), db, cookie.Value)
+			switch {
+			case err == sql.ErrNoRows:
+				// Expired or closed, the request is anonymous
+			case err != nil:
+				log.Printf("Error: Couldn't load session - %s", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			default:
+				r = r.WithContext(WithEmail(r.Context(), email))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func findSessionUser(ctx context.Context, db *sql.DB, token string) (string, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	return data_interface.FindSessionUser(ctx, &lCtx, token)
+}
+
+// LogoutHandler closes the session of the request and clears its cookie
+func LogoutHandler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cookie, err := r.Cookie(SessionCookie); err == nil && cookie.Value != "" {
+			if err := deleteSession(r.Context(), db, cookie.Value); err != nil {
+				log.Printf("Error: Couldn't close session - %s", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: SessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func deleteSession(ctx context.Context, db *sql.DB, token string) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	if err := data_interface.DeleteSession(ctx, &lCtx, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+)
+
+var ErrInsufficientScope = errors.New("not allowed by the scopes of the API token")
+
+type scopesKey struct{}
+
+// WithScopes returns a context carrying the scopes of the API token authenticating the request
+func WithScopes(ctx context.Context, scopes []model.TokenScope) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// ScopesForContext returns the scopes of the API token, not found when the user isn't authenticated by a token
+func ScopesForContext(ctx context.Context) ([]model.TokenScope, bool) {
+	scopes, found := ctx.Value(scopesKey{}).([]model.TokenScope)
+	return scopes, found
+}
+
+// BearerToken authenticates the requests carrying an API token in their Authorization header,
+// requests with an invalid token are refused
+func BearerToken(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if len(authorization) < len("Bearer ") || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		email, scopes, err := authenticateToken(r.Context(), db, strings.TrimSpace(authorization[len("Bearer "):]))
+		switch {
+		case err == sql.ErrNoRows:
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid API token", http.StatusUnauthorized)
+			return
+		case err != nil:
+			log.Printf("Error: Couldn't authenticate API token - %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		ctx := WithScopes(WithEmail(r.Context(), email), scopes)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func authenticateToken(ctx context.Context, db *sql.DB, token string) (string, []model.TokenScope, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	email, scopes, err := data_interface.AuthenticateAPIToken(ctx, &lCtx, token, time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	return email, scopes, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerToken(t *testing.T) {
+	const dbPath = "../test_bearer.sqlite3"
+	os.Remove(dbPath)
+
+	db, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	if err := data_interface.Migrate("../assets/ddl.whosdriving-core", db); err != nil {
+		t.Fatalf("Migration error - %s", err)
+	}
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	email := "bot.owner@domain.com"
+	_, err = data_interface.FindOrCreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+	assert.Nil(t, err)
+	generated, err := data_interface.CreateAPIToken(ctx, &lCtx, email, &model.NewAPIToken{Name: "bot", Scopes: []model.TokenScope{model.TokenScopeReadOnly}})
+	assert.Nil(t, err)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+
+	var authenticated string
+	var scopes []model.TokenScope
+	var scoped bool
+	handler := BearerToken(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticated, _ = ForContext(r.Context())
+		scopes, scoped = ScopesForContext(r.Context())
+	}))
+
+	// Requests without token go through
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/query", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "", authenticated)
+	assert.False(t, scoped)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.Header.Set("Authorization", "bearer "+generated.Token)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, email, authenticated)
+	assert.True(t, scoped)
+	assert.Equal(t, []model.TokenScope{model.TokenScopeReadOnly}, scopes)
+
+	authenticated = ""
+	req.Header.Set("Authorization", "Bearer wdp_forged")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Contains(t, res.Header().Get("WWW-Authenticate"), "invalid_token")
+	assert.Equal(t, "", authenticated)
+}
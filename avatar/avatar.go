@@ -0,0 +1,198 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Prefix of the URLs the avatars are served at
+const Prefix = "/avatars/"
+
+// Uploads larger than this are rejected
+const MaxSize = 2 << 20
+
+// Larger images are rejected before being decoded
+const maxDimension = 4096
+
+// Avatars are cropped to a square and stored at these sizes
+const (
+	Size          = 256
+	ThumbnailSize = 64
+)
+
+var contentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// Stored files are <name>-<size>.png, name being 32 random hex digits
+var fileName = regexp.MustCompile(`^[0-9a-f]{32}-(64|256)\.png$`)
+
+var ErrTooLarge = fmt.Errorf("avatar exceeds %d bytes", MaxSize)
+var ErrUnsupportedType = errors.New("avatar must be a PNG, JPEG or GIF image")
+
+// Store keeps the avatars as files of a local directory
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+func URL(name string) string {
+	return fmt.Sprintf("%s%s-%d.png", Prefix, name, Size)
+}
+
+func ThumbnailURL(name string) string {
+	return fmt.Sprintf("%s%s-%d.png", Prefix, name, ThumbnailSize)
+}
+
+func (s *Store) path(name string, size int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%d.png", name, size))
+}
+
+// Save validates the uploaded image and stores it resized, returns the name of the avatar
+func (s *Store) Save(r io.Reader) (string, error) {
+	content, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(content) > MaxSize {
+		return "", ErrTooLarge
+	}
+	if !contentTypes[http.DetectContentType(content)] {
+		return "", ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return "", ErrUnsupportedType
+	}
+	if config.Width > maxDimension || config.Height > maxDimension {
+		return "", fmt.Errorf("avatar exceeds %dx%d pixels", maxDimension, maxDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return "", ErrUnsupportedType
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(random)
+
+	square := centerSquare(img.Bounds())
+	for _, size := range []int{Size, ThumbnailSize} {
+		if err := s.write(s.path(name, size), resize(img, square, size)); err != nil {
+			s.Remove(name)
+			return "", err
+		}
+	}
+
+	log.Printf("Store avatar %s", name)
+	return name, nil
+}
+
+func (s *Store) write(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Remove deletes the files of the avatar, missing ones are ignored
+func (s *Store) Remove(name string) {
+	for _, size := range []int{Size, ThumbnailSize} {
+		if err := os.Remove(s.path(name, size)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error: Couldn't remove avatar %s - %s", name, err)
+		}
+	}
+}
+
+// Handler serves the stored avatars at Prefix, names are random so they never change
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, Prefix)
+		if !fileName.MatchString(name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeFile(w, r, filepath.Join(s.dir, name))
+	})
+}
+
+// centerSquare is the largest square in the middle of the bounds
+func centerSquare(bounds image.Rectangle) image.Rectangle {
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// resize scales the area of src to a size x size image, each pixel averaging the source pixels it covers
+func resize(src image.Image, area image.Rectangle, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := span(area.Min.Y, area.Dy(), y, size)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(area.Min.X, area.Dx(), x, size)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// span is the range of source coordinates covered by the destination coordinate i, at least one
+func span(min int, length int, i int, size int) (int, int) {
+	start := min + i*length/size
+	end := min + (i+1)*length/size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
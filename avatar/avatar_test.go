@@ -0,0 +1,104 @@
+package avatar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodePng(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Red left half, blue right half
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Couldn't encode png - %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAvatar(t *testing.T) {
+	dir, err := os.MkdirTemp("", "avatars")
+	if err != nil {
+		t.Fatalf("Couldn't create directory - %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Couldn't create store - %s", err)
+	}
+
+	// Rejected uploads
+	_, err = store.Save(strings.NewReader("not an image"))
+	assert.Equal(t, ErrUnsupportedType, err)
+	_, err = store.Save(bytes.NewReader(append([]byte("%PDF-1.4\n"), make([]byte, 100)...)))
+	assert.Equal(t, ErrUnsupportedType, err)
+	_, err = store.Save(bytes.NewReader(make([]byte, MaxSize+1)))
+	assert.Equal(t, ErrTooLarge, err)
+	_, err = store.Save(bytes.NewReader(encodePng(t, maxDimension+1, 1)))
+	assert.Error(t, err)
+
+	// Wide image is cropped to its center and resized
+	name, err := store.Save(bytes.NewReader(encodePng(t, 600, 300)))
+	assert.Nil(t, err)
+	assert.Regexp(t, "^[0-9a-f]{32}$", name)
+	assert.Equal(t, "/avatars/"+name+"-256.png", URL(name))
+	assert.Equal(t, "/avatars/"+name+"-64.png", ThumbnailURL(name))
+
+	server := httptest.NewServer(store.Handler())
+	defer server.Close()
+
+	for url, size := range map[string]int{URL(name): Size, ThumbnailURL(name): ThumbnailSize} {
+		res, err := http.Get(server.URL + url)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "image/png", res.Header.Get("Content-Type"))
+
+		img, err := png.Decode(res.Body)
+		res.Body.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, size, size), img.Bounds())
+		r, _, b, _ := img.At(0, 0).RGBA()
+		assert.Equal(t, uint32(0xffff), r)
+		assert.Equal(t, uint32(0), b)
+		r, _, b, _ = img.At(size-1, size-1).RGBA()
+		assert.Equal(t, uint32(0), r)
+		assert.Equal(t, uint32(0xffff), b)
+	}
+
+	// Only stored avatars are served
+	for _, url := range []string{"/avatars/", "/avatars/../avatar.go", "/avatars/" + name + "-128.png"} {
+		res, err := http.Get(server.URL + url)
+		assert.Nil(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode, url)
+	}
+	res, err := http.Post(server.URL+URL(name), "image/png", nil)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+
+	store.Remove(name)
+	res, err = http.Get(server.URL + URL(name))
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
@@ -0,0 +1,244 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+)
+
+// Names of the snapshots, sorted by date
+const (
+	prefix     = "whosdriving-"
+	suffix     = ".sqlite3"
+	timeFormat = "20060102T150405.000Z"
+	// Of the snapshots taken before the names had milliseconds
+	legacyTimeFormat = "20060102T150405Z"
+)
+
+// Files of sqlite next to the database while it's in use
+var journalSuffixes = []string{"-journal", "-wal", "-shm"}
+
+// Replaced by the tests to fail the swap of the restored database
+var rename = os.Rename
+
+// Store keeps the last snapshots of the database in a directory
+type Store struct {
+	dir string
+	// Number of snapshots kept, the older ones are deleted
+	retain int
+
+	// The snapshots are taken one at a time, each one named after the previous one
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewStore keeps retain snapshots in dir, created when missing
+func NewStore(dir string, retain int) (*Store, error) {
+	if retain < 1 {
+		return nil, fmt.Errorf("invalid backup retention %d, at least 1 snapshot is kept", retain)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, retain: retain}, nil
+}
+
+func toModel(info os.FileInfo, createdAt time.Time) *model.Backup {
+	return &model.Backup{Name: info.Name(), Size: int(info.Size()), CreatedAt: createdAt}
+}
+
+// Create takes a snapshot of the database while it stays in use, then deletes the snapshots beyond the retention
+func (s *Store) Create(ctx context.Context, db *sql.DB, now time.Time) (*model.Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Two snapshots taken within the same millisecond get distinct names
+	createdAt := now.UTC().Truncate(time.Millisecond)
+	if !createdAt.After(s.last) {
+		createdAt = s.last.Add(time.Millisecond)
+	}
+	s.last = createdAt
+
+	path := filepath.Join(s.dir, prefix+createdAt.Format(timeFormat)+suffix)
+	if err := data_interface.Backup(ctx, db, path); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Backed up the database to %s", path)
+
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	return toModel(info, createdAt), nil
+}
+
+// List returns the snapshots, the latest first
+func (s *Store) List() ([]*model.Backup, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]*model.Backup, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		createdAt, err := parseTime(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, toModel(info, createdAt))
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	createdAt, err := time.Parse(timeFormat, value)
+	if err != nil {
+		return time.Parse(legacyTimeFormat, value)
+	}
+	return createdAt, nil
+}
+
+// Path is the path of the snapshot
+func (s *Store) Path(name string) string {
+	return filepath.Join(s.dir, filepath.Base(name))
+}
+
+func (s *Store) prune() error {
+	backups, err := s.List()
+	if err != nil {
+		return err
+	}
+	for i := s.retain; i < len(backups); i++ {
+		if err := os.Remove(s.Path(backups[i].Name)); err != nil {
+			return err
+		}
+		log.Printf("Deleted the backup %s beyond the retention", backups[i].Name)
+	}
+	return nil
+}
+
+// Verify checks the integrity of the database copy, and that it has the schema of this version
+func Verify(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := data_interface.NewConnection("file:" + path + "?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return fmt.Errorf("%s isn't a readable database - %w", path, err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("%s is corrupted - %s", path, integrity)
+	}
+
+	version, err := data_interface.FindSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version != data_interface.SchemaVersion {
+		return fmt.Errorf("%s has the schema version %d instead of %d, migrate a copy of it first", path, version, data_interface.SchemaVersion)
+	}
+	return nil
+}
+
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	if err := destination.Sync(); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
+
+// Restore replaces the database, which must not be in use, by a verified copy of the backup.
+// The replaced database is kept aside, its path is returned, and put back when the swap fails.
+func Restore(dbPath string, backupPath string, now time.Time) (string, error) {
+	// Copied next to the database for the swap to be atomic
+	restoring := dbPath + ".restoring"
+	os.Remove(restoring)
+	if err := copyFile(backupPath, restoring); err != nil {
+		return "", err
+	}
+	defer os.Remove(restoring)
+	if err := Verify(restoring); err != nil {
+		return "", err
+	}
+
+	replaced := dbPath + ".replaced-" + now.UTC().Format(timeFormat)
+	// Suffixes of the files moved aside, the database is the empty one
+	moved := make([]string, 0, len(journalSuffixes)+1)
+	putBack := func() {
+		for _, movedSuffix := range moved {
+			if err := rename(replaced+movedSuffix, dbPath+movedSuffix); err != nil {
+				log.Printf("Error: Couldn't put back %s - %s", dbPath+movedSuffix, err)
+			}
+		}
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		// A journal left aside would be applied to the restored database
+		for _, journalSuffix := range append(journalSuffixes, "") {
+			err := rename(dbPath+journalSuffix, replaced+journalSuffix)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+			case err != nil:
+				putBack()
+				return "", err
+			default:
+				moved = append(moved, journalSuffix)
+			}
+		}
+	} else {
+		replaced = ""
+	}
+
+	if err := rename(restoring, dbPath); err != nil {
+		putBack()
+		return "", err
+	}
+	log.Printf("Restored the database %s from %s", dbPath, backupPath)
+	return replaced, nil
+}
@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"whosdriving-be/data_interface"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDb(t *testing.T, dbPath string) *sql.DB {
+	db, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	if err := data_interface.Migrate("../assets/ddl.whosdriving-core", db); err != nil {
+		t.Fatalf("Migration error - %s", err)
+	}
+	return db
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	db := newDb(t, filepath.Join(dir, "whosdriving.sqlite3"))
+	defer db.Close()
+
+	_, err := NewStore(filepath.Join(dir, "backups"), 0)
+	assert.NotNil(t, err)
+	store, err := NewStore(filepath.Join(dir, "backups"), 2)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	now := time.Date(2022, 10, 3, 8, 30, 15, 500, time.UTC)
+	first, err := store.Create(ctx, db, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "whosdriving-20221003T083015.000Z.sqlite3", first.Name)
+	assert.True(t, first.Size > 0)
+	// Snapshots aren't overwritten, those of the same instant are named apart
+	second, err := store.Create(ctx, db, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "whosdriving-20221003T083015.001Z.sqlite3", second.Name)
+
+	_, err = store.Create(ctx, db, now.Add(time.Hour))
+	assert.Nil(t, err)
+	last, err := store.Create(ctx, db, now.Add(2*time.Hour))
+	assert.Nil(t, err)
+
+	// Only the last ones are kept
+	backups, err := store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(backups))
+	assert.Equal(t, last.Name, backups[0].Name)
+	assert.Equal(t, now.Add(2*time.Hour).Truncate(time.Millisecond), backups[0].CreatedAt)
+	_, err = os.Stat(store.Path(first.Name))
+	assert.True(t, os.IsNotExist(err))
+
+	// Snapshots named to the second are still listed
+	legacy := filepath.Join(dir, "backups", "whosdriving-20221004T083015Z.sqlite3")
+	assert.Nil(t, os.WriteFile(legacy, []byte("snapshot"), 0600))
+	backups, err = store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(backups))
+	assert.Equal(t, time.Date(2022, 10, 4, 8, 30, 15, 0, time.UTC), backups[0].CreatedAt)
+
+	assert.Nil(t, Verify(store.Path(last.Name)))
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "whosdriving.sqlite3")
+	db := newDb(t, dbPath)
+	backupPath := filepath.Join(dir, "backup.sqlite3")
+	assert.Nil(t, data_interface.Backup(context.Background(), db, backupPath))
+	_, err := db.Exec("INSERT INTO Users(email, roleCd, createTmstmp, lstUpdTmstmp) VALUES ('john.doe@domain.com', 1, DATETIME('now'), DATETIME('now'))")
+	assert.Nil(t, err)
+	db.Close()
+
+	// Not a database
+	invalidPath := filepath.Join(dir, "invalid.sqlite3")
+	os.WriteFile(invalidPath, []byte("not a database"), 0600)
+	assert.NotNil(t, Verify(invalidPath))
+	_, err = Restore(dbPath, invalidPath, time.Now())
+	assert.NotNil(t, err)
+	assert.NotNil(t, Verify(filepath.Join(dir, "missing.sqlite3")))
+
+	// Of another schema version
+	otherVersionPath := filepath.Join(dir, "other.sqlite3")
+	other, err := data_interface.NewConnection(otherVersionPath)
+	assert.Nil(t, err)
+	_, err = other.Exec("CREATE TABLE Users(email TEXT)")
+	assert.Nil(t, err)
+	other.Close()
+	assert.NotNil(t, Verify(otherVersionPath))
+
+	// A journal left by a crash goes along with the replaced database
+	os.WriteFile(dbPath+"-journal", []byte("journal"), 0600)
+	now := time.Date(2022, 10, 3, 8, 30, 15, 0, time.UTC)
+
+	// The replaced files are put back when the swap fails
+	rename = func(from string, to string) error {
+		if from == dbPath+".restoring" {
+			return os.ErrPermission
+		}
+		return os.Rename(from, to)
+	}
+	_, err = Restore(dbPath, backupPath, now)
+	rename = os.Rename
+	assert.NotNil(t, err)
+	for _, path := range []string{dbPath, dbPath + "-journal"} {
+		_, err = os.Stat(path)
+		assert.Nil(t, err, path)
+	}
+	_, err = os.Stat(dbPath + ".replaced-20221003T083015.000Z")
+	assert.True(t, os.IsNotExist(err))
+
+	replaced, err := Restore(dbPath, backupPath, now)
+	assert.Nil(t, err)
+	assert.Equal(t, dbPath+".replaced-20221003T083015.000Z", replaced)
+	_, err = os.Stat(replaced + "-journal")
+	assert.Nil(t, err)
+	_, err = os.Stat(dbPath + "-journal")
+	assert.True(t, os.IsNotExist(err))
+
+	db, err = data_interface.NewConnection(dbPath)
+	assert.Nil(t, err)
+	defer db.Close()
+	var users int
+	assert.Nil(t, db.QueryRow("select count(*) from Users").Scan(&users))
+	assert.Equal(t, 0, users, "The user was created after the backup")
+
+	// Without database to replace
+	os.Remove(dbPath)
+	replaced, err = Restore(dbPath, backupPath, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "", replaced)
+}
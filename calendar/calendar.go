@@ -0,0 +1,171 @@
+package calendar
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+)
+
+// Rides have no end, events last this long in the calendars
+const eventDuration = 30 * time.Minute
+
+const icsTimeFormat = "20060102T150405Z"
+
+// Lines longer than this are folded (RFC 5545 3.1)
+const maxLineLength = 75
+
+var eventStatus = map[model.RideStatus]string{
+	model.RideStatusPlanned:   "TENTATIVE",
+	model.RideStatusRecorded:  "CONFIRMED",
+	model.RideStatusCancelled: "CANCELLED",
+}
+
+// Handler serves the feed of a user at <prefix><token>.ics
+func Handler(db *sql.DB, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := strings.TrimPrefix(r.URL.Path, prefix)
+		if !strings.HasSuffix(token, ".ics") {
+			http.NotFound(w, r)
+			return
+		}
+		token = strings.TrimSuffix(token, ".ics")
+
+		user, rides, err := loadRides(r.Context(), db, token)
+		switch {
+		case err == sql.ErrNoRows:
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			log.Printf("Error: Couldn't load calendar - %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="whosdriving.ics"`)
+		if err := Write(w, user, rides, time.Now()); err != nil {
+			log.Printf("Error: Couldn't write calendar - %s", err)
+		}
+	})
+}
+
+func loadRides(ctx context.Context, db *sql.DB, token string) (*model.User, []*data_interface.RotationRide, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	user, err := data_interface.FindUserByCalendarToken(ctx, &lCtx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rides, err := data_interface.FindUserRides(ctx, &lCtx, user.Email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, rides, nil
+}
+
+// Write renders the rides as an iCalendar (RFC 5545) document
+func Write(w io.Writer, user *model.User, rides []*data_interface.RotationRide, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//whosdriving//whosdriving-be//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + escape("Who's driving - "+displayName(user)),
+	}
+
+	for _, rotationRide := range rides {
+		ride := rotationRide.Ride
+		passengers := make([]string, 0, len(ride.Participants))
+		for _, participant := range ride.Participants {
+			if participant.Email != ride.Conductor.Email {
+				passengers = append(passengers, displayName(participant))
+			}
+		}
+
+		description := "Conductor: " + displayName(ride.Conductor)
+		if len(passengers) > 0 {
+			description += "\nPassengers: " + strings.Join(passengers, ", ")
+		}
+
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:ride-%d@whosdriving", ride.ID),
+			"DTSTAMP:"+now.UTC().Format(icsTimeFormat),
+			"DTSTART:"+ride.Date.UTC().Format(icsTimeFormat),
+			"DTEND:"+ride.Date.Add(eventDuration).UTC().Format(icsTimeFormat),
+			"SUMMARY:"+escape(fmt.Sprintf("%s - %s drives", rotationRide.RotationName, displayName(ride.Conductor))),
+			"DESCRIPTION:"+escape(description),
+			"CATEGORIES:"+escape(rotationRide.RotationName),
+			"STATUS:"+eventStatus[ride.Status],
+			"END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, fold(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func displayName(user *model.User) string {
+	names := make([]string, 0, 2)
+	if user.FirstName != nil && *user.FirstName != "" {
+		names = append(names, *user.FirstName)
+	}
+	if user.LastName != nil && *user.LastName != "" {
+		names = append(names, *user.LastName)
+	}
+	if len(names) == 0 {
+		return user.Email
+	}
+	return strings.Join(names, " ")
+}
+
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// fold splits a line in chunks of at most 75 octets without breaking utf-8 characters
+func fold(line string) string {
+	var folded strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > maxLineLength {
+			folded.WriteString("\r\n ")
+			length = 1
+		}
+		folded.WriteRune(r)
+		length += size
+	}
+	return folded.String()
+}
@@ -0,0 +1,125 @@
+package calendar
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	const dbPath = "../test_calendar.sqlite3"
+	os.Remove(dbPath)
+
+	db, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	if err := data_interface.Migrate("../assets/ddl.whosdriving-core", db); err != nil {
+		t.Fatalf("Migration error - %s", err)
+	}
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+
+	firstName, lastName := "John", "Smith"
+	for _, newUser := range []model.NewUser{
+		{Email: "john@domain.com", FirstName: &firstName, LastName: &lastName},
+		{Email: "test@domain.com"},
+		{Email: "other@domain.com"},
+	} {
+		user := newUser
+		_, err = data_interface.CreateUser(ctx, &lCtx, &user)
+		assert.Nil(t, err, "")
+	}
+
+	_, err = data_interface.CreateRotation(ctx, &lCtx, &model.NewRotation{
+		Name:              "Office, morning",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{"test@domain.com", "john@domain.com", "other@domain.com"},
+	})
+	assert.Nil(t, err, "")
+
+	rideDate := time.Date(2022, time.September, 5, 7, 45, 0, 0, time.UTC)
+	_, err = data_interface.AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Date:              &rideDate,
+		EmailConductor:    "john@domain.com",
+		EmailParticipants: []string{"john@domain.com", "test@domain.com"},
+	})
+	assert.Nil(t, err, "")
+	_, err = data_interface.AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Date:              &rideDate,
+		EmailConductor:    "other@domain.com",
+		EmailParticipants: []string{"other@domain.com"},
+	})
+	assert.Nil(t, err, "")
+
+	token, err := data_interface.GenerateCalendarToken(ctx, &lCtx, "test@domain.com")
+	assert.Nil(t, err, "")
+	_, err = data_interface.GenerateCalendarToken(ctx, &lCtx, "nobody@domain.com")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+
+	server := httptest.NewServer(Handler(db, "/calendar/"))
+	defer server.Close()
+
+	// unknown token
+	res, err := http.Get(server.URL + "/calendar/unknown.ics")
+	assert.Nil(t, err, "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = http.Get(server.URL + "/calendar/" + token + ".ics")
+	assert.Nil(t, err, "")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/calendar; charset=utf-8", res.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err, "")
+	feed := string(body)
+	t.Log(feed)
+
+	// only the ride of the user, with a stable uid
+	assert.Equal(t, 1, strings.Count(feed, "BEGIN:VEVENT"))
+	assert.Contains(t, feed, "UID:ride-1@whosdriving\r\n")
+	assert.Contains(t, feed, "DTSTART:20220905T074500Z\r\n")
+	assert.Contains(t, feed, "DTEND:20220905T081500Z\r\n")
+	assert.Contains(t, feed, "SUMMARY:Office\\, morning - John Smith drives\r\n")
+	assert.Contains(t, feed, "DESCRIPTION:Conductor: John Smith\\nPassengers: test@domain.com\r\n")
+	assert.Contains(t, feed, "STATUS:CONFIRMED\r\n")
+	assert.True(t, strings.HasSuffix(feed, "END:VCALENDAR\r\n"))
+}
+
+func TestFold(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("é", 60)
+	folded := fold(line)
+
+	for _, chunk := range strings.Split(folded, "\r\n") {
+		assert.LessOrEqual(t, len(chunk), maxLineLength)
+	}
+	assert.Equal(t, line, strings.ReplaceAll(folded, "\r\n ", ""))
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"whosdriving-be/backup"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+	"whosdriving-be/importer"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes of the commands
+const (
+	exitOK = 0
+	// The command failed, e.g. on a database error
+	exitFailure = 1
+	// Unknown command, invalid flags or arguments
+	exitUsage = 2
+	// The user or rotation doesn't exist
+	exitNotFound = 3
+	// The schema of the database has pending migrations
+	exitPending = 4
+)
+
+func usageError(format string, a ...interface{}) error {
+	return cli.Exit(fmt.Sprintf(format, a...), exitUsage)
+}
+
+// commandError keeps the exit codes set, and tells the missing records from the failures
+func commandError(err error) error {
+	var exitCoder cli.ExitCoder
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitCoder):
+		return err
+	case errors.Is(err, sql.ErrNoRows):
+		return cli.Exit("not found", exitNotFound)
+	default:
+		return cli.Exit(err.Error(), exitFailure)
+	}
+}
+
+// groupAction shows the help of a group of commands, it's given an unknown command otherwise
+func groupAction(c *cli.Context) error {
+	if c.NArg() > 0 {
+		return usageError("unknown command %s", c.Args().First())
+	}
+	return cli.ShowSubcommandHelp(c)
+}
+
+func onUsageError(c *cli.Context, err error, isSubcommand bool) error {
+	return usageError("%s", err)
+}
+
+// setUsageErrors gives the usage exit code to the parsing errors of the flags of the commands
+func setUsageErrors(commands []*cli.Command) {
+	for _, command := range commands {
+		command.OnUsageError = onUsageError
+		setUsageErrors(command.Subcommands)
+	}
+}
+
+// notFound tells the missing records from the other errors
+func notFound(err error, format string, a ...interface{}) error {
+	if err == sql.ErrNoRows {
+		return cli.Exit(fmt.Sprintf(format, a...)+" not found", exitNotFound)
+	}
+	return err
+}
+
+var dbFlag = &cli.StringFlag{
+	Name:    "db",
+	Usage:   "path of the database",
+	EnvVars: []string{"DB_PATH"},
+	Value:   defaultDbHostPath,
+}
+
+var ddlFlag = &cli.StringFlag{
+	Name:    "ddl",
+	Usage:   "path of the DDL creating the schema",
+	EnvVars: []string{"DDL_PATH"},
+	Value:   defaultDdlPath,
+}
+
+// openDb opens an existing database, sqlite would create a missing one
+func openDb(c *cli.Context) (*sql.DB, error) {
+	dbPath := c.String(dbFlag.Name)
+	if !checkFileExists(dbPath) {
+		return nil, cli.Exit("no database at "+dbPath, exitFailure)
+	}
+	return data_interface.NewConnection(dbPath)
+}
+
+// errRollback ends a transaction without committing nor failing
+var errRollback = errors.New("rollback")
+
+// inTx runs the function in a transaction committed unless it fails
+func inTx(c *cli.Context, f func(ctx context.Context, lCtx *data_interface.LuwContext) error) error {
+	db, err := openDb(c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := c.Context
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := f(ctx, &data_interface.LuwContext{Conn: db, Tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// printResult writes the result as JSON with --json, as text otherwise
+func printResult(c *cli.Context, result interface{}, text func(w io.Writer)) error {
+	if c.Bool("json") {
+		encoder := json.NewEncoder(c.App.Writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func printUsers(w io.Writer, users []*model.User) {
+	fmt.Fprintln(w, "EMAIL\tFIRST NAME\tLAST NAME\tROLE")
+	for _, user := range users {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", user.Email, stringValue(user.FirstName), stringValue(user.LastName), user.Role)
+	}
+}
+
+func printRotations(w io.Writer, rotations []*model.Rotation) {
+	fmt.Fprintln(w, "ID\tNAME\tCREATOR\tPARTICIPANTS\tRIDES")
+	for _, rotation := range rotations {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\n", rotation.ID, rotation.Name, rotation.Creator.Email, len(rotation.Participants), len(rotation.Rides))
+	}
+}
+
+type migrateStatus struct {
+	SchemaVersion int  `json:"schemaVersion"`
+	LatestVersion int  `json:"latestVersion"`
+	UpToDate      bool `json:"upToDate"`
+}
+
+func findMigrateStatus(db *sql.DB) (*migrateStatus, error) {
+	version, err := data_interface.FindSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	return &migrateStatus{
+		SchemaVersion: version,
+		LatestVersion: data_interface.SchemaVersion,
+		UpToDate:      version >= data_interface.SchemaVersion,
+	}, nil
+}
+
+// printMigrateStatus fails with exitPending when migrations are pending, for the scripts
+func printMigrateStatus(c *cli.Context, status *migrateStatus) error {
+	err := printResult(c, status, func(w io.Writer) {
+		state := "pending"
+		if status.UpToDate {
+			state = "up to date"
+		}
+		fmt.Fprintf(w, "Schema version %d of %d, %s\n", status.SchemaVersion, status.LatestVersion, state)
+	})
+	if err == nil && !status.UpToDate {
+		return cli.Exit("", exitPending)
+	}
+	return err
+}
+
+var migrateCommand = &cli.Command{
+	Name:   "migrate",
+	Usage:  "migrate the schema of the database",
+	Action: groupAction,
+	Subcommands: []*cli.Command{
+		{
+			Name:  "up",
+			Usage: "create or update the schema, the database is created when missing",
+			Flags: []cli.Flag{dbFlag, ddlFlag},
+			Action: func(c *cli.Context) error {
+				db, err := data_interface.NewConnection(c.String(dbFlag.Name))
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				if err := data_interface.Migrate(c.String(ddlFlag.Name), db); err != nil {
+					return err
+				}
+				status, err := findMigrateStatus(db)
+				if err != nil {
+					return err
+				}
+				return printMigrateStatus(c, status)
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "tell whether the schema is up to date",
+			Flags: []cli.Flag{dbFlag},
+			Action: func(c *cli.Context) error {
+				db, err := openDb(c)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				status, err := findMigrateStatus(db)
+				if err != nil {
+					return err
+				}
+				return printMigrateStatus(c, status)
+			},
+		},
+	},
+}
+
+// emailArg is the single argument of the user commands
+func emailArg(c *cli.Context) (string, error) {
+	if c.NArg() != 1 {
+		return "", usageError("expected the email of the user")
+	}
+	return c.Args().First(), nil
+}
+
+var userCommand = &cli.Command{
+	Name:   "user",
+	Usage:  "manage the users",
+	Action: groupAction,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "create",
+			Usage:     "create a user",
+			ArgsUsage: "<email>",
+			Flags: []cli.Flag{
+				dbFlag,
+				&cli.StringFlag{Name: "first-name"},
+				&cli.StringFlag{Name: "last-name"},
+				&cli.BoolFlag{Name: "admin", Usage: "with the ADMIN role"},
+			},
+			Action: func(c *cli.Context) error {
+				email, err := emailArg(c)
+				if err != nil {
+					return err
+				}
+				newUser := model.NewUser{Email: email}
+				if c.IsSet("first-name") {
+					firstName := c.String("first-name")
+					newUser.FirstName = &firstName
+				}
+				if c.IsSet("last-name") {
+					lastName := c.String("last-name")
+					newUser.LastName = &lastName
+				}
+
+				var user *model.User
+				err = inTx(c, func(ctx context.Context, lCtx *data_interface.LuwContext) error {
+					if _, err := data_interface.FindUser(ctx, lCtx, &email); err != sql.ErrNoRows {
+						if err == nil {
+							return cli.Exit("user "+email+" already exists", exitFailure)
+						}
+						return err
+					}
+					user, err = data_interface.CreateUser(ctx, lCtx, &newUser)
+					if err != nil || !c.Bool("admin") {
+						return err
+					}
+					user.Role = model.RoleAdmin
+					user, err = data_interface.UpdateUser(ctx, lCtx, user)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return printResult(c, user, func(w io.Writer) { printUsers(w, []*model.User{user}) })
+			},
+		},
+		{
+			Name:      "promote",
+			Usage:     "give a role to a user, ADMIN by default",
+			ArgsUsage: "<email>",
+			Flags: []cli.Flag{
+				dbFlag,
+				&cli.StringFlag{Name: "role", Value: model.RoleAdmin.String(), Usage: "ADMIN or STANDARD"},
+			},
+			Action: func(c *cli.Context) error {
+				email, err := emailArg(c)
+				if err != nil {
+					return err
+				}
+				role := model.Role(c.String("role"))
+				if role != model.RoleAdmin && role != model.RoleStandard {
+					return usageError("invalid role %s, expected ADMIN or STANDARD", role)
+				}
+
+				var user *model.User
+				err = inTx(c, func(ctx context.Context, lCtx *data_interface.LuwContext) error {
+					user, err = data_interface.FindUser(ctx, lCtx, &email)
+					if err != nil {
+						return notFound(err, "user %s", email)
+					}
+					user.Role = role
+					user, err = data_interface.UpdateUser(ctx, lCtx, user)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return printResult(c, user, func(w io.Writer) { printUsers(w, []*model.User{user}) })
+			},
+		},
+		{
+			Name:      "delete",
+			Usage:     "delete a user",
+			ArgsUsage: "<email>",
+			Flags:     []cli.Flag{dbFlag},
+			Action: func(c *cli.Context) error {
+				email, err := emailArg(c)
+				if err != nil {
+					return err
+				}
+
+				var user *model.User
+				err = inTx(c, func(ctx context.Context, lCtx *data_interface.LuwContext) error {
+					user, err = data_interface.FindUser(ctx, lCtx, &email)
+					if err != nil {
+						return notFound(err, "user %s", email)
+					}
+					user, err = data_interface.DeleteUser(ctx, lCtx, user)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return printResult(c, user, func(w io.Writer) { fmt.Fprintf(w, "Deleted %s\n", user.Email) })
+			},
+		},
+	},
+}
+
+var rotationCommand = &cli.Command{
+	Name:   "rotation",
+	Usage:  "look at the rotations",
+	Action: groupAction,
+	Subcommands: []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "list the rotations",
+			Flags: []cli.Flag{
+				dbFlag,
+				&cli.StringFlag{Name: "creator", Usage: "only the rotations created by this email"},
+			},
+			Action: func(c *cli.Context) error {
+				var rotations []*model.Rotation
+				err := inTx(c, func(ctx context.Context, lCtx *data_interface.LuwContext) error {
+					var err error
+					if c.IsSet("creator") {
+						creator := c.String("creator")
+						rotations, err = data_interface.FindRotations(ctx, lCtx, &creator)
+					} else {
+						rotations, err = data_interface.FindAllRotations(ctx, lCtx)
+					}
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return printResult(c, rotations, func(w io.Writer) { printRotations(w, rotations) })
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "show a rotation with its participants and rides",
+			ArgsUsage: "<id>",
+			Flags:     []cli.Flag{dbFlag},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return usageError("expected the id of the rotation")
+				}
+				id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+				if err != nil {
+					return usageError("invalid rotation id %s", c.Args().First())
+				}
+
+				var rotation *model.Rotation
+				err = inTx(c, func(ctx context.Context, lCtx *data_interface.LuwContext) error {
+					rotation, err = data_interface.FindRotation(ctx, lCtx, id)
+					return notFound(err, "rotation %d", id)
+				})
+				if err != nil {
+					return err
+				}
+				return printResult(c, rotation, func(w io.Writer) {
+					printRotations(w, []*model.Rotation{rotation})
+					fmt.Fprintln(w)
+					printUsers(w, rotation.Participants)
+					fmt.Fprintln(w)
+					fmt.Fprintln(w, "RIDE\tDATE\tSTATUS\tDRIVER\tPASSENGERS")
+					for _, ride := range rotation.Rides {
+						fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\n", ride.ID, ride.Date.Format(time.RFC3339), ride.Status, ride.Conductor.Email, len(ride.Participants))
+					}
+				})
+			},
+		},
+	},
+}
+
+type backupResult struct {
+	Path string `json:"path"`
+}
+
+var backupCommand = &cli.Command{
+	Name:      "backup",
+	Usage:     "copy the database while it's in use, to the backup directory without path",
+	ArgsUsage: "[<path>]",
+	Flags: []cli.Flag{
+		dbFlag,
+		&cli.StringFlag{Name: "dir", Usage: "backup directory", EnvVars: []string{"BACKUP_DIR"}, Value: defaultBackupDir},
+		&cli.IntFlag{Name: "retain", Usage: "snapshots kept in the backup directory", EnvVars: []string{"BACKUP_RETAIN"}, Value: defaultBackupRetain},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() > 1 {
+			return usageError("expected the path of the backup at most")
+		}
+
+		db, err := openDb(c)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		path := c.Args().First()
+		if path != "" {
+			err = data_interface.Backup(c.Context, db, path)
+		} else {
+			var backups *backup.Store
+			backups, err = backup.NewStore(c.String("dir"), c.Int("retain"))
+			if err != nil {
+				return usageError("%s", err)
+			}
+			var created *model.Backup
+			if created, err = backups.Create(c.Context, db, time.Now()); err == nil {
+				path = backups.Path(created.Name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return printResult(c, backupResult{Path: path}, func(w io.Writer) { fmt.Fprintf(w, "Backed up to %s\n", path) })
+	},
+}
+
+type restoreResult struct {
+	Backup string `json:"backup"`
+	// Path of the replaced database, none when there was none or on a dry run
+	Replaced *string `json:"replaced"`
+	DryRun   bool    `json:"dryRun"`
+}
+
+var restoreCommand = &cli.Command{
+	Name:      "restore",
+	Usage:     "replace the database by a backup once verified, the server must be stopped",
+	ArgsUsage: "<backup>",
+	Flags: []cli.Flag{
+		dbFlag,
+		&cli.BoolFlag{Name: "dry-run", Usage: "only verify the backup"},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return usageError("expected the path of the backup")
+		}
+		result := restoreResult{Backup: c.Args().First(), DryRun: c.Bool("dry-run")}
+
+		if result.DryRun {
+			if err := backup.Verify(result.Backup); err != nil {
+				return err
+			}
+		} else {
+			replaced, err := backup.Restore(c.String(dbFlag.Name), result.Backup, time.Now())
+			if err != nil {
+				return err
+			}
+			if replaced != "" {
+				result.Replaced = &replaced
+			}
+		}
+		return printResult(c, result, func(w io.Writer) {
+			switch {
+			case result.DryRun:
+				fmt.Fprintf(w, "%s can be restored\n", result.Backup)
+			case result.Replaced != nil:
+				fmt.Fprintf(w, "Restored %s, the replaced database is %s\n", result.Backup, *result.Replaced)
+			default:
+				fmt.Fprintf(w, "Restored %s\n", result.Backup)
+			}
+		})
+	},
+}
+
+var importCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "record the historical rides of a CSV or JSON file in a rotation, all or nothing",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		dbFlag,
+		&cli.Int64Flag{Name: "rotation", Usage: "id of the rotation"},
+		&cli.StringFlag{Name: "format", Usage: "CSV or JSON, from the file extension by default"},
+		&cli.BoolFlag{Name: "dry-run", Usage: "only report the errors"},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 || !c.IsSet("rotation") {
+			return usageError("expected the file of the rides and --rotation")
+		}
+		path := c.Args().First()
+		format, err := importer.FormatOf(path)
+		if c.IsSet("format") {
+			format = model.ImportFormat(strings.ToUpper(c.String("format")))
+			if !format.IsValid() {
+				return usageError("invalid format %s, expected CSV or JSON", c.String("format"))
+			}
+		} else if err != nil {
+			return usageError("%s, use --format", err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		var report *model.RidesImport
+		err = inTx(c, func(ctx context.Context, lCtx *data_interface.LuwContext) error {
+			report, err = importer.Import(ctx, lCtx, c.Int64("rotation"), format, file)
+			if err != nil {
+				return notFound(err, "rotation %d", c.Int64("rotation"))
+			}
+			report.DryRun = c.Bool("dry-run")
+			if report.DryRun || len(report.Errors) > 0 {
+				return errRollback
+			}
+			return nil
+		})
+		if err != nil && err != errRollback {
+			return err
+		}
+
+		if err := printResult(c, report, func(w io.Writer) {
+			for _, rowError := range report.Errors {
+				fmt.Fprintf(w, "Row %d\t%s\n", rowError.Row, rowError.Message)
+			}
+			switch {
+			case len(report.Errors) > 0:
+				fmt.Fprintf(w, "%d errors in %d rows, nothing imported\n", len(report.Errors), report.Rows)
+			case report.DryRun:
+				fmt.Fprintf(w, "%d rides can be imported, creating %d users\n", report.Imported, len(report.CreatedUsers))
+			default:
+				fmt.Fprintf(w, "Imported %d rides, created %d users\n", report.Imported, len(report.CreatedUsers))
+			}
+		}); err != nil {
+			return err
+		}
+		if len(report.Errors) > 0 {
+			return cli.Exit("", exitFailure)
+		}
+		return nil
+	},
+}
+
+// export is the content of the database written by the export command
+type export struct {
+	ExportedAt    time.Time         `json:"exportedAt"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Rotations     []*model.Rotation `json:"rotations"`
+}
+
+var exportCommand = &cli.Command{
+	Name:  "export",
+	Usage: "write the rotations with their participants, rides and schedules as JSON",
+	Flags: []cli.Flag{
+		dbFlag,
+		&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Usage: "file written, the standard output otherwise"},
+	},
+	Action: func(c *cli.Context) error {
+		exported := export{ExportedAt: time.Now().UTC()}
+		err := inTx(c, func(ctx context.Context, lCtx *data_interface.LuwContext) error {
+			var err error
+			if exported.SchemaVersion, err = data_interface.FindSchemaVersion(lCtx.Conn); err != nil {
+				return err
+			}
+			exported.Rotations, err = data_interface.FindAllRotations(ctx, lCtx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		content, err := json.MarshalIndent(exported, "", "  ")
+		if err != nil {
+			return err
+		}
+		if !c.IsSet("output") {
+			_, err = fmt.Fprintln(c.App.Writer, string(content))
+			return err
+		}
+		return ioutil.WriteFile(c.String("output"), content, 0600)
+	},
+}
+
+var serveCommand = &cli.Command{
+	Name:  "serve",
+	Usage: "serve the GraphQL API, configured by the environment",
+	Action: func(c *cli.Context) error {
+		serve(createConfig())
+		return nil
+	},
+}
+
+func newApp(stdout io.Writer, stderr io.Writer) *cli.App {
+	commands := []*cli.Command{serveCommand, migrateCommand, userCommand, rotationCommand, backupCommand, restoreCommand, importCommand, exportCommand}
+	setUsageErrors(commands)
+	return &cli.App{
+		Name:  "whosdriving-be",
+		Usage: "carpooling rotations",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "json", Usage: "write the results as JSON"},
+			&cli.BoolFlag{Name: "verbose", Usage: "log the database accesses of the commands"},
+		},
+		Commands:        commands,
+		HideHelpCommand: true,
+		Writer:          stdout,
+		ErrWriter:       stderr,
+		Before: func(c *cli.Context) error {
+			// The logs of the data access would mix with the results, the server keeps them
+			if !c.Bool("verbose") && c.Args().First() != "" && c.Args().First() != serveCommand.Name {
+				log.SetOutput(ioutil.Discard)
+			}
+			return nil
+		},
+		// Without command the server is started
+		Action: func(c *cli.Context) error {
+			if c.NArg() > 0 {
+				return usageError("unknown command %s", c.Args().First())
+			}
+			return serveCommand.Action(c)
+		},
+		OnUsageError: onUsageError,
+		// The exit codes are returned by run
+		ExitErrHandler: func(c *cli.Context, err error) {},
+	}
+}
+
+// run executes the command of the arguments and returns its exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	err := newApp(stdout, stderr).Run(args)
+	if err == nil {
+		return exitOK
+	}
+
+	var exitCoder cli.ExitCoder
+	errors.As(commandError(err), &exitCoder)
+	if exitCoder.Error() != "" {
+		fmt.Fprintf(stderr, "Error: %s\n", exitCoder)
+	}
+	return exitCoder.ExitCode()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runCommand runs the command with --json and decodes its output in result
+func runCommand(t *testing.T, result interface{}, args ...string) int {
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"whosdriving-be", "--json"}, args...), &stdout, &stderr)
+	if code == exitOK && result != nil {
+		if err := json.Unmarshal(stdout.Bytes(), result); err != nil {
+			t.Fatalf("Invalid output of %v - %s: %s", args, err, stdout.String())
+		}
+	}
+	return code
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "cli.sqlite3")
+	db := "--db=" + dbPath
+
+	assert.Equal(t, exitFailure, runCommand(t, nil, "migrate", "status", db), "No database yet")
+	empty, err := data_interface.NewConnection(dbPath)
+	assert.Nil(t, err, "")
+	empty.Close()
+	assert.Equal(t, exitPending, runCommand(t, nil, "migrate", "status", db), "Never migrated")
+	var status migrateStatus
+	assert.Equal(t, exitOK, runCommand(t, &status, "migrate", "up", db, "--ddl=assets/ddl.whosdriving-core"))
+	assert.Equal(t, migrateStatus{SchemaVersion: data_interface.SchemaVersion, LatestVersion: data_interface.SchemaVersion, UpToDate: true}, status)
+
+	var user model.User
+	assert.Equal(t, exitOK, runCommand(t, &user, "user", "create", db, "--first-name=John", "john.doe@domain.com"))
+	assert.Equal(t, "John", *user.FirstName)
+	assert.Equal(t, model.RoleStandard, user.Role)
+	assert.Equal(t, exitFailure, runCommand(t, nil, "user", "create", db, "john.doe@domain.com"))
+	assert.Equal(t, exitOK, runCommand(t, &user, "user", "promote", db, "john.doe@domain.com"))
+	assert.Equal(t, model.RoleAdmin, user.Role)
+	assert.Equal(t, exitNotFound, runCommand(t, nil, "user", "promote", db, "jane.doe@domain.com"))
+
+	// Usage errors
+	assert.Equal(t, exitUsage, runCommand(t, nil, "user", "promote", db, "--role=UNREGISTRED", "john.doe@domain.com"))
+	assert.Equal(t, exitUsage, runCommand(t, nil, "user", "create", db))
+	assert.Equal(t, exitUsage, runCommand(t, nil, "user", "create", "--unknown", db, "jane.doe@domain.com"))
+	assert.Equal(t, exitUsage, runCommand(t, nil, "user", "rename"))
+	assert.Equal(t, exitUsage, runCommand(t, nil, "rename"))
+	assert.Equal(t, exitUsage, runCommand(t, nil, "rotation", "show", db, "first"))
+
+	conn, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	lCtx := data_interface.LuwContext{Conn: conn, Tx: tx}
+	_, err = data_interface.CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "Office", EmailCreator: "john.doe@domain.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Commit())
+	conn.Close()
+
+	var rotations []*model.Rotation
+	assert.Equal(t, exitOK, runCommand(t, &rotations, "rotation", "list", db))
+	assert.Equal(t, 1, len(rotations))
+	assert.Equal(t, "Office", rotations[0].Name)
+	var rotation model.Rotation
+	assert.Equal(t, exitOK, runCommand(t, &rotation, "rotation", "show", db, "1"))
+	assert.Equal(t, "john.doe@domain.com", rotation.Creator.Email)
+	assert.Equal(t, exitNotFound, runCommand(t, nil, "rotation", "show", db, "2"))
+
+	var exported export
+	assert.Equal(t, exitOK, runCommand(t, &exported, "export", db))
+	assert.Equal(t, data_interface.SchemaVersion, exported.SchemaVersion)
+	assert.Equal(t, 1, len(exported.Rotations))
+
+	backupPath := filepath.Join(dir, "backup.sqlite3")
+	assert.Equal(t, exitOK, runCommand(t, nil, "backup", db, backupPath))
+	assert.Equal(t, exitFailure, runCommand(t, nil, "backup", db, backupPath), "Backups aren't overwritten")
+	assert.Equal(t, exitOK, runCommand(t, &rotations, "rotation", "list", "--db="+backupPath))
+	assert.Equal(t, 1, len(rotations))
+
+	var backedUp backupResult
+	assert.Equal(t, exitOK, runCommand(t, &backedUp, "backup", db, "--dir="+filepath.Join(dir, "backups"), "--retain=1"))
+	assert.Equal(t, filepath.Join(dir, "backups"), filepath.Dir(backedUp.Path))
+
+	assert.Equal(t, exitOK, runCommand(t, nil, "user", "delete", db, "john.doe@domain.com"))
+	assert.Equal(t, exitNotFound, runCommand(t, nil, "user", "delete", db, "john.doe@domain.com"))
+
+	var restored restoreResult
+	assert.Equal(t, exitOK, runCommand(t, &restored, "restore", db, "--dry-run", backupPath))
+	assert.Nil(t, restored.Replaced)
+	assert.Equal(t, exitFailure, runCommand(t, nil, "restore", db, filepath.Join(dir, "missing.sqlite3")))
+	assert.Equal(t, exitOK, runCommand(t, &restored, "restore", db, backupPath))
+	assert.NotNil(t, restored.Replaced)
+	assert.Equal(t, exitOK, runCommand(t, &user, "user", "promote", db, "john.doe@domain.com"), "Back from the backup")
+}
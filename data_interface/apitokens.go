@@ -0,0 +1,201 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+// Tells the API tokens apart from the other secrets, e.g. for secret scanners
+const apiTokenPrefix = "wdp_"
+
+// The last use of a token is only recorded again after this period
+const apiTokenUseResolution = time.Minute
+
+func FindAPIToken(ctx context.Context, lCtx *LuwContext, id int64) (*model.APIToken, error) {
+	const q string = `select t.id, t.name, t.createTmstmp, t.lastUsedTmstmp, t.expireTmstmp
+						from ApiTokens t
+						where t.id = ? and t.revokeTmstmp is null`
+
+	apiToken := new(model.APIToken)
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&apiToken.ID,
+		&apiToken.Name,
+		&apiToken.CreatedAt,
+		&apiToken.LastUsedAt,
+		&apiToken.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	scopes, err := findAPITokenScopes(ctx, lCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	apiToken.Scopes = scopes
+	return apiToken, nil
+}
+
+func findAPITokenScopes(ctx context.Context, lCtx *LuwContext, id int64) ([]model.TokenScope, error) {
+	const q string = `select r.RefName
+						from ApiTokenScopes s join RefTokenScope r on s.scopeCd = r.RefCd
+						where s.tokenId = ?
+						order by r.RefCd`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scopes := make([]model.TokenScope, 0)
+	for rows.Next() {
+		var scope model.TokenScope
+		if err := rows.Scan(&scope); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, rows.Err()
+}
+
+// FindAPITokenOwner returns the email of the user of an API token
+func FindAPITokenOwner(ctx context.Context, lCtx *LuwContext, id int64) (string, error) {
+	const q string = `select email from ApiTokens where id = ? and revokeTmstmp is null`
+
+	var email string
+	err := lCtx.Tx.QueryRowContext(ctx, q, id).Scan(&email)
+	return email, err
+}
+
+// FindAPITokens lists the tokens of the user still usable at now
+func FindAPITokens(ctx context.Context, lCtx *LuwContext, email string, now time.Time) ([]*model.APIToken, error) {
+	const q string = `select t.id
+						from ApiTokens t
+						where t.email = ? and t.revokeTmstmp is null and (t.expireTmstmp is null or t.expireTmstmp > ?)
+						order by t.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, email, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiTokenIds := make([]int64, 0)
+	for rows.Next() {
+		var apiTokenId int64
+		if err := rows.Scan(&apiTokenId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		apiTokenIds = append(apiTokenIds, apiTokenId)
+	}
+	rows.Close()
+
+	apiTokens := make([]*model.APIToken, 0, len(apiTokenIds))
+	for _, apiTokenId := range apiTokenIds {
+		apiToken, err := FindAPIToken(ctx, lCtx, apiTokenId)
+		if err != nil {
+			return nil, err
+		}
+		apiTokens = append(apiTokens, apiToken)
+	}
+
+	return apiTokens, nil
+}
+
+// CreateAPIToken returns the token of the user to use as bearer, only its hash is stored
+func CreateAPIToken(ctx context.Context, lCtx *LuwContext, email string, newAPIToken *model.NewAPIToken) (*model.GeneratedAPIToken, error) {
+	const qToken string = `INSERT INTO ApiTokens(email, name, tokenHash, expireTmstmp, lastUsedTmstmp, createTmstmp, revokeTmstmp)
+						VALUES (?, ?, ?, ?, null, DATETIME('now'), null)`
+	const qScope string = `INSERT OR IGNORE INTO ApiTokenScopes(tokenId, scopeCd)
+						VALUES (?, (select RefCd from RefTokenScope where RefName=?))`
+
+	if strings.TrimSpace(newAPIToken.Name) == "" {
+		return nil, fmt.Errorf("an API token needs a name")
+	}
+	if len(newAPIToken.Scopes) == 0 {
+		return nil, fmt.Errorf("an API token needs a scope")
+	}
+	var expireTmstmp *time.Time
+	if newAPIToken.ValidDays != nil {
+		if *newAPIToken.ValidDays < 1 {
+			return nil, fmt.Errorf("an API token is valid at least one day")
+		}
+		expire := time.Now().UTC().Truncate(time.Second).AddDate(0, 0, *newAPIToken.ValidDays)
+		expireTmstmp = &expire
+	}
+
+	raw, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	token := apiTokenPrefix + raw
+
+	res, err := lCtx.Tx.ExecContext(ctx, qToken, email, newAPIToken.Name, hashToken(token), expireTmstmp)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range newAPIToken.Scopes {
+		if _, err := lCtx.Tx.ExecContext(ctx, qScope, id, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Create API token %s of %s assign id %d", newAPIToken.Name, email, id)
+	apiToken, err := FindAPIToken(ctx, lCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &model.GeneratedAPIToken{Token: token, APIToken: apiToken}, nil
+}
+
+func RevokeAPIToken(ctx context.Context, lCtx *LuwContext, apiToken *model.APIToken) (*model.APIToken, error) {
+	const q string = `UPDATE ApiTokens set revokeTmstmp=DATETIME('now') WHERE id=? and revokeTmstmp is null`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, apiToken.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Revoke API token id %d", apiToken.ID)
+	return apiToken, nil
+}
+
+// AuthenticateAPIToken returns the user and the scopes of a token usable at now and records its use,
+// sql.ErrNoRows when unknown, revoked or expired
+func AuthenticateAPIToken(ctx context.Context, lCtx *LuwContext, token string, now time.Time) (string, []model.TokenScope, error) {
+	const qFind string = `select t.id, t.email
+						from ApiTokens t join Users u on u.email = t.email
+						where t.tokenHash = ? and t.revokeTmstmp is null and (t.expireTmstmp is null or t.expireTmstmp > ?)
+						and u.deleteTmstmp is null`
+	const qUse string = `UPDATE ApiTokens set lastUsedTmstmp=? WHERE id=? and (lastUsedTmstmp is null or lastUsedTmstmp <= ?)`
+
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return "", nil, sql.ErrNoRows
+	}
+
+	var id int64
+	var email string
+	now = now.UTC().Truncate(time.Second)
+	if err := lCtx.Tx.QueryRowContext(ctx, qFind, hashToken(token), now).Scan(&id, &email); err != nil {
+		return "", nil, err
+	}
+	if _, err := lCtx.Tx.ExecContext(ctx, qUse, now, id, now.Add(-apiTokenUseResolution)); err != nil {
+		return "", nil, err
+	}
+
+	scopes, err := findAPITokenScopes(ctx, lCtx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return email, scopes, nil
+}
@@ -0,0 +1,221 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+// Covers tells if the availability applies at a given instant
+func availabilityCovers(availability *model.Availability, at time.Time) bool {
+	if availability.From != nil && at.Before(*availability.From) {
+		return false
+	}
+	if availability.To != nil && at.After(*availability.To) {
+		return false
+	}
+	if len(availability.Days) == 0 {
+		return true
+	}
+
+	loc, err := time.LoadLocation(availability.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	weekday := at.In(loc).Weekday()
+	for _, day := range availability.Days {
+		if weekdayTimes[day] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+func FindAvailability(ctx context.Context, lCtx *LuwContext, id int64) (*model.Availability, error) {
+	const q string = `select a.id, a.email, k.RefName, a.fromDate, a.toDate, a.byDay, a.timezone, a.reason
+						from Availabilities a left join RefAvailabilityKind k on a.kindCd = k.RefCd
+						where a.id = ? and a.deleteTmstmp is null`
+
+	availability := new(model.Availability)
+	var email string
+	var byDay sql.NullString
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&availability.ID,
+		&email,
+		&availability.Kind,
+		&availability.From,
+		&availability.To,
+		&byDay,
+		&availability.Timezone,
+		&availability.Reason); err != nil {
+		return nil, err
+	}
+	if byDay.Valid {
+		availability.Days = decodeByDay(byDay.String)
+	}
+
+	user, err := FindUser(ctx, lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+	availability.User = user
+
+	return availability, nil
+}
+
+func findAvailabilities(ctx context.Context, lCtx *LuwContext, q string, args ...interface{}) ([]*model.Availability, error) {
+	rows, err := lCtx.Tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	availabilityIds := make([]int64, 0)
+	for rows.Next() {
+		var availabilityId int64
+		if err := rows.Scan(&availabilityId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		availabilityIds = append(availabilityIds, availabilityId)
+	}
+	rows.Close()
+
+	availabilities := make([]*model.Availability, 0, len(availabilityIds))
+	for _, availabilityId := range availabilityIds {
+		availability, err := FindAvailability(ctx, lCtx, availabilityId)
+		if err != nil {
+			return nil, err
+		}
+		availabilities = append(availabilities, availability)
+	}
+
+	return availabilities, nil
+}
+
+// FindUserAvailabilities lists the availabilities of a user overlapping [from, to]
+func FindUserAvailabilities(ctx context.Context, lCtx *LuwContext, email string, from time.Time, to time.Time) ([]*model.Availability, error) {
+	const q string = `select a.id
+						from Availabilities a
+						where a.email = ? and a.deleteTmstmp is null
+						and (a.fromDate is null or a.fromDate <= ?)
+						and (a.toDate is null or a.toDate >= ?)
+						order by a.fromDate, a.id`
+
+	return findAvailabilities(ctx, lCtx, q, email, to.UTC(), from.UTC())
+}
+
+// FindRotationAvailabilities lists the availabilities of the rotation participants overlapping [from, to]
+func FindRotationAvailabilities(ctx context.Context, lCtx *LuwContext, rotationId int64, from time.Time, to time.Time) ([]*model.Availability, error) {
+	const q string = `select a.id
+						from Availabilities a join RotationParticipants p on p.email = a.email
+						where p.rotationId = ? and a.deleteTmstmp is null
+						and (a.fromDate is null or a.fromDate <= ?)
+						and (a.toDate is null or a.toDate >= ?)
+						order by a.email, a.fromDate, a.id`
+
+	return findAvailabilities(ctx, lCtx, q, rotationId, to.UTC(), from.UTC())
+}
+
+// IsUnavailable tells if the user is absent, or when one of kinds is given is unavailable for one of them
+func IsUnavailable(ctx context.Context, lCtx *LuwContext, email string, at time.Time, kinds ...model.AvailabilityKind) (bool, error) {
+	availabilities, err := FindUserAvailabilities(ctx, lCtx, email, at, at)
+	if err != nil {
+		return false, err
+	}
+
+	for _, availability := range availabilities {
+		if !availabilityCovers(availability, at) {
+			continue
+		}
+		if len(kinds) == 0 {
+			return true, nil
+		}
+		for _, kind := range kinds {
+			if availability.Kind == kind {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func CreateAvailability(ctx context.Context, lCtx *LuwContext, newAvailability *model.NewAvailability) (*model.Availability, error) {
+	const q string = `INSERT INTO Availabilities(email, kindCd, fromDate, toDate, byDay, timezone, reason, createTmstmp, lstUpdTmstmp, deleteTmstmp)
+						VALUES (?, (select RefCd from RefAvailabilityKind where RefName=?), ?, ?, ?, ?, ?, DATETIME('now'), DATETIME('now'), null)`
+
+	if newAvailability.From == nil && newAvailability.To == nil && len(newAvailability.Days) == 0 {
+		return nil, fmt.Errorf("an availability needs a date range or days")
+	}
+	if newAvailability.From != nil && newAvailability.To != nil && newAvailability.To.Before(*newAvailability.From) {
+		return nil, fmt.Errorf("availability ends before it starts")
+	}
+
+	var from, to *time.Time
+	if newAvailability.From != nil {
+		utcFrom := newAvailability.From.UTC()
+		from = &utcFrom
+	}
+	if newAvailability.To != nil {
+		utcTo := newAvailability.To.UTC()
+		to = &utcTo
+	}
+
+	var byDay *string
+	if len(newAvailability.Days) > 0 {
+		days, err := encodeByDay(newAvailability.Days)
+		if err != nil {
+			return nil, err
+		}
+		byDay = &days
+	}
+
+	timezone := defaultTimezone
+	if newAvailability.Timezone != nil {
+		timezone = *newAvailability.Timezone
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, err
+	}
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, newAvailability.Email, newAvailability.Kind, from, to, byDay, timezone, newAvailability.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create availability %s for %s assign id %d", newAvailability.Kind, newAvailability.Email, id)
+	return FindAvailability(ctx, lCtx, id)
+}
+
+func DeleteAvailability(ctx context.Context, lCtx *LuwContext, availability *model.Availability) (*model.Availability, error) {
+	const q string = `UPDATE Availabilities set lstUpdTmstmp=DATETIME('now'), deleteTmstmp=DATETIME('now')
+				WHERE id=? and deleteTmstmp is null`
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, availability.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Delete availability id %d", availability.ID)
+	return availability, nil
+}
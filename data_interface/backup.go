@@ -0,0 +1,20 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// Backup writes a consistent copy of the database to path while it stays in use
+func Backup(ctx context.Context, db *sql.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	}
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return err
+	}
+	return nil
+}
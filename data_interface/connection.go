@@ -2,11 +2,17 @@ package data_interface
 
 import (
 	"database/sql"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"strings"
 )
 
+// SchemaVersion is the version of the schema created by the DDL, to increase with each change of it
+// along with a step of the migrations upgrading the existing databases.
+// Migrate records it in the user_version of the database.
+const SchemaVersion = 2
+
 type LuwContext struct {
 	Conn *sql.DB
 	Tx   *sql.Tx
@@ -42,6 +48,23 @@ func Migrate(ddlPath string, db *sql.DB) error {
 	}
 	defer tx.Rollback()
 
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		log.Printf("Error: Could not read the schema version - %s", err)
+		return err
+	}
+	var pending []migration
+	if version < SchemaVersion {
+		pending = migrations[version:]
+	}
+
+	for i := range pending {
+		if err := pending[i].addColumns(tx); err != nil {
+			log.Printf("Error: Could not add the columns of the schema version %d - %s", version+i+1, err)
+			return err
+		}
+	}
+
 	// Here we searching for ; to split the file in commands
 	for _, chunk := range strings.Split(string(file), ";") {
 		var commandChunks []string
@@ -73,6 +96,18 @@ func Migrate(ddlPath string, db *sql.DB) error {
 		}
 	}
 
+	for i := range pending {
+		if err := pending[i].backfill(tx); err != nil {
+			log.Printf("Error: Could not backfill the schema version %d - %s", version+i+1, err)
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		log.Printf("Error: Could not set the schema version - %s", err)
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("Error: Could not commit - %s", err)
 		return err
@@ -80,3 +115,12 @@ func Migrate(ddlPath string, db *sql.DB) error {
 
 	return nil
 }
+
+// FindSchemaVersion is the version of the schema of the database, 0 when it was never migrated since versioned
+func FindSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,537 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+const defaultCurrency = "EUR"
+
+// Amounts are stored in cents to keep the ledger balanced
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// splitCents shares total in parts equal to the cent, the first parts take the remainder
+func splitCents(total int64, parts int) []int64 {
+	shares := make([]int64, parts)
+	for i := range shares {
+		shares[i] = total / int64(parts)
+		if int64(i) < total%int64(parts) {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+func SetRotationDistance(ctx context.Context, lCtx *LuwContext, rotationId int64, distanceKm *float64) (*model.Rotation, error) {
+	const q string = `UPDATE Rotations set distanceKm=?, lstUpdTmstmp=DATETIME('now')
+				WHERE id=? and deleteTmstmp is null`
+
+	if distanceKm != nil && *distanceKm < 0 {
+		return nil, fmt.Errorf("distance can't be negative")
+	}
+
+	if _, err := lCtx.Tx.ExecContext(ctx, q, distanceKm, rotationId); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Set distance of rotation id %d", rotationId)
+	return FindRotation(ctx, lCtx, rotationId)
+}
+
+// FindCostRule returns the rule of the rotation, NONE when it never defined one
+func FindCostRule(ctx context.Context, lCtx *LuwContext, rotationId int64) (*model.CostRule, error) {
+	const q string = `select k.RefName, c.amountCents, c.fuelPriceCents, c.currency
+						from CostRules c left join RefCostRuleKind k on c.kindCd = k.RefCd
+						where c.rotationId = ?`
+
+	costRule, err := findCostRule(ctx, lCtx, q, rotationId)
+	if err == sql.ErrNoRows {
+		return &model.CostRule{Kind: model.CostRuleKindNone, Currency: defaultCurrency}, nil
+	}
+	return costRule, err
+}
+
+func findCostRule(ctx context.Context, lCtx *LuwContext, q string, id int64) (*model.CostRule, error) {
+	costRule := new(model.CostRule)
+	var amountCents, fuelPriceCents sql.NullInt64
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&costRule.Kind,
+		&amountCents,
+		&fuelPriceCents,
+		&costRule.Currency); err != nil {
+		return nil, err
+	}
+
+	if amountCents.Valid {
+		amount := fromCents(amountCents.Int64)
+		costRule.Amount = &amount
+	}
+	if fuelPriceCents.Valid {
+		fuelPrice := fromCents(fuelPriceCents.Int64)
+		costRule.FuelPrice = &fuelPrice
+	}
+
+	return costRule, nil
+}
+
+// SetCostRule replaces the rule of the rotation, it applies to the rides recorded from now on,
+// the recorded rides keep the rule they were first costed with
+func SetCostRule(ctx context.Context, lCtx *LuwContext, newCostRule *model.NewCostRule) (*model.CostRule, error) {
+	const q string = `INSERT INTO CostRules(rotationId, kindCd, amountCents, fuelPriceCents, currency, createTmstmp, lstUpdTmstmp)
+						VALUES (?, (select RefCd from RefCostRuleKind where RefName=?), ?, ?, ?, DATETIME('now'), DATETIME('now'))
+						ON CONFLICT(rotationId) DO UPDATE SET kindCd=excluded.kindCd, amountCents=excluded.amountCents,
+							fuelPriceCents=excluded.fuelPriceCents, currency=excluded.currency, lstUpdTmstmp=DATETIME('now')`
+
+	var amountCents, fuelPriceCents *int64
+	switch newCostRule.Kind {
+	case model.CostRuleKindFixedPerRide:
+		if newCostRule.Amount == nil || *newCostRule.Amount < 0 {
+			return nil, fmt.Errorf("a fixed cost rule needs a positive amount")
+		}
+	case model.CostRuleKindPerKm:
+		if newCostRule.FuelPrice == nil || *newCostRule.FuelPrice < 0 {
+			return nil, fmt.Errorf("a per km cost rule needs a positive fuel price")
+		}
+	}
+	if newCostRule.Amount != nil {
+		cents := toCents(*newCostRule.Amount)
+		amountCents = &cents
+	}
+	if newCostRule.FuelPrice != nil {
+		cents := toCents(*newCostRule.FuelPrice)
+		fuelPriceCents = &cents
+	}
+
+	currency := defaultCurrency
+	if newCostRule.Currency != nil {
+		currency = *newCostRule.Currency
+	}
+
+	if _, err := lCtx.Tx.ExecContext(ctx, q, newCostRule.IDRotation, newCostRule.Kind, amountCents, fuelPriceCents, currency); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Set %s cost rule for rotation %d", newCostRule.Kind, newCostRule.IDRotation)
+	return FindCostRule(ctx, lCtx, int64(newCostRule.IDRotation))
+}
+
+// rideCostRule is the rule the ride was first costed with, the current rule of the rotation
+// is kept for the ride when it was never costed
+func rideCostRule(ctx context.Context, lCtx *LuwContext, rotationId int64, rideId int64) (*model.CostRule, error) {
+	const q string = `select k.RefName, c.amountCents, c.fuelPriceCents, c.currency
+						from RideCostRules c left join RefCostRuleKind k on c.kindCd = k.RefCd
+						where c.rideId = ?`
+	const qInsert string = `INSERT INTO RideCostRules(rideId, kindCd, amountCents, fuelPriceCents, currency, createTmstmp)
+						VALUES (?, (select RefCd from RefCostRuleKind where RefName=?), ?, ?, ?, DATETIME('now'))`
+
+	costRule, err := findCostRule(ctx, lCtx, q, rideId)
+	if err != sql.ErrNoRows {
+		return costRule, err
+	}
+
+	costRule, err = FindCostRule(ctx, lCtx, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	var amountCents, fuelPriceCents *int64
+	if costRule.Amount != nil {
+		cents := toCents(*costRule.Amount)
+		amountCents = &cents
+	}
+	if costRule.FuelPrice != nil {
+		cents := toCents(*costRule.FuelPrice)
+		fuelPriceCents = &cents
+	}
+	if _, err := lCtx.Tx.ExecContext(ctx, qInsert, rideId, costRule.Kind, amountCents, fuelPriceCents, costRule.Currency); err != nil {
+		return nil, err
+	}
+	return costRule, nil
+}
+
+// rideCostCents is the cost of a ride according to the rule, 0 when it can't be costed
+func rideCostCents(costRule *model.CostRule, ride *model.Ride) int64 {
+	switch costRule.Kind {
+	case model.CostRuleKindFixedPerRide:
+		return toCents(*costRule.Amount)
+	case model.CostRuleKindPerKm:
+		if ride.DistanceKm == nil || ride.Vehicle == nil || ride.Vehicle.Consumption == nil {
+			log.Printf("Ride %d can't be costed per km without distance nor vehicle consumption", ride.ID)
+			return 0
+		}
+		return toCents(*ride.DistanceKm * *ride.Vehicle.Consumption / 100 * *costRule.FuelPrice)
+	}
+	return 0
+}
+
+func insertLedgerEntry(ctx context.Context, lCtx *LuwContext, rotationId int64, email string, kind model.LedgerEntryKind,
+	amountCents int64, date time.Time, label string, rideId *int64, expenseId *int64, paymentId *int64) error {
+	const q string = `INSERT INTO LedgerEntries(rotationId, email, kindCd, amountCents, entryDate, label, rideId, expenseId, paymentId, createTmstmp)
+						VALUES (?, ?, (select RefCd from RefLedgerEntryKind where RefName=?), ?, ?, ?, ?, ?, ?, DATETIME('now'))`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, rotationId, email, kind, amountCents, date.UTC(), label, rideId, expenseId, paymentId)
+	return err
+}
+
+// RecordRideCosts derives the ledger entries of a ride from its current state:
+// the passengers owe their share of the ride cost to the conductor.
+// Rides which are not recorded have no entries.
+func RecordRideCosts(ctx context.Context, lCtx *LuwContext, rideId int64) error {
+	const qDelete string = `DELETE FROM LedgerEntries WHERE rideId=?`
+	const qRotation string = `select rotationId from Rides where id=? and deleteTmstmp is null`
+
+	if _, err := lCtx.Tx.ExecContext(ctx, qDelete, rideId); err != nil {
+		return err
+	}
+
+	var rotationId int64
+	err := lCtx.Tx.QueryRowContext(ctx, qRotation, rideId).Scan(&rotationId)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		return err
+	}
+
+	ride, err := FindRide(ctx, lCtx, rideId)
+	if err != nil {
+		return err
+	}
+	if ride.Status != model.RideStatusRecorded {
+		return nil
+	}
+	costRule, err := rideCostRule(ctx, lCtx, rotationId, rideId)
+	if err != nil {
+		return err
+	}
+
+	passengers := make([]string, 0, len(ride.Participants))
+	for _, participant := range ride.Participants {
+		if participant.Email != ride.Conductor.Email {
+			passengers = append(passengers, participant.Email)
+		}
+	}
+	if len(passengers) == 0 {
+		return nil
+	}
+
+	cost := rideCostCents(costRule, ride)
+	if cost == 0 {
+		return nil
+	}
+
+	// The conductor keeps a share, passengers owe theirs
+	label := fmt.Sprintf("Ride of %s", ride.Date.Format("2006-01-02"))
+	swappedEmail, err := swappedWith(ctx, lCtx, rideId)
+	if err != nil {
+		return err
+	}
+	if swappedEmail != "" {
+		label += fmt.Sprintf(", swapped with %s", swappedEmail)
+	}
+	shares := splitCents(cost, len(passengers)+1)
+	var owed int64
+	for i, passenger := range passengers {
+		owed += shares[i+1]
+		if err := insertLedgerEntry(ctx, lCtx, rotationId, passenger, model.LedgerEntryKindRide, -shares[i+1], ride.Date, label, &rideId, nil, nil); err != nil {
+			return err
+		}
+	}
+	if err := insertLedgerEntry(ctx, lCtx, rotationId, ride.Conductor.Email, model.LedgerEntryKindRide, owed, ride.Date, label, &rideId, nil, nil); err != nil {
+		return err
+	}
+
+	log.Printf("Record cost of ride %d: %d cents shared by %d", rideId, cost, len(passengers)+1)
+	return nil
+}
+
+func FindExpense(ctx context.Context, lCtx *LuwContext, id int64) (*model.Expense, error) {
+	const q string = `select id, payerEmail, amountCents, label, expenseDate
+						from Expenses e
+						where e.id = ? and e.deleteTmstmp is null`
+
+	expense := new(model.Expense)
+	var payerEmail string
+	var amountCents int64
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&expense.ID,
+		&payerEmail,
+		&amountCents,
+		&expense.Label,
+		&expense.Date); err != nil {
+		return nil, err
+	}
+	expense.Amount = fromCents(amountCents)
+
+	payer, err := FindUser(ctx, lCtx, &payerEmail)
+	if err != nil {
+		return nil, err
+	}
+	expense.Payer = payer
+
+	return expense, nil
+}
+
+// AddExpense credits the payer and shares the amount between the rotation participants, or the given ones
+func AddExpense(ctx context.Context, lCtx *LuwContext, newExpense *model.NewExpense) (*model.Expense, error) {
+	const q string = `INSERT INTO Expenses(rotationId, payerEmail, amountCents, label, expenseDate, createTmstmp, lstUpdTmstmp, deleteTmstmp)
+						VALUES (?, ?, ?, ?, ?, DATETIME('now'), DATETIME('now'), null)`
+
+	if newExpense.Amount <= 0 {
+		return nil, fmt.Errorf("an expense needs a positive amount")
+	}
+
+	rotationId := int64(newExpense.IDRotation)
+	sharedBy := newExpense.EmailSharedBy
+	if len(sharedBy) == 0 {
+		participants, err := FindRotationParticipants(ctx, lCtx, rotationId)
+		if err != nil {
+			return nil, err
+		}
+		for _, participant := range participants {
+			sharedBy = append(sharedBy, participant.Email)
+		}
+	}
+	if len(sharedBy) == 0 {
+		return nil, fmt.Errorf("nobody to share the expense with")
+	}
+	if err := checkRotationParticipants(ctx, lCtx, rotationId, append([]string{newExpense.EmailPayer}, sharedBy...)); err != nil {
+		return nil, err
+	}
+
+	date := time.Now().UTC().Truncate(time.Second)
+	if newExpense.Date != nil {
+		date = newExpense.Date.UTC()
+	}
+
+	amountCents := toCents(newExpense.Amount)
+	res, err := lCtx.Tx.ExecContext(ctx, q, rotationId, newExpense.EmailPayer, amountCents, newExpense.Label, date)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertLedgerEntry(ctx, lCtx, rotationId, newExpense.EmailPayer, model.LedgerEntryKindExpense, amountCents, date, newExpense.Label, nil, &id, nil); err != nil {
+		return nil, err
+	}
+	shares := splitCents(amountCents, len(sharedBy))
+	for i, email := range sharedBy {
+		if err := insertLedgerEntry(ctx, lCtx, rotationId, email, model.LedgerEntryKindExpense, -shares[i], date, newExpense.Label, nil, &id, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Add expense %s of %d cents for rotation %d assign id %d", newExpense.Label, amountCents, rotationId, id)
+	return FindExpense(ctx, lCtx, id)
+}
+
+func FindPayment(ctx context.Context, lCtx *LuwContext, id int64) (*model.Payment, error) {
+	const q string = `select id, fromEmail, toEmail, amountCents, paymentDate
+						from Payments p
+						where p.id = ?`
+
+	payment := new(model.Payment)
+	var fromEmail, toEmail string
+	var amountCents int64
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&payment.ID,
+		&fromEmail,
+		&toEmail,
+		&amountCents,
+		&payment.Date); err != nil {
+		return nil, err
+	}
+	payment.Amount = fromCents(amountCents)
+
+	from, err := FindUser(ctx, lCtx, &fromEmail)
+	if err != nil {
+		return nil, err
+	}
+	payment.From = from
+
+	to, err := FindUser(ctx, lCtx, &toEmail)
+	if err != nil {
+		return nil, err
+	}
+	payment.To = to
+
+	return payment, nil
+}
+
+// checkRotationParticipants rejects the users who don't participate in the rotation
+func checkRotationParticipants(ctx context.Context, lCtx *LuwContext, rotationId int64, emails []string) error {
+	for _, email := range emails {
+		participant, err := IsRotationParticipant(ctx, lCtx, rotationId, email)
+		if err != nil {
+			return err
+		}
+		if !participant {
+			return fmt.Errorf("%s doesn't participate in rotation %d", email, rotationId)
+		}
+	}
+	return nil
+}
+
+// isLedgerMember tells whether the user participates in the rotation, or did and still has entries in its ledger
+func isLedgerMember(ctx context.Context, lCtx *LuwContext, rotationId int64, email string) (bool, error) {
+	const q string = `select exists(select 1 from RotationParticipants where rotationId = ? and email = ?)
+						or exists(select 1 from LedgerEntries where rotationId = ? and email = ?)`
+
+	var member bool
+	err := lCtx.Tx.QueryRowContext(ctx, q, rotationId, email, rotationId, email).Scan(&member)
+	return member, err
+}
+
+// SettleUp records a payment, the payer balance goes up and the receiver one down
+func SettleUp(ctx context.Context, lCtx *LuwContext, newPayment *model.NewPayment) (*model.Payment, error) {
+	const q string = `INSERT INTO Payments(rotationId, fromEmail, toEmail, amountCents, paymentDate, createTmstmp)
+						VALUES (?, ?, ?, ?, ?, DATETIME('now'))`
+
+	if newPayment.Amount <= 0 {
+		return nil, fmt.Errorf("a payment needs a positive amount")
+	}
+	if newPayment.EmailFrom == newPayment.EmailTo {
+		return nil, fmt.Errorf("a payment needs two different members")
+	}
+
+	rotationId := int64(newPayment.IDRotation)
+	for _, email := range []string{newPayment.EmailFrom, newPayment.EmailTo} {
+		member, err := isLedgerMember(ctx, lCtx, rotationId, email)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			return nil, fmt.Errorf("%s doesn't participate in rotation %d", email, rotationId)
+		}
+	}
+	amountCents := toCents(newPayment.Amount)
+	date := time.Now().UTC().Truncate(time.Second)
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, rotationId, newPayment.EmailFrom, newPayment.EmailTo, amountCents, date)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	label := fmt.Sprintf("Payment from %s to %s", newPayment.EmailFrom, newPayment.EmailTo)
+	if err := insertLedgerEntry(ctx, lCtx, rotationId, newPayment.EmailFrom, model.LedgerEntryKindPayment, amountCents, date, label, nil, nil, &id); err != nil {
+		return nil, err
+	}
+	if err := insertLedgerEntry(ctx, lCtx, rotationId, newPayment.EmailTo, model.LedgerEntryKindPayment, -amountCents, date, label, nil, nil, &id); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Settle up %d cents from %s to %s in rotation %d", amountCents, newPayment.EmailFrom, newPayment.EmailTo, rotationId)
+	return FindPayment(ctx, lCtx, id)
+}
+
+// FindBalances sums the ledger of every rotation participant
+func FindBalances(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.Balance, error) {
+	const q string = `select l.email, sum(l.amountCents)
+						from LedgerEntries l
+						where l.rotationId = ?
+						group by l.email
+						order by l.email`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sums := make(map[string]int64)
+	emails := make([]string, 0)
+	for rows.Next() {
+		var email string
+		var amountCents int64
+		if err := rows.Scan(&email, &amountCents); err != nil {
+			return nil, err
+		}
+		sums[email] = amountCents
+		emails = append(emails, email)
+	}
+	rows.Close()
+
+	participants, err := FindRotationParticipants(ctx, lCtx, rotationId)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]*model.Balance, 0, len(participants))
+	for _, participant := range participants {
+		balances = append(balances, &model.Balance{User: participant, Amount: fromCents(sums[participant.Email])})
+		delete(sums, participant.Email)
+	}
+
+	// Former participants may still owe or be owed, even once their account is deleted
+	for _, email := range emails {
+		amountCents, former := sums[email]
+		if !former {
+			continue
+		}
+		user, err := findUser(ctx, lCtx, &email, true)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, &model.Balance{User: user, Amount: fromCents(amountCents)})
+	}
+
+	return balances, nil
+}
+
+// FindLedger lists the entries of the rotation, of one member only when email is given
+func FindLedger(ctx context.Context, lCtx *LuwContext, rotationId int64, email *string) ([]*model.LedgerEntry, error) {
+	const q string = `select l.id, l.email, k.RefName, l.amountCents, l.entryDate, l.label, l.rideId
+						from LedgerEntries l left join RefLedgerEntryKind k on l.kindCd = k.RefCd
+						where l.rotationId = ? and (? is null or l.email = ?)
+						order by l.entryDate, l.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId, email, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*model.LedgerEntry, 0)
+	entryEmails := make([]string, 0)
+	for rows.Next() {
+		entry := new(model.LedgerEntry)
+		var entryEmail string
+		var amountCents int64
+		if err := rows.Scan(&entry.ID, &entryEmail, &entry.Kind, &amountCents, &entry.Date, &entry.Label, &entry.IDRide); err != nil {
+			return nil, err
+		}
+		entry.Amount = fromCents(amountCents)
+		entries = append(entries, entry)
+		entryEmails = append(entryEmails, entryEmail)
+	}
+	rows.Close()
+
+	for i, entry := range entries {
+		user, err := findUser(ctx, lCtx, &entryEmails[i], true)
+		if err != nil {
+			return nil, err
+		}
+		entry.User = user
+	}
+
+	return entries, nil
+}
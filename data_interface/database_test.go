@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"os"
+	"strings"
 	"testing"
+	"time"
 	"whosdriving-be/graph/model"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -220,14 +222,18 @@ func TestRide(t *testing.T) {
 		EmailParticipants: []string{expectedCreator.Email, expectedParticipant1.Email},
 	}
 
+	rideDate := time.Date(2022, time.September, 5, 7, 45, 0, 0, time.UTC)
 	expectedRide := model.Ride{
 		ID:           1,
+		Date:         rideDate,
+		Status:       model.RideStatusRecorded,
 		Conductor:    &expectedParticipant1,
 		Participants: []*model.User{&expectedParticipant1, &expectedCreator},
 	}
 
 	newRide := model.NewRide{
 		IDRotation:        1,
+		Date:              &rideDate,
 		EmailConductor:    "john@domain.com",
 		EmailParticipants: []string{expectedCreator.Email, expectedParticipant1.Email},
 	}
@@ -297,3 +303,2019 @@ func TestRide(t *testing.T) {
 		t.Fatalf("Error on commit - %s", err)
 	}
 }
+
+func TestSchedule(t *testing.T) {
+	firstName, lastName, profile := "test", "domain", "noProfile"
+	creator := model.User{
+		Email:     "test@domain.com",
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Profile:   &profile,
+		Role:      "STANDARD",
+	}
+
+	firstNameJohn, lastNameJohn, profileJohn := "John", "Smith", ""
+	participant1 := model.User{
+		Email:     "john@domain.com",
+		FirstName: &firstNameJohn,
+		LastName:  &lastNameJohn,
+		Profile:   &profileJohn,
+		Role:      "STANDARD",
+	}
+
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{creator.Email, participant1.Email},
+	}
+
+	// Every monday and wednesday at 07:45 in Paris, starting a monday
+	timezone := "Europe/Paris"
+	startDate := time.Now().UTC().AddDate(0, 0, 1).Truncate(24 * time.Hour)
+	for startDate.Weekday() != time.Monday {
+		startDate = startDate.AddDate(0, 0, 1)
+	}
+	newSchedule := model.NewSchedule{
+		IDRotation: 1,
+		Days:       []model.Weekday{model.WeekdayMonday, model.WeekdayWednesday},
+		Time:       "07:45",
+		Timezone:   &timezone,
+		Direction:  model.DirectionOutbound,
+		StartDate:  &startDate,
+	}
+
+	expectedSchedule := model.Schedule{
+		ID:        1,
+		Days:      newSchedule.Days,
+		Time:      newSchedule.Time,
+		Timezone:  timezone,
+		Direction: model.DirectionOutbound,
+		StartDate: startDate,
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_schedule.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&creator))
+	assert.Nil(t, err, "")
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&participant1))
+	assert.Nil(t, err, "")
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	// invalid schedules
+	invalidSchedule := newSchedule
+	invalidSchedule.Time = "7h45"
+	_, err = CreateSchedule(ctx, &lCtx, &invalidSchedule)
+	assert.NotNil(t, err, "Invalid time rejected")
+	invalidSchedule = newSchedule
+	invalidSchedule.Days = nil
+	_, err = CreateSchedule(ctx, &lCtx, &invalidSchedule)
+	assert.NotNil(t, err, "Schedule without days rejected")
+
+	// create & find
+	schedule, err := CreateSchedule(ctx, &lCtx, &newSchedule)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &expectedSchedule, schedule)
+
+	rotation, err := FindRotation(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Schedule{&expectedSchedule}, rotation.Schedules)
+
+	// two weeks hold 4 slots
+	until := startDate.AddDate(0, 0, 13)
+	occurrences, err := ScheduleOccurrences(schedule, startDate, until)
+	assert.Nil(t, err, "")
+	assert.Len(t, occurrences, 4)
+	paris, _ := time.LoadLocation(timezone)
+	for _, occurrence := range occurrences {
+		assert.Equal(t, 7, occurrence.In(paris).Hour())
+		assert.Equal(t, 45, occurrence.In(paris).Minute())
+	}
+
+	// planned rides alternate the drivers
+	rides, err := PlanRides(ctx, &lCtx, 1, until)
+	assert.Nil(t, err, "")
+	assert.Len(t, rides, 4)
+	for i, ride := range rides {
+		assert.Equal(t, model.RideStatusPlanned, ride.Status)
+		assert.Equal(t, occurrences[i], ride.Date)
+		assert.Len(t, ride.Participants, 2)
+	}
+	assert.Equal(t, participant1.Email, rides[0].Conductor.Email)
+	assert.Equal(t, creator.Email, rides[1].Conductor.Email)
+	assert.Equal(t, participant1.Email, rides[2].Conductor.Email)
+	assert.Equal(t, creator.Email, rides[3].Conductor.Email)
+
+	// planning twice does not duplicate the slots
+	rides, err = PlanRides(ctx, &lCtx, 1, until)
+	assert.Nil(t, err, "")
+	assert.Len(t, rides, 0)
+
+	driver, err := SuggestDriver(ctx, &lCtx, 1, until)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &participant1, driver)
+
+	// confirm & cancel
+	ride, err := FindRide(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	ride, err = ChangeRideStatus(ctx, &lCtx, ride, model.RideStatusRecorded)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.RideStatusRecorded, ride.Status)
+	_, err = ChangeRideStatus(ctx, &lCtx, ride, model.RideStatusCancelled)
+	assert.NotNil(t, err, "Recorded ride can't be cancelled")
+
+	ride, err = FindRide(ctx, &lCtx, 2)
+	assert.Nil(t, err, "")
+	ride, err = ChangeRideStatus(ctx, &lCtx, ride, model.RideStatusCancelled)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.RideStatusCancelled, ride.Status)
+
+	// delete drops the remaining planned rides
+	_, err = DeleteSchedule(ctx, &lCtx, schedule)
+	assert.Nil(t, err, "")
+	rotation, err = FindRotation(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Nil(t, rotation.Schedules)
+	assert.Len(t, rotation.Rides, 2)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	firstName, lastName, profile := "test", "domain", "noProfile"
+	creator := model.User{
+		Email:     "test@domain.com",
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Profile:   &profile,
+		Role:      "STANDARD",
+	}
+
+	firstNameJohn, lastNameJohn, profileJohn := "John", "Smith", ""
+	participant1 := model.User{
+		Email:     "john@domain.com",
+		FirstName: &firstNameJohn,
+		LastName:  &lastNameJohn,
+		Profile:   &profileJohn,
+		Role:      "STANDARD",
+	}
+
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{creator.Email, participant1.Email},
+	}
+
+	// John is on holidays the first week, and can't drive on wednesdays
+	monday := time.Now().UTC().AddDate(0, 0, 1).Truncate(24 * time.Hour)
+	for monday.Weekday() != time.Monday {
+		monday = monday.AddDate(0, 0, 1)
+	}
+	holidaysEnd := monday.AddDate(0, 0, 7)
+	reason := "Holidays"
+	holidays := model.NewAvailability{
+		Email:  participant1.Email,
+		Kind:   model.AvailabilityKindAbsent,
+		From:   &monday,
+		To:     &holidaysEnd,
+		Reason: &reason,
+	}
+	expectedHolidays := model.Availability{
+		ID:       1,
+		User:     &participant1,
+		Kind:     model.AvailabilityKindAbsent,
+		From:     &monday,
+		To:       &holidaysEnd,
+		Timezone: "UTC",
+		Reason:   &reason,
+	}
+	wednesdays := model.NewAvailability{
+		Email: participant1.Email,
+		Kind:  model.AvailabilityKindCannotDrive,
+		Days:  []model.Weekday{model.WeekdayWednesday},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_availability.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&creator))
+	assert.Nil(t, err, "")
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&participant1))
+	assert.Nil(t, err, "")
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	// invalid availabilities
+	_, err = CreateAvailability(ctx, &lCtx, &model.NewAvailability{Email: participant1.Email, Kind: model.AvailabilityKindAbsent})
+	assert.NotNil(t, err, "Availability without range nor days rejected")
+	_, err = CreateAvailability(ctx, &lCtx, &model.NewAvailability{Email: participant1.Email, Kind: model.AvailabilityKindAbsent,
+		From: &holidaysEnd, To: &monday})
+	assert.NotNil(t, err, "Reversed range rejected")
+
+	// create & find
+	availability, err := CreateAvailability(ctx, &lCtx, &holidays)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &expectedHolidays, availability)
+	_, err = CreateAvailability(ctx, &lCtx, &wednesdays)
+	assert.Nil(t, err, "")
+
+	availabilities, err := FindRotationAvailabilities(ctx, &lCtx, 1, monday, monday.AddDate(0, 0, 1))
+	assert.Nil(t, err, "")
+	assert.Len(t, availabilities, 2)
+	availabilities, err = FindRotationAvailabilities(ctx, &lCtx, 1, monday.AddDate(0, 0, 14), monday.AddDate(0, 0, 15))
+	assert.Nil(t, err, "")
+	assert.Len(t, availabilities, 1)
+
+	// suggestion skips John while absent or on wednesdays
+	driver, err := SuggestDriver(ctx, &lCtx, 1, monday.Add(8*time.Hour))
+	assert.Nil(t, err, "")
+	assert.Equal(t, creator.Email, driver.Email)
+	driver, err = SuggestDriver(ctx, &lCtx, 1, monday.AddDate(0, 0, 16).Add(8*time.Hour))
+	assert.Nil(t, err, "")
+	assert.Equal(t, creator.Email, driver.Email)
+	driver, err = SuggestDriver(ctx, &lCtx, 1, monday.AddDate(0, 0, 14).Add(8*time.Hour))
+	assert.Nil(t, err, "")
+	assert.Equal(t, participant1.Email, driver.Email)
+
+	// planning leaves John out of the rides of his holidays, but keeps him as passenger on wednesdays
+	_, err = CreateSchedule(ctx, &lCtx, &model.NewSchedule{
+		IDRotation: 1,
+		Days:       []model.Weekday{model.WeekdayMonday, model.WeekdayWednesday},
+		Time:       "08:00",
+		Direction:  model.DirectionOutbound,
+		StartDate:  &monday,
+	})
+	assert.Nil(t, err, "")
+	rides, err := PlanRides(ctx, &lCtx, 1, monday.AddDate(0, 0, 14))
+	assert.Nil(t, err, "")
+	assert.Len(t, rides, 4)
+	assert.Equal(t, creator.Email, rides[0].Conductor.Email)
+	assert.Equal(t, []*model.User{&creator}, rides[0].Participants)
+	assert.Equal(t, creator.Email, rides[1].Conductor.Email)
+	assert.Equal(t, []*model.User{&creator}, rides[1].Participants)
+	assert.Equal(t, participant1.Email, rides[2].Conductor.Email)
+	assert.Len(t, rides[2].Participants, 2)
+	assert.Equal(t, creator.Email, rides[3].Conductor.Email)
+	assert.Len(t, rides[3].Participants, 2)
+
+	// delete
+	_, err = DeleteAvailability(ctx, &lCtx, availability)
+	assert.Nil(t, err, "")
+	_, err = FindAvailability(ctx, &lCtx, int64(availability.ID))
+	assert.Equal(t, err, sql.ErrNoRows)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestVehicle(t *testing.T) {
+	firstName, lastName, profile := "test", "domain", "noProfile"
+	creator := model.User{
+		Email:     "test@domain.com",
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Profile:   &profile,
+		Role:      "STANDARD",
+	}
+
+	firstNameJohn, lastNameJohn, profileJohn := "John", "Smith", ""
+	participant1 := model.User{
+		Email:     "john@domain.com",
+		FirstName: &firstNameJohn,
+		LastName:  &lastNameJohn,
+		Profile:   &profileJohn,
+		Role:      "STANDARD",
+	}
+
+	firstNameJane, lastNameJane, profileJane := "Jane", "Doe", ""
+	participant2 := model.User{
+		Email:     "jane@domain.com",
+		FirstName: &firstNameJane,
+		LastName:  &lastNameJane,
+		Profile:   &profileJane,
+		Role:      "STANDARD",
+	}
+
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{creator.Email, participant1.Email, participant2.Email},
+	}
+
+	consumption := 5.4
+	newVehicle := model.NewVehicle{
+		EmailOwner:  participant1.Email,
+		Label:       "Roadster",
+		Seats:       2,
+		FuelType:    model.FuelTypePetrol,
+		Consumption: &consumption,
+	}
+
+	expectedVehicle := model.Vehicle{
+		ID:          1,
+		Owner:       &participant1,
+		Label:       "Roadster",
+		Seats:       2,
+		FuelType:    model.FuelTypePetrol,
+		Consumption: &consumption,
+	}
+
+	vehicleId := 1
+	rideDate := time.Date(2022, time.September, 5, 7, 45, 0, 0, time.UTC)
+	newRide := model.NewRide{
+		IDRotation:        1,
+		Date:              &rideDate,
+		IDVehicle:         &vehicleId,
+		EmailConductor:    participant1.Email,
+		EmailParticipants: []string{participant1.Email, creator.Email},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_vehicle.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&creator))
+	assert.Nil(t, err, "")
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&participant1))
+	assert.Nil(t, err, "")
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&participant2))
+	assert.Nil(t, err, "")
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	// invalid vehicle
+	invalidVehicle := newVehicle
+	invalidVehicle.Seats = 0
+	_, err = CreateVehicle(ctx, &lCtx, &invalidVehicle)
+	assert.NotNil(t, err, "Vehicle without seats rejected")
+
+	// create & find
+	vehicle, err := CreateVehicle(ctx, &lCtx, &newVehicle)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &expectedVehicle, vehicle)
+
+	vehicles, err := FindUserVehicles(ctx, &lCtx, participant1.Email)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Vehicle{&expectedVehicle}, vehicles)
+
+	// rides within the capacity only
+	ride, err := AddRide(ctx, &lCtx, &newRide)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &expectedVehicle, ride.Vehicle)
+
+	overloadedRide := newRide
+	overloadedRide.EmailParticipants = []string{creator.Email, participant2.Email}
+	_, err = AddRide(ctx, &lCtx, &overloadedRide)
+	assert.NotNil(t, err, "Ride over capacity rejected")
+
+	otherRide := newRide
+	otherRide.EmailConductor = participant2.Email
+	otherRide.EmailParticipants = []string{participant2.Email, creator.Email}
+	_, err = AddRide(ctx, &lCtx, &otherRide)
+	assert.NotNil(t, err, "Vehicle of someone else rejected")
+
+	// delete, rides keep their vehicle
+	_, err = DeleteVehicle(ctx, &lCtx, vehicle)
+	assert.Nil(t, err, "")
+	vehicles, err = FindUserVehicles(ctx, &lCtx, participant1.Email)
+	assert.Nil(t, err, "")
+	assert.Len(t, vehicles, 0)
+	ride, err = FindRide(ctx, &lCtx, int64(ride.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, &expectedVehicle, ride.Vehicle)
+	_, err = AddRide(ctx, &lCtx, &newRide)
+	assert.Equal(t, err, sql.ErrNoRows)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestCosts(t *testing.T) {
+	firstName, lastName, profile := "test", "domain", "noProfile"
+	creator := model.User{
+		Email:     "test@domain.com",
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Profile:   &profile,
+		Role:      "STANDARD",
+	}
+
+	firstNameJohn, lastNameJohn, profileJohn := "John", "Smith", ""
+	participant1 := model.User{
+		Email:     "john@domain.com",
+		FirstName: &firstNameJohn,
+		LastName:  &lastNameJohn,
+		Profile:   &profileJohn,
+		Role:      "STANDARD",
+	}
+
+	firstNameJane, lastNameJane, profileJane := "Jane", "Doe", ""
+	participant2 := model.User{
+		Email:     "jane@domain.com",
+		FirstName: &firstNameJane,
+		LastName:  &lastNameJane,
+		Profile:   &profileJane,
+		Role:      "STANDARD",
+	}
+
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{creator.Email, participant1.Email, participant2.Email},
+	}
+
+	rideDate := time.Date(2022, time.September, 5, 7, 45, 0, 0, time.UTC)
+	newRide := model.NewRide{
+		IDRotation:        1,
+		Date:              &rideDate,
+		EmailConductor:    participant1.Email,
+		EmailParticipants: []string{creator.Email, participant1.Email, participant2.Email},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_costs.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&creator))
+	assert.Nil(t, err, "")
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&participant1))
+	assert.Nil(t, err, "")
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&participant2))
+	assert.Nil(t, err, "")
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	// no rule, no cost
+	costRule, err := FindCostRule(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &model.CostRule{Kind: model.CostRuleKindNone, Currency: "EUR"}, costRule)
+	_, err = AddRide(ctx, &lCtx, &newRide)
+	assert.Nil(t, err, "")
+	ledger, err := FindLedger(ctx, &lCtx, 1, nil)
+	assert.Nil(t, err, "")
+	assert.Len(t, ledger, 0)
+
+	// fixed cost of 10 shared by 3
+	_, err = SetCostRule(ctx, &lCtx, &model.NewCostRule{IDRotation: 1, Kind: model.CostRuleKindFixedPerRide})
+	assert.NotNil(t, err, "Fixed rule without amount rejected")
+	amount := 10.0
+	costRule, err = SetCostRule(ctx, &lCtx, &model.NewCostRule{IDRotation: 1, Kind: model.CostRuleKindFixedPerRide, Amount: &amount})
+	assert.Nil(t, err, "")
+	assert.Equal(t, &model.CostRule{Kind: model.CostRuleKindFixedPerRide, Amount: &amount, Currency: "EUR"}, costRule)
+
+	ride, err := AddRide(ctx, &lCtx, &newRide)
+	assert.Nil(t, err, "")
+	balances, err := FindBalances(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Balance{
+		{User: &participant2, Amount: -3.33},
+		{User: &participant1, Amount: 6.66},
+		{User: &creator, Amount: -3.33},
+	}, balances)
+
+	// the conductor changes, so does the ledger
+	ride.Conductor = &creator
+	_, err = UpdateRide(ctx, &lCtx, ride)
+	assert.Nil(t, err, "")
+	balances, err = FindBalances(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Balance{
+		{User: &participant2, Amount: -3.33},
+		{User: &participant1, Amount: -3.33},
+		{User: &creator, Amount: 6.66},
+	}, balances)
+
+	// per km with the vehicle consumption
+	consumption := 5.0
+	vehicle, err := CreateVehicle(ctx, &lCtx, &model.NewVehicle{EmailOwner: creator.Email, Label: "Car", Seats: 5,
+		FuelType: model.FuelTypeDiesel, Consumption: &consumption})
+	assert.Nil(t, err, "")
+	distanceKm := 30.0
+	rotation, err := SetRotationDistance(ctx, &lCtx, 1, &distanceKm)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &distanceKm, rotation.DistanceKm)
+	fuelPrice := 2.0
+	_, err = SetCostRule(ctx, &lCtx, &model.NewCostRule{IDRotation: 1, Kind: model.CostRuleKindPerKm, FuelPrice: &fuelPrice})
+	assert.Nil(t, err, "")
+
+	// the recorded ride keeps its fixed cost
+	_, err = UpdateRide(ctx, &lCtx, ride)
+	assert.Nil(t, err, "")
+	balances, err = FindBalances(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Balance{
+		{User: &participant2, Amount: -3.33},
+		{User: &participant1, Amount: -3.33},
+		{User: &creator, Amount: 6.66},
+	}, balances)
+
+	// 30km at 5L/100km, 2 per litre is 3 shared by 3
+	vehicleId := vehicle.ID
+	perKmRide := newRide
+	perKmRide.IDVehicle = &vehicleId
+	_, err = AddRide(ctx, &lCtx, &perKmRide)
+	assert.Nil(t, err, "")
+	ledger, err = FindLedger(ctx, &lCtx, 1, &participant1.Email)
+	assert.Nil(t, err, "")
+	assert.Len(t, ledger, 2)
+	assert.Equal(t, model.LedgerEntryKindRide, ledger[1].Kind)
+	assert.Equal(t, 2.0, ledger[1].Amount)
+
+	// expenses and settle up
+	_, err = AddExpense(ctx, &lCtx, &model.NewExpense{IDRotation: 1, EmailPayer: creator.Email, Amount: 6, Label: "Toll"})
+	assert.Nil(t, err, "")
+	payment, err := SettleUp(ctx, &lCtx, &model.NewPayment{IDRotation: 1, EmailFrom: participant2.Email, EmailTo: participant1.Email, Amount: 2.5})
+	assert.Nil(t, err, "")
+	assert.Equal(t, &participant2, payment.From)
+	_, err = SettleUp(ctx, &lCtx, &model.NewPayment{IDRotation: 1, EmailFrom: creator.Email, EmailTo: creator.Email, Amount: 1})
+	assert.NotNil(t, err, "Payment to oneself rejected")
+	_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: "other@domain.com"})
+	assert.Nil(t, err, "")
+	_, err = AddExpense(ctx, &lCtx, &model.NewExpense{IDRotation: 1, EmailPayer: "other@domain.com", Amount: 6, Label: "Toll"})
+	assert.NotNil(t, err, "Expense paid by a non member rejected")
+	_, err = AddExpense(ctx, &lCtx, &model.NewExpense{IDRotation: 1, EmailPayer: creator.Email, Amount: 6, Label: "Toll",
+		EmailSharedBy: []string{creator.Email, "other@domain.com"}})
+	assert.NotNil(t, err, "Expense shared with a non member rejected")
+	_, err = SettleUp(ctx, &lCtx, &model.NewPayment{IDRotation: 1, EmailFrom: "other@domain.com", EmailTo: creator.Email, Amount: 1})
+	assert.NotNil(t, err, "Payment from a non member rejected")
+
+	balances, err = FindBalances(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Balance{
+		{User: &participant2, Amount: -3.83},
+		{User: &participant1, Amount: -5.83},
+		{User: &creator, Amount: 9.66},
+	}, balances)
+
+	// deleted rides leave the ledger
+	_, err = DeleteRide(ctx, &lCtx, ride)
+	assert.Nil(t, err, "")
+	balances, err = FindBalances(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Balance{
+		{User: &participant2, Amount: -0.5},
+		{User: &participant1, Amount: -2.5},
+		{User: &creator, Amount: 3},
+	}, balances)
+
+	// the former participants keep their balance, even once deleted
+	err = RemoveRotationParticipants(ctx, &lCtx, 1, &[]string{participant1.Email, participant2.Email})
+	assert.Nil(t, err, "")
+	_, err = DeleteUser(ctx, &lCtx, &participant2)
+	assert.Nil(t, err, "")
+	balances, err = FindBalances(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []*model.Balance{
+		{User: &creator, Amount: 3},
+		{User: &participant2, Amount: -0.5},
+		{User: &participant1, Amount: -2.5},
+	}, balances)
+	ledger, err = FindLedger(ctx, &lCtx, 1, &participant2.Email)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &participant2, ledger[0].User)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestInvitation(t *testing.T) {
+	firstName, lastName, profile := "test", "domain", "noProfile"
+	creator := model.User{
+		Email:     "test@domain.com",
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Profile:   &profile,
+		Role:      "STANDARD",
+	}
+
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{creator.Email, "john@domain.com", "jane@domain.com"},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_invitation.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	_, err = CreateUser(ctx, &lCtx, toNewUser(&creator))
+	assert.Nil(t, err, "")
+	rotation, err := CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	// unknown emails are invited, not participants yet
+	assert.Equal(t, []*model.User{&creator}, rotation.Participants)
+	john, err := FindUser(ctx, &lCtx, &newRotation.EmailParticipants[1])
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.RoleUnregistred, john.Role)
+
+	invitations, err := FindPendingInvitations(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(invitations))
+	assert.Equal(t, "jane@domain.com", invitations[0].User.Email)
+	assert.Equal(t, model.InvitationStatusPending, invitations[0].Status)
+
+	// invited again while pending, still one invitation each
+	err = CreateRotationParticipants(ctx, &lCtx, 1, &[]string{"john@domain.com"})
+	assert.Nil(t, err, "")
+
+	issued, err := IssueInvitationTokens(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(issued))
+	assert.Equal(t, "TestRotation", issued[0].RotationName)
+	assert.Equal(t, "john@domain.com", issued[0].Invitation.User.Email)
+	tokens := map[string]string{}
+	for _, invitation := range issued {
+		tokens[invitation.Invitation.User.Email] = invitation.Token
+	}
+
+	// tokens are only issued once
+	issued, err = IssueInvitationTokens(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 0, len(issued))
+
+	_, err = AcceptInvitation(ctx, &lCtx, "unknown", nil, nil)
+	assert.NotNil(t, err, "")
+
+	firstNameJohn := "John"
+	rotation, err = AcceptInvitation(ctx, &lCtx, tokens["john@domain.com"], &firstNameJohn, nil)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(rotation.Participants))
+	assert.Equal(t, "john@domain.com", rotation.Participants[0].Email)
+	assert.Equal(t, model.RoleStandard, rotation.Participants[0].Role)
+	assert.Equal(t, &firstNameJohn, rotation.Participants[0].FirstName)
+
+	// a token can't be used twice
+	_, err = DeclineInvitation(ctx, &lCtx, tokens["john@domain.com"])
+	assert.NotNil(t, err, "")
+
+	invitation, err := DeclineInvitation(ctx, &lCtx, tokens["jane@domain.com"])
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.InvitationStatusDeclined, invitation.Status)
+
+	invitations, err = FindPendingInvitations(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 0, len(invitations))
+
+	// registered users are added directly
+	err = CreateRotationParticipants(ctx, &lCtx, 1, &[]string{"john@domain.com"})
+	assert.Nil(t, err, "")
+	participants, err := FindRotationParticipants(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(participants))
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestJoinCode(t *testing.T) {
+	firstName, lastName, profile := "test", "domain", "noProfile"
+	creator := model.User{
+		Email:     "test@domain.com",
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Profile:   &profile,
+		Role:      "STANDARD",
+	}
+
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{creator.Email},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_joincode.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	for _, email := range []string{creator.Email, "john@domain.com", "jane@domain.com", "joe@domain.com"} {
+		_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+		assert.Nil(t, err, "")
+	}
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	zeroDays := 0
+	_, err = CreateJoinCode(ctx, &lCtx, &model.NewJoinCode{IDRotation: 1, ValidDays: &zeroDays})
+	assert.NotNil(t, err, "")
+
+	singleUse := true
+	generated, err := CreateJoinCode(ctx, &lCtx, &model.NewJoinCode{IDRotation: 1, SingleUse: &singleUse})
+	assert.Nil(t, err, "")
+	assert.True(t, generated.JoinCode.SingleUse)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 7), generated.JoinCode.ExpiresAt, time.Minute)
+
+	// without approval the user joins right away
+	joinRequest, err := JoinRotation(ctx, &lCtx, generated.Code, "john@domain.com")
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.JoinRequestStatusApproved, joinRequest.Status)
+	participants, err := FindRotationParticipants(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(participants))
+
+	// single use code is spent
+	_, err = JoinRotation(ctx, &lCtx, generated.Code, "jane@domain.com")
+	assert.NotNil(t, err, "")
+	joinCodes, err := FindJoinCodes(ctx, &lCtx, 1, time.Now())
+	assert.Nil(t, err, "")
+	assert.Equal(t, 0, len(joinCodes))
+
+	// with approval the request waits for the owner
+	_, err = SetJoinApproval(ctx, &lCtx, 1, true)
+	assert.Nil(t, err, "")
+	generated, err = CreateJoinCode(ctx, &lCtx, &model.NewJoinCode{IDRotation: 1})
+	assert.Nil(t, err, "")
+
+	_, err = JoinRotation(ctx, &lCtx, generated.Code, "john@domain.com")
+	assert.NotNil(t, err, "Already a participant")
+
+	janeRequest, err := JoinRotation(ctx, &lCtx, generated.Code, "jane@domain.com")
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.JoinRequestStatusPending, janeRequest.Status)
+	again, err := JoinRotation(ctx, &lCtx, generated.Code, "jane@domain.com")
+	assert.Nil(t, err, "")
+	assert.Equal(t, janeRequest.ID, again.ID)
+	joeRequest, err := JoinRotation(ctx, &lCtx, generated.Code, "joe@domain.com")
+	assert.Nil(t, err, "")
+
+	pending, err := FindPendingJoinRequests(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(pending))
+
+	joinRequest, err = DecideJoinRequest(ctx, &lCtx, int64(janeRequest.ID), model.JoinRequestStatusApproved)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.JoinRequestStatusApproved, joinRequest.Status)
+	joinRequest, err = DecideJoinRequest(ctx, &lCtx, int64(joeRequest.ID), model.JoinRequestStatusRejected)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.JoinRequestStatusRejected, joinRequest.Status)
+	_, err = DecideJoinRequest(ctx, &lCtx, int64(joeRequest.ID), model.JoinRequestStatusApproved)
+	assert.NotNil(t, err, "Already decided")
+
+	participants, err = FindRotationParticipants(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 3, len(participants))
+
+	// revoked code can't be used anymore
+	_, err = RevokeJoinCode(ctx, &lCtx, generated.JoinCode)
+	assert.Nil(t, err, "")
+	_, err = JoinRotation(ctx, &lCtx, generated.Code, "joe@domain.com")
+	assert.NotNil(t, err, "")
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{"test@domain.com", "john@domain.com", "jane@domain.com"},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_swap.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	for _, email := range []string{"test@domain.com", "john@domain.com", "jane@domain.com", "other@domain.com"} {
+		_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+		assert.Nil(t, err, "")
+	}
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	amount := 9.0
+	_, err = SetCostRule(ctx, &lCtx, &model.NewCostRule{IDRotation: 1, Kind: model.CostRuleKindFixedPerRide, Amount: &amount})
+	assert.Nil(t, err, "")
+
+	day1 := time.Date(2022, time.September, 5, 7, 45, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	ride, err := AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Date:              &day1,
+		EmailConductor:    "john@domain.com",
+		EmailParticipants: []string{"john@domain.com", "test@domain.com", "jane@domain.com"},
+	})
+	assert.Nil(t, err, "")
+	exchangeRide, err := AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Date:              &day2,
+		EmailConductor:    "jane@domain.com",
+		EmailParticipants: []string{"jane@domain.com", "test@domain.com"},
+	})
+	assert.Nil(t, err, "")
+
+	// only the conductor can ask, to another participant of the rotation
+	_, err = CreateSwapRequest(ctx, &lCtx, "jane@domain.com", &model.NewSwapRequest{IDRide: ride.ID, EmailTarget: "test@domain.com"})
+	assert.NotNil(t, err, "")
+	_, err = CreateSwapRequest(ctx, &lCtx, "john@domain.com", &model.NewSwapRequest{IDRide: ride.ID, EmailTarget: "other@domain.com"})
+	assert.NotNil(t, err, "")
+	_, err = CreateSwapRequest(ctx, &lCtx, "john@domain.com", &model.NewSwapRequest{IDRide: ride.ID, EmailTarget: "john@domain.com"})
+	assert.NotNil(t, err, "")
+	_, err = CreateSwapRequest(ctx, &lCtx, "john@domain.com", &model.NewSwapRequest{IDRide: ride.ID, EmailTarget: "test@domain.com", IDExchangeRide: &exchangeRide.ID})
+	assert.NotNil(t, err, "Exchange ride not driven by the target")
+
+	declined, err := CreateSwapRequest(ctx, &lCtx, "john@domain.com", &model.NewSwapRequest{IDRide: ride.ID, EmailTarget: "test@domain.com"})
+	assert.Nil(t, err, "")
+	declined, err = DeclineSwapRequest(ctx, &lCtx, declined)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.SwapStatusDeclined, declined.Status)
+	_, err = AcceptSwapRequest(ctx, &lCtx, declined)
+	assert.NotNil(t, err, "Already declined")
+
+	swap, err := CreateSwapRequest(ctx, &lCtx, "john@domain.com", &model.NewSwapRequest{IDRide: ride.ID, EmailTarget: "jane@domain.com", IDExchangeRide: &exchangeRide.ID})
+	assert.Nil(t, err, "")
+	outdated, err := CreateSwapRequest(ctx, &lCtx, "john@domain.com", &model.NewSwapRequest{IDRide: ride.ID, EmailTarget: "test@domain.com"})
+	assert.Nil(t, err, "")
+
+	swaps, err := FindUserSwapRequests(ctx, &lCtx, "jane@domain.com")
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(swaps))
+	assert.Equal(t, swap.ID, swaps[0].ID)
+
+	swap, err = AcceptSwapRequest(ctx, &lCtx, swap)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.SwapStatusAccepted, swap.Status)
+	assert.Equal(t, "jane@domain.com", swap.Ride.Conductor.Email)
+	assert.Equal(t, "john@domain.com", swap.ExchangeRide.Conductor.Email)
+	assert.Equal(t, 3, len(swap.ExchangeRide.Participants), "john joins the exchange ride he now drives")
+
+	outdated, err = FindSwapRequest(ctx, &lCtx, int64(outdated.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.SwapStatusCancelled, outdated.Status)
+
+	// the ledger credits the new conductors
+	ledger, err := FindLedger(ctx, &lCtx, 1, nil)
+	assert.Nil(t, err, "")
+	credits := map[string]float64{}
+	for _, entry := range ledger {
+		if entry.Amount > 0 {
+			credits[entry.User.Email] += entry.Amount
+			assert.Contains(t, *entry.Label, "swapped with")
+		}
+	}
+	assert.Equal(t, map[string]float64{"jane@domain.com": 6, "john@domain.com": 6}, credits)
+
+	// the owner hands a ride over to another participant, outdating its pending requests
+	pending, err := CreateSwapRequest(ctx, &lCtx, "john@domain.com", &model.NewSwapRequest{IDRide: exchangeRide.ID, EmailTarget: "jane@domain.com"})
+	assert.Nil(t, err, "")
+	exchangeRide, err = FindRide(ctx, &lCtx, int64(exchangeRide.ID))
+	assert.Nil(t, err, "")
+	_, err = HandOverRide(ctx, &lCtx, exchangeRide, "other@domain.com")
+	assert.NotNil(t, err, "Not a participant of the rotation")
+	handedOver, err := HandOverRide(ctx, &lCtx, exchangeRide, "test@domain.com")
+	assert.Nil(t, err, "")
+	assert.Equal(t, "test@domain.com", handedOver.Conductor.Email)
+	pending, err = FindSwapRequest(ctx, &lCtx, int64(pending.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.SwapStatusCancelled, pending.Status)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{"test@domain.com", "john@domain.com", "jane@domain.com"},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_stats.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	for _, email := range []string{"test@domain.com", "john@domain.com", "jane@domain.com"} {
+		_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+		assert.Nil(t, err, "")
+	}
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+
+	rides := []struct {
+		date         time.Time
+		participants []string
+	}{
+		{time.Date(2022, time.August, 31, 7, 45, 0, 0, time.UTC), []string{"test@domain.com"}},
+		{time.Date(2022, time.September, 5, 7, 45, 0, 0, time.UTC), []string{"john@domain.com", "test@domain.com", "jane@domain.com"}},
+		{time.Date(2022, time.September, 6, 7, 45, 0, 0, time.UTC), []string{"john@domain.com", "test@domain.com"}},
+		{time.Date(2022, time.September, 7, 7, 45, 0, 0, time.UTC), []string{"jane@domain.com", "john@domain.com", "test@domain.com"}},
+		{time.Date(2022, time.September, 12, 7, 45, 0, 0, time.UTC), []string{"jane@domain.com"}},
+		{time.Date(2022, time.October, 3, 7, 45, 0, 0, time.UTC), []string{"test@domain.com", "jane@domain.com"}},
+	}
+	for i := range rides {
+		_, err = AddRide(ctx, &lCtx, &model.NewRide{
+			IDRotation:        1,
+			Date:              &rides[i].date,
+			EmailConductor:    rides[i].participants[0],
+			EmailParticipants: rides[i].participants,
+		})
+		assert.Nil(t, err, "")
+	}
+
+	from := time.Date(2022, time.September, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, time.October, 8, 0, 0, 0, 0, time.UTC)
+
+	_, err = FindRotationStats(ctx, &lCtx, 1, to, from, model.GranularityMonth)
+	assert.NotNil(t, err, "")
+
+	stats, err := FindRotationStats(ctx, &lCtx, 1, from, to, model.GranularityMonth)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 5, stats.Rides)
+	assert.Equal(t, 1.2, stats.AveragePassengers)
+	assert.Equal(t, 2, len(stats.Buckets))
+	assert.Equal(t, from, stats.Buckets[0].Start)
+	assert.Equal(t, 4, stats.Buckets[0].Rides)
+	assert.Equal(t, 5, stats.Buckets[0].Passengers)
+	assert.Equal(t, 1, stats.Buckets[1].Rides)
+
+	emails := make([]string, 0)
+	for _, member := range stats.Members {
+		emails = append(emails, member.User.Email)
+	}
+	assert.Equal(t, []string{"jane@domain.com", "john@domain.com", "test@domain.com"}, emails)
+	jane, john, test := stats.Members[0], stats.Members[1], stats.Members[2]
+	assert.Equal(t, 2, john.Drives)
+	assert.Equal(t, 1, john.PassengerRides)
+	assert.Equal(t, 0.4, john.DrivingShare)
+	assert.Equal(t, 2, john.LongestStreak)
+	assert.Equal(t, 0, john.CurrentStreak)
+	assert.Equal(t, 2, jane.PassengerRides)
+	assert.Equal(t, 2, jane.LongestStreak)
+	assert.Equal(t, 1, test.Drives)
+	assert.Equal(t, 3, test.PassengerRides)
+	assert.Equal(t, 1, test.CurrentStreak)
+
+	// weeks start on monday, empty weeks are listed too
+	stats, err = FindRotationStats(ctx, &lCtx, 1, from, to, model.GranularityWeek)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 6, len(stats.Buckets))
+	assert.Equal(t, time.Date(2022, time.August, 29, 0, 0, 0, 0, time.UTC), stats.Buckets[0].Start)
+	counts := make([]int, 0)
+	for _, bucket := range stats.Buckets {
+		counts = append(counts, bucket.Rides)
+	}
+	assert.Equal(t, []int{0, 3, 1, 0, 0, 1}, counts)
+	assert.Equal(t, 5.0/3.0, stats.Buckets[1].AveragePassengers)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestSavings(t *testing.T) {
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{"test@domain.com", "john@domain.com", "jane@domain.com"},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_savings.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	for _, email := range []string{"test@domain.com", "john@domain.com", "jane@domain.com"} {
+		_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+		assert.Nil(t, err, "")
+	}
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+	_, err = CreateVehicle(ctx, &lCtx, &model.NewVehicle{EmailOwner: "jane@domain.com", Label: "Zoe", Seats: 5, FuelType: model.FuelTypeElectric})
+	assert.Nil(t, err, "")
+
+	day := time.Date(2022, time.September, 5, 7, 45, 0, 0, time.UTC)
+	ride, err := AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Date:              &day,
+		EmailConductor:    "john@domain.com",
+		EmailParticipants: []string{"john@domain.com", "test@domain.com", "jane@domain.com"},
+	})
+	assert.Nil(t, err, "")
+	assert.Nil(t, ride.DistanceKm, "Neither the ride nor the rotation has a distance")
+
+	distanceKm := 20.0
+	_, err = SetRotationDistance(ctx, &lCtx, 1, &distanceKm)
+	assert.Nil(t, err, "")
+	ride, err = FindRide(ctx, &lCtx, int64(ride.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, 20.0, *ride.DistanceKm)
+
+	// test has no vehicle and saves an average car, jane an electric one
+	savings, err := FindRideSavings(ctx, &lCtx, int64(ride.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, &model.Savings{KmShared: 40, Co2AvoidedKg: 4.2}, savings)
+
+	negativeKm := -1.0
+	_, err = AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		EmailConductor:    "jane@domain.com",
+		EmailParticipants: []string{"jane@domain.com", "john@domain.com"},
+		DistanceKm:        &negativeKm,
+	})
+	assert.NotNil(t, err, "")
+
+	day = day.AddDate(0, 0, 1)
+	shortKm := 12.5
+	ride, err = AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Date:              &day,
+		EmailConductor:    "jane@domain.com",
+		EmailParticipants: []string{"jane@domain.com", "john@domain.com"},
+		DistanceKm:        &shortKm,
+	})
+	assert.Nil(t, err, "")
+	assert.Equal(t, 12.5, *ride.DistanceKm)
+
+	cancelled, err := AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Date:              &day,
+		EmailConductor:    "test@domain.com",
+		EmailParticipants: []string{"test@domain.com", "john@domain.com"},
+	})
+	assert.Nil(t, err, "")
+	_, err = tx.ExecContext(ctx, "UPDATE Rides set statusCd=(select RefCd from RefRideStatus where RefName='CANCELLED') where id=?", cancelled.ID)
+	assert.Nil(t, err, "")
+
+	savings, err = FindRotationSavings(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &model.Savings{KmShared: 52.5, Co2AvoidedKg: 6.2}, savings)
+
+	from := time.Date(2022, time.September, 1, 0, 0, 0, 0, time.UTC)
+	stats, err := FindRotationStats(ctx, &lCtx, 1, from, from.AddDate(0, 1, 0), model.GranularityWeek)
+	assert.Nil(t, err, "")
+	assert.Equal(t, savings, stats.Savings)
+	assert.Equal(t, &model.Savings{}, stats.Buckets[0].Savings)
+	assert.Equal(t, savings, stats.Buckets[1].Savings)
+	memberSavings := map[string]*model.Savings{}
+	for _, member := range stats.Members {
+		memberSavings[member.User.Email] = member.Savings
+	}
+	assert.Equal(t, map[string]*model.Savings{
+		"test@domain.com": {KmShared: 20, Co2AvoidedKg: 3.2},
+		"jane@domain.com": {KmShared: 20, Co2AvoidedKg: 1},
+		"john@domain.com": {KmShared: 12.5, Co2AvoidedKg: 2},
+	}, memberSavings)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestMeetingPoint(t *testing.T) {
+	newRotation := model.NewRotation{
+		Name:              "TestRotation",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{"test@domain.com", "john@domain.com", "jane@domain.com"},
+	}
+
+	ctx := context.Background()
+	db := createNewDb(t, "../test_meetingpoint.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	for _, email := range []string{"test@domain.com", "john@domain.com", "jane@domain.com", "other@domain.com"} {
+		_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+		assert.Nil(t, err, "")
+	}
+	_, err = CreateRotation(ctx, &lCtx, &newRotation)
+	assert.Nil(t, err, "")
+	_, err = CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "OtherRotation", EmailCreator: "other@domain.com", EmailParticipants: []string{"other@domain.com"}})
+	assert.Nil(t, err, "")
+
+	_, err = CreateMeetingPoint(ctx, &lCtx, &model.NewMeetingPoint{IDRotation: 1, Name: " ", Latitude: 48.8, Longitude: 2.3})
+	assert.NotNil(t, err, "")
+	_, err = CreateMeetingPoint(ctx, &lCtx, &model.NewMeetingPoint{IDRotation: 1, Name: "Station", Latitude: 91, Longitude: 2.3})
+	assert.NotNil(t, err, "")
+
+	address := "1 place de la Gare"
+	station, err := CreateMeetingPoint(ctx, &lCtx, &model.NewMeetingPoint{IDRotation: 1, Name: "Station", Latitude: 48.84, Longitude: 2.37, Address: &address})
+	assert.Nil(t, err, "")
+	assert.Equal(t, address, *station.Address)
+	mall, err := CreateMeetingPoint(ctx, &lCtx, &model.NewMeetingPoint{IDRotation: 1, Name: "Mall", Latitude: 48.86, Longitude: 2.35})
+	assert.Nil(t, err, "")
+	office, err := CreateMeetingPoint(ctx, &lCtx, &model.NewMeetingPoint{IDRotation: 1, Name: "Office", Latitude: 48.89, Longitude: 2.24})
+	assert.Nil(t, err, "")
+	elsewhere, err := CreateMeetingPoint(ctx, &lCtx, &model.NewMeetingPoint{IDRotation: 2, Name: "Elsewhere", Latitude: 45.76, Longitude: 4.83})
+	assert.Nil(t, err, "")
+
+	routeNames := func(route []*model.MeetingPoint) []string {
+		names := make([]string, 0, len(route))
+		for _, meetingPoint := range route {
+			names = append(names, meetingPoint.Name)
+		}
+		return names
+	}
+	route, err := FindRoute(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []string{"Station", "Mall", "Office"}, routeNames(route))
+
+	_, err = SetRoute(ctx, &lCtx, 1, []int{office.ID, station.ID})
+	assert.NotNil(t, err, "Mall is missing")
+	_, err = SetRoute(ctx, &lCtx, 1, []int{office.ID, station.ID, station.ID, mall.ID})
+	assert.NotNil(t, err, "Station is given twice")
+	_, err = SetRoute(ctx, &lCtx, 1, []int{office.ID, station.ID, mall.ID, elsewhere.ID})
+	assert.NotNil(t, err, "Elsewhere is in another rotation")
+	route, err = SetRoute(ctx, &lCtx, 1, []int{office.ID, station.ID, mall.ID})
+	assert.Nil(t, err, "")
+	assert.Equal(t, []string{"Office", "Station", "Mall"}, routeNames(route))
+
+	_, err = SetPickup(ctx, &lCtx, 1, "other@domain.com", &station.ID)
+	assert.NotNil(t, err, "Not a participant")
+	_, err = SetPickup(ctx, &lCtx, 1, "jane@domain.com", &elsewhere.ID)
+	assert.NotNil(t, err, "Meeting point of another rotation")
+	pickup, err := SetPickup(ctx, &lCtx, 1, "jane@domain.com", &station.ID)
+	assert.Nil(t, err, "")
+	assert.Equal(t, "Station", pickup.MeetingPoint.Name)
+	_, err = SetPickup(ctx, &lCtx, 1, "test@domain.com", &mall.ID)
+	assert.Nil(t, err, "")
+
+	pickups, err := FindPickups(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 3, len(pickups))
+	assert.Equal(t, "jane@domain.com", pickups[0].User.Email)
+	assert.Equal(t, station.ID, pickups[0].MeetingPoint.ID)
+	assert.Nil(t, pickups[1].MeetingPoint, "john has no pickup point")
+	assert.Equal(t, mall.ID, pickups[2].MeetingPoint.ID)
+
+	stopNames := func(stops []*model.Stop) []string {
+		names := make([]string, 0, len(stops))
+		for _, stop := range stops {
+			for _, passenger := range stop.Passengers {
+				names = append(names, stop.MeetingPoint.Name+" "+passenger.Email)
+			}
+		}
+		return names
+	}
+	outbound := model.DirectionOutbound
+	ride, err := AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Direction:         &outbound,
+		EmailConductor:    "john@domain.com",
+		EmailParticipants: []string{"john@domain.com", "test@domain.com", "jane@domain.com"},
+	})
+	assert.Nil(t, err, "")
+	stops, err := FindRideStops(ctx, &lCtx, int64(ride.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, []string{"Station jane@domain.com", "Mall test@domain.com"}, stopNames(stops))
+
+	_, err = SetRidePickup(ctx, &lCtx, ride, "other@domain.com", &station.ID)
+	assert.NotNil(t, err, "Not a passenger")
+	_, err = SetRidePickup(ctx, &lCtx, ride, "test@domain.com", &station.ID)
+	assert.Nil(t, err, "")
+	stops, err = FindRideStops(ctx, &lCtx, int64(ride.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(stops))
+	assert.Equal(t, []string{"Station jane@domain.com", "Station test@domain.com"}, stopNames(stops))
+
+	// return rides go the other way
+	back := model.DirectionReturn
+	returnRide, err := AddRide(ctx, &lCtx, &model.NewRide{
+		IDRotation:        1,
+		Direction:         &back,
+		EmailConductor:    "john@domain.com",
+		EmailParticipants: []string{"john@domain.com", "test@domain.com", "jane@domain.com"},
+	})
+	assert.Nil(t, err, "")
+	stops, err = FindRideStops(ctx, &lCtx, int64(returnRide.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, []string{"Mall test@domain.com", "Station jane@domain.com"}, stopNames(stops))
+
+	// removing the station clears the pickups there
+	_, err = DeleteMeetingPoint(ctx, &lCtx, station)
+	assert.Nil(t, err, "")
+	stops, err = FindRideStops(ctx, &lCtx, int64(ride.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, []string{"Mall test@domain.com"}, stopNames(stops))
+	route, err = FindRoute(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, []string{"Office", "Mall"}, routeNames(route))
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestOrganization(t *testing.T) {
+	ctx := context.Background()
+	db := createNewDb(t, "../test_organization.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	for _, email := range []string{"alice@domain.com", "bob@domain.com", "carol@domain.com", "dave@domain.com"} {
+		_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+		assert.Nil(t, err, "")
+	}
+
+	_, err = CreateOrganization(ctx, &lCtx, " ", "alice@domain.com")
+	assert.NotNil(t, err, "")
+	sales, err := CreateOrganization(ctx, &lCtx, "Sales", "alice@domain.com")
+	assert.Nil(t, err, "")
+	_, err = CreateOrganization(ctx, &lCtx, "Sales", "bob@domain.com")
+	assert.NotNil(t, err, "Name already used")
+	_, err = CreateOrganization(ctx, &lCtx, "Support", "dave@domain.com")
+	assert.Nil(t, err, "")
+
+	err = AddOrganizationMembers(ctx, &lCtx, int64(sales.ID), []string{"bob@domain.com", "newbie@domain.com"})
+	assert.Nil(t, err, "")
+	members, err := FindOrganizationMembers(ctx, &lCtx, int64(sales.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, 3, len(members))
+	assert.Equal(t, model.OrgRoleAdmin, members[0].Role)
+	assert.Equal(t, model.OrgRoleMember, members[1].Role)
+	assert.Equal(t, model.RoleUnregistred, members[2].User.Role, "newbie is created")
+
+	// rotations of the organization are restricted to its members
+	_, err = CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "Office", EmailCreator: "dave@domain.com",
+		EmailParticipants: []string{"dave@domain.com"}, IDOrganization: &sales.ID})
+	assert.NotNil(t, err, "")
+	_, err = CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "Office", EmailCreator: "alice@domain.com",
+		EmailParticipants: []string{"alice@domain.com", "dave@domain.com"}, IDOrganization: &sales.ID})
+	assert.NotNil(t, err, "")
+	rotation, err := CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "Office", EmailCreator: "alice@domain.com",
+		EmailParticipants: []string{"alice@domain.com", "bob@domain.com"}, IDOrganization: &sales.ID})
+	assert.Nil(t, err, "")
+
+	organization, err := FindRotationOrganization(ctx, &lCtx, int64(rotation.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, "Sales", organization.Name)
+	rotations, err := FindOrganizationRotations(ctx, &lCtx, int64(sales.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(rotations))
+
+	err = CreateRotationParticipants(ctx, &lCtx, int64(rotation.ID), &[]string{"dave@domain.com"})
+	assert.NotNil(t, err, "")
+	err = CreateRotationParticipants(ctx, &lCtx, int64(rotation.ID), &[]string{"newbie@domain.com"})
+	assert.Nil(t, err, "")
+	invitations, err := FindPendingInvitations(ctx, &lCtx, int64(rotation.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(invitations))
+
+	generated, err := CreateJoinCode(ctx, &lCtx, &model.NewJoinCode{IDRotation: rotation.ID})
+	assert.Nil(t, err, "")
+	_, err = JoinRotation(ctx, &lCtx, generated.Code, "dave@domain.com")
+	assert.NotNil(t, err, "")
+
+	// members are only visible to their organizations
+	for _, visibility := range []struct {
+		viewer, email string
+		visible       bool
+	}{
+		{"alice@domain.com", "bob@domain.com", true},
+		{"dave@domain.com", "bob@domain.com", false},
+		{"dave@domain.com", "dave@domain.com", true},
+		{"bob@domain.com", "carol@domain.com", true},
+		{"carol@domain.com", "bob@domain.com", false},
+	} {
+		visible, err := IsUserVisible(ctx, &lCtx, visibility.viewer, visibility.email)
+		assert.Nil(t, err, "")
+		assert.Equal(t, visibility.visible, visible, visibility.viewer+" sees "+visibility.email)
+	}
+
+	// rotations are visible to their participants, creator and organization
+	carpool, err := CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "Carpool", EmailCreator: "dave@domain.com",
+		EmailParticipants: []string{"dave@domain.com", "carol@domain.com"}})
+	assert.Nil(t, err, "")
+	dave := "dave@domain.com"
+	for _, visibility := range []struct {
+		viewer    string
+		email     *string
+		rotations []int
+	}{
+		{"alice@domain.com", nil, []int{rotation.ID}},
+		{"newbie@domain.com", nil, []int{rotation.ID}},
+		{"carol@domain.com", nil, []int{carpool.ID}},
+		{"dave@domain.com", nil, []int{carpool.ID}},
+		{"alice@domain.com", &dave, []int{}},
+	} {
+		rotations, err := FindVisibleRotations(ctx, &lCtx, visibility.viewer, visibility.email)
+		assert.Nil(t, err, "")
+		ids := make([]int, 0, len(rotations))
+		for _, rotation := range rotations {
+			ids = append(ids, rotation.ID)
+		}
+		assert.Equal(t, visibility.rotations, ids, visibility.viewer+" sees rotations")
+	}
+
+	// an organization keeps an admin
+	_, err = SetOrganizationRole(ctx, &lCtx, int64(sales.ID), "alice@domain.com", model.OrgRoleMember)
+	assert.NotNil(t, err, "")
+	_, err = SetOrganizationRole(ctx, &lCtx, int64(sales.ID), "dave@domain.com", model.OrgRoleAdmin)
+	assert.NotNil(t, err, "Not a member")
+	member, err := SetOrganizationRole(ctx, &lCtx, int64(sales.ID), "bob@domain.com", model.OrgRoleAdmin)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.OrgRoleAdmin, member.Role)
+	_, err = SetOrganizationRole(ctx, &lCtx, int64(sales.ID), "alice@domain.com", model.OrgRoleMember)
+	assert.Nil(t, err, "")
+	err = RemoveOrganizationMembers(ctx, &lCtx, int64(sales.ID), []string{"bob@domain.com"})
+	assert.NotNil(t, err, "")
+
+	// removed members leave the rotations of the organization
+	err = RemoveOrganizationMembers(ctx, &lCtx, int64(sales.ID), []string{"alice@domain.com"})
+	assert.Nil(t, err, "")
+	participants, err := FindRotationParticipants(ctx, &lCtx, int64(rotation.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(participants))
+	assert.Equal(t, "bob@domain.com", participants[0].Email)
+	organizations, err := FindUserOrganizations(ctx, &lCtx, "alice@domain.com")
+	assert.Nil(t, err, "")
+	assert.Equal(t, 0, len(organizations))
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestUserSearch(t *testing.T) {
+	ctx := context.Background()
+	db := createNewDb(t, "../test_usersearch.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	for _, names := range [][]string{
+		{"john.doe@domain.com", "John", "Doe"},
+		{"jane.smith@domain.com", "Jane", "Smith"},
+		{"bob@johnson.org", "Bob", "Johnson"},
+		{"sonia@corp.com", "Sonia", "Lopez"},
+		{"old@domain.com", "Old", "Timer"},
+	} {
+		_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: names[0], FirstName: &names[1], LastName: &names[2]})
+		assert.Nil(t, err, "")
+	}
+	oldEmail := "old@domain.com"
+	old, err := FindUser(ctx, &lCtx, &oldEmail)
+	assert.Nil(t, err, "")
+	_, err = DeleteUser(ctx, &lCtx, old)
+	assert.Nil(t, err, "")
+
+	// the index follows the registration
+	invitee, err := createUnregisteredUser(ctx, &lCtx, "invitee@domain.com")
+	assert.Nil(t, err, "")
+	firstName, lastName := "Johnny", "Walker"
+	_, err = RegisterUser(ctx, &lCtx, invitee, &firstName, &lastName)
+	assert.Nil(t, err, "")
+
+	// john has no organization, everyone is visible to him
+	viewer := "john.doe@domain.com"
+	search := func(viewer string, term string) []string {
+		connection, err := SearchUsers(ctx, &lCtx, viewer, &term, nil, nil)
+		assert.Nil(t, err, "")
+		emails := make([]string, 0)
+		for _, user := range connection.Users {
+			emails = append(emails, user.Email)
+		}
+		return emails
+	}
+	assert.Equal(t, []string{"bob@johnson.org", "invitee@domain.com", "john.doe@domain.com"}, search(viewer, "jo"))
+	assert.Equal(t, []string{"bob@johnson.org", "invitee@domain.com", "john.doe@domain.com"}, search(viewer, "ohn"))
+	assert.Equal(t, []string{"jane.smith@domain.com"}, search(viewer, "JANE sm"))
+	assert.Equal(t, []string{"sonia@corp.com", "bob@johnson.org"}, search(viewer, "son"), "Prefix matches first")
+	assert.Equal(t, []string{}, search(viewer, "old"), "Deleted users are excluded")
+	assert.Equal(t, 5, len(search(viewer, "@")))
+
+	// the directory is paged by email
+	first := 2
+	pages := make([][]string, 0)
+	var after *string
+	for {
+		connection, err := SearchUsers(ctx, &lCtx, viewer, nil, &first, after)
+		assert.Nil(t, err, "")
+		page := make([]string, 0)
+		for _, user := range connection.Users {
+			page = append(page, user.Email)
+		}
+		pages = append(pages, page)
+		if !connection.HasNextPage {
+			break
+		}
+		after = connection.EndCursor
+	}
+	assert.Equal(t, [][]string{
+		{"bob@johnson.org", "invitee@domain.com"},
+		{"jane.smith@domain.com", "john.doe@domain.com"},
+		{"sonia@corp.com"},
+	}, pages)
+
+	first = 0
+	_, err = SearchUsers(ctx, &lCtx, viewer, nil, &first, nil)
+	assert.NotNil(t, err, "")
+	invalidCursor := "not a cursor"
+	_, err = SearchUsers(ctx, &lCtx, viewer, nil, nil, &invalidCursor)
+	assert.NotNil(t, err, "")
+
+	// members of an organization are hidden from the others
+	_, err = CreateOrganization(ctx, &lCtx, "Sales", "jane.smith@domain.com")
+	assert.Nil(t, err, "")
+	sonia, jane := "sonia@corp.com", "jane.smith@domain.com"
+	assert.Equal(t, []string{}, search(sonia, "jane"))
+	assert.Equal(t, []string{"jane.smith@domain.com"}, search(jane, "jane"))
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	ctx := context.Background()
+	db := createNewDb(t, "../test_profile.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	email, firstName, lastName := "john.doe@domain.com", "John", "Doe"
+	_, err = CreateUser(ctx, &lCtx, &model.NewUser{Email: email, FirstName: &firstName, LastName: &lastName})
+	assert.Nil(t, err, "")
+
+	// fields left out are unchanged, empty ones cleared
+	newFirstName, empty, phone, profile := "Johnny", "", "+33 6 12 34 56 78", "Drives a van"
+	reminded := false
+	user, err := UpdateProfile(ctx, &lCtx, email, &model.NewProfile{
+		FirstName:               &newFirstName,
+		LastName:                &empty,
+		Profile:                 &profile,
+		Phone:                   &phone,
+		NotificationPreferences: &model.NewProfileNotificationPreferences{DriverReminder: &reminded},
+	})
+	assert.Nil(t, err, "")
+	assert.Equal(t, "Johnny", *user.FirstName)
+	assert.Nil(t, user.LastName)
+	assert.Equal(t, "Drives a van", *user.Profile)
+	assert.Equal(t, "+33 6 12 34 56 78", *user.Phone)
+	preferences, err := FindNotificationPreferences(ctx, &lCtx, email)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.NotificationPreferences{AddedToRotation: true, DriverReminder: false, RideCancelled: true}, *preferences)
+
+	user, err = UpdateProfile(ctx, &lCtx, email, &model.NewProfile{LastName: &lastName})
+	assert.Nil(t, err, "")
+	assert.Equal(t, "Johnny", *user.FirstName)
+	assert.Equal(t, "Doe", *user.LastName)
+	assert.Equal(t, "+33 6 12 34 56 78", *user.Phone)
+
+	// the new names are searchable
+	search := "johnny"
+	connection, err := SearchUsers(ctx, &lCtx, email, &search, nil, nil)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(connection.Users))
+
+	invalidPhone := "call me"
+	_, err = UpdateProfile(ctx, &lCtx, email, &model.NewProfile{Phone: &invalidPhone})
+	assert.NotNil(t, err, "")
+	unknown := "unknown@domain.com"
+	_, err = UpdateProfile(ctx, &lCtx, unknown, &model.NewProfile{FirstName: &firstName})
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// avatar
+	assert.Nil(t, user.AvatarURL)
+	name := "0123456789abcdef0123456789abcdef"
+	previous, err := SetUserAvatar(ctx, &lCtx, email, &name)
+	assert.Nil(t, err, "")
+	assert.Nil(t, previous)
+	user, err = FindUser(ctx, &lCtx, &email)
+	assert.Nil(t, err, "")
+	assert.Equal(t, "/avatars/"+name+"-256.png", *user.AvatarURL)
+	assert.Equal(t, "/avatars/"+name+"-64.png", *user.AvatarThumbnailURL)
+
+	previous, err = SetUserAvatar(ctx, &lCtx, email, nil)
+	assert.Nil(t, err, "")
+	assert.Equal(t, name, *previous)
+	user, err = FindUser(ctx, &lCtx, &email)
+	assert.Nil(t, err, "")
+	assert.Nil(t, user.AvatarURL)
+	assert.Nil(t, user.AvatarThumbnailURL)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	db := createNewDb(t, "../test_session.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	email := "john.doe@domain.com"
+	_, err = FindOrCreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+	assert.Nil(t, err, "")
+
+	token, err := CreateSession(ctx, &lCtx, email, time.Hour)
+	assert.Nil(t, err, "")
+	found, err := FindSessionUser(ctx, &lCtx, token)
+	assert.Nil(t, err, "")
+	assert.Equal(t, email, found)
+
+	_, err = FindSessionUser(ctx, &lCtx, "unknown")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	expired, err := CreateSession(ctx, &lCtx, email, -time.Hour)
+	assert.Nil(t, err, "")
+	_, err = FindSessionUser(ctx, &lCtx, expired)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// expired sessions are purged by the next login
+	_, err = CreateSession(ctx, &lCtx, email, time.Hour)
+	assert.Nil(t, err, "")
+	var count int
+	assert.Nil(t, tx.QueryRowContext(ctx, "select count(*) from Sessions").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	err = DeleteSession(ctx, &lCtx, token)
+	assert.Nil(t, err, "")
+	_, err = FindSessionUser(ctx, &lCtx, token)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// sessions of deleted users are closed
+	other, err := CreateSession(ctx, &lCtx, email, time.Hour)
+	assert.Nil(t, err, "")
+	user, err := FindUser(ctx, &lCtx, &email)
+	assert.Nil(t, err, "")
+	_, err = DeleteUser(ctx, &lCtx, user)
+	assert.Nil(t, err, "")
+	_, err = FindSessionUser(ctx, &lCtx, other)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	ctx := context.Background()
+	db := createNewDb(t, "../test_apitoken.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	email := "bot.owner@domain.com"
+	_, err = FindOrCreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+	assert.Nil(t, err, "")
+
+	generated, err := CreateAPIToken(ctx, &lCtx, email, &model.NewAPIToken{
+		Name:   "chat bot",
+		Scopes: []model.TokenScope{model.TokenScopeRidesWrite, model.TokenScopeReadOnly, model.TokenScopeReadOnly},
+	})
+	assert.Nil(t, err, "")
+	assert.True(t, strings.HasPrefix(generated.Token, "wdp_"))
+	assert.Equal(t, "chat bot", generated.APIToken.Name)
+	assert.Equal(t, []model.TokenScope{model.TokenScopeReadOnly, model.TokenScopeRidesWrite}, generated.APIToken.Scopes)
+	assert.Nil(t, generated.APIToken.ExpiresAt)
+	assert.Nil(t, generated.APIToken.LastUsedAt)
+
+	validDays := 30
+	expiring, err := CreateAPIToken(ctx, &lCtx, email, &model.NewAPIToken{Name: "cron", Scopes: []model.TokenScope{model.TokenScopeReadOnly}, ValidDays: &validDays})
+	assert.Nil(t, err, "")
+	assert.NotNil(t, expiring.APIToken.ExpiresAt)
+
+	_, err = CreateAPIToken(ctx, &lCtx, email, &model.NewAPIToken{Name: " ", Scopes: []model.TokenScope{model.TokenScopeReadOnly}})
+	assert.NotNil(t, err, "A name is required")
+	_, err = CreateAPIToken(ctx, &lCtx, email, &model.NewAPIToken{Name: "none", Scopes: []model.TokenScope{}})
+	assert.NotNil(t, err, "A scope is required")
+
+	// authentication records the use of the token
+	now := time.Now()
+	authenticated, scopes, err := AuthenticateAPIToken(ctx, &lCtx, generated.Token, now)
+	assert.Nil(t, err, "")
+	assert.Equal(t, email, authenticated)
+	assert.Equal(t, []model.TokenScope{model.TokenScopeReadOnly, model.TokenScopeRidesWrite}, scopes)
+	apiToken, err := FindAPIToken(ctx, &lCtx, int64(generated.APIToken.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, now.UTC().Truncate(time.Second), apiToken.LastUsedAt.UTC())
+
+	_, _, err = AuthenticateAPIToken(ctx, &lCtx, "wdp_unknown", now)
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, _, err = AuthenticateAPIToken(ctx, &lCtx, expiring.Token, now.AddDate(0, 0, 31))
+	assert.Equal(t, sql.ErrNoRows, err, "Expired")
+
+	tokens, err := FindAPITokens(ctx, &lCtx, email, now)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(tokens))
+	owner, err := FindAPITokenOwner(ctx, &lCtx, int64(generated.APIToken.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, email, owner)
+
+	// revoked tokens are refused
+	_, err = RevokeAPIToken(ctx, &lCtx, apiToken)
+	assert.Nil(t, err, "")
+	_, _, err = AuthenticateAPIToken(ctx, &lCtx, generated.Token, now)
+	assert.Equal(t, sql.ErrNoRows, err)
+	tokens, err = FindAPITokens(ctx, &lCtx, email, now)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(tokens))
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+func TestImportRides(t *testing.T) {
+	ctx := context.Background()
+	db := createNewDb(t, "../test_import.sqlite3", "../assets/ddl.whosdriving-core")
+	if db == nil {
+		t.Fatal("Couldn't create database connexion")
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	// Create database context
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	john, jane, other := "John", "Jane", "Doe"
+	for _, user := range []*model.NewUser{
+		{Email: "john.doe@domain.com", FirstName: &john, LastName: &other},
+		{Email: "jane.doe@domain.com", FirstName: &jane, LastName: &other},
+		{Email: "john.doe@other.com", FirstName: &john, LastName: &other},
+	} {
+		_, err := CreateUser(ctx, &lCtx, user)
+		assert.Nil(t, err, "")
+	}
+	rotation, err := CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "Office", EmailCreator: "jane.doe@domain.com",
+		EmailParticipants: []string{"jane.doe@domain.com", "john.doe@domain.com"}})
+	assert.Nil(t, err, "")
+
+	_, err = ImportRides(ctx, &lCtx, 999, nil)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	date := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	report, err := ImportRides(ctx, &lCtx, int64(rotation.ID), []*ImportedRide{
+		// The homonym participating in the rotation
+		{Row: 1, Date: date, Driver: "  john   DOE ", Passengers: []string{"Jane Doe", "New.Comer@domain.com", "jane.doe@domain.com"}},
+		{Row: 2, Date: date.AddDate(0, 0, 1), Driver: "Jack Doe"},
+	})
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, report.Rows)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, []string{"new.comer@domain.com"}, report.CreatedUsers)
+	assert.Equal(t, []*model.ImportRowError{{Row: 2, Message: "no user named Jack Doe, use an email"}}, report.Errors)
+
+	rides, err := FindRides(ctx, &lCtx, int64(rotation.ID))
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(rides))
+	assert.Equal(t, "john.doe@domain.com", rides[0].Conductor.Email)
+	assert.Equal(t, date, rides[0].Date.UTC())
+	assert.Equal(t, 2, len(rides[0].Participants), "Without duplicate")
+
+	newComer := "new.comer@domain.com"
+	user, err := FindUser(ctx, &lCtx, &newComer)
+	assert.Nil(t, err, "")
+	assert.Equal(t, model.RoleUnregistred, user.Role)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+// baselineDdl is the schema before it was versioned
+const baselineDdl = `
+CREATE TABLE RefRole(RefCd INTEGER NOT NULL PRIMARY KEY, RefName TEXT NOT NULL UNIQUE);
+INSERT into RefRole(RefCd, RefName) values (0, 'ADMIN'), (1, 'STANDARD'), (2, 'UNREGISTRED');
+CREATE TABLE Users(email TEXT PRIMARY KEY, password TEXT NULL, firstname TEXT NULL, lastname TEXT NULL, profile TEXT NULL,
+	roleCd INT NOT NULL, createTmstmp DATETIME NOT NULL, lstUpdTmstmp DATETIME NOT NULL, deleteTmstmp DATETIME NULL);
+CREATE TABLE Rotations(id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, creatorEmail TEXT NOT NULL,
+	createTmstmp DATETIME NOT NULL, lstUpdTmstmp DATETIME NOT NULL, deleteTmstmp DATETIME NULL,
+	UNIQUE(deleteTmstmp, creatorEmail, name),
+	FOREIGN KEY (creatorEmail) REFERENCES Users (email) ON DELETE RESTRICT ON UPDATE RESTRICT);
+CREATE TABLE RotationParticipants(rotationId INT NOT NULL, email TEXT NOT NULL, PRIMARY KEY (rotationId, email),
+	FOREIGN KEY (rotationId) REFERENCES Rotation (id) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (email) REFERENCES Users (email) ON DELETE RESTRICT ON UPDATE RESTRICT) WITHOUT ROWID;
+CREATE TABLE Rides(id INTEGER NOT NULL PRIMARY KEY, rotationId INT NOT NULL, riderEmail TEXT NOT NULL,
+	createTmstmp DATETIME NOT NULL, lstUpdTmstmp DATETIME NOT NULL, deleteTmstmp DATETIME NULL,
+	FOREIGN KEY (rotationId) REFERENCES Rotation (id) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (riderEmail) REFERENCES Users (email) ON DELETE RESTRICT ON UPDATE RESTRICT);
+CREATE TABLE RideParticipants(rideId INTEGER NOT NULL, email TEXT NOT NULL, PRIMARY KEY (rideId, email),
+	FOREIGN KEY (rideId) REFERENCES Rides (id) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (email) REFERENCES Users (email) ON DELETE RESTRICT ON UPDATE RESTRICT) WITHOUT ROWID;
+
+INSERT into Users(email, firstname, lastname, roleCd, createTmstmp, lstUpdTmstmp)
+	values ('john@domain.com', 'John', 'Smith', 1, '2021-03-01 08:00:00', '2021-03-01 08:00:00'),
+	('jane@domain.com', 'Jane', 'Doe', 1, '2021-03-01 08:00:00', '2021-03-01 08:00:00');
+INSERT into Rotations(id, name, creatorEmail, createTmstmp, lstUpdTmstmp) values (1, 'Office', 'john@domain.com', '2021-03-01 08:00:00', '2021-03-01 08:00:00');
+INSERT into RotationParticipants(rotationId, email) values (1, 'john@domain.com'), (1, 'jane@domain.com');
+INSERT into Rides(id, rotationId, riderEmail, createTmstmp, lstUpdTmstmp) values (1, 1, 'jane@domain.com', '2021-03-02 08:00:00', '2021-03-02 08:00:00');
+INSERT into RideParticipants(rideId, email) values (1, 'john@domain.com'), (1, 'jane@domain.com');
+`
+
+func TestMigrateBaseline(t *testing.T) {
+	assert.Equal(t, SchemaVersion, len(migrations), "A migration per version")
+
+	dbPath := "../test_migrate.sqlite3"
+	os.Remove(dbPath)
+	db, err := NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(baselineDdl); err != nil {
+		t.Fatalf("Could't create the baseline schema - %s", err)
+	}
+	assert.Nil(t, Migrate("../assets/ddl.whosdriving-core", db), "")
+	version, err := FindSchemaVersion(db)
+	assert.Nil(t, err, "")
+	assert.Equal(t, SchemaVersion, version)
+	assert.Nil(t, Migrate("../assets/ddl.whosdriving-core", db), "Migrating again changes nothing")
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	ride, err := FindRide(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, time.Date(2021, 3, 2, 8, 0, 0, 0, time.UTC), ride.Date.UTC(), "Recorded at its creation")
+	assert.Equal(t, model.RideStatusRecorded, ride.Status)
+	assert.Equal(t, "jane@domain.com", ride.Conductor.Email)
+	assert.Equal(t, 2, len(ride.Participants))
+
+	rotation, err := FindRotation(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, len(rotation.Participants))
+	assert.Equal(t, 1, len(rotation.Rides))
+
+	john := "john@domain.com"
+	jane := "jane"
+	users, err := SearchUsers(ctx, &lCtx, john, &jane, nil, nil)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(users.Users), "Indexed by the migration")
+
+	newRide, err := AddRide(ctx, &lCtx, &model.NewRide{IDRotation: 1, EmailConductor: john, EmailParticipants: []string{john, "jane@domain.com"}})
+	assert.Nil(t, err, "")
+	assert.Equal(t, john, newRide.Conductor.Email)
+
+	token, err := GenerateCalendarToken(ctx, &lCtx, john)
+	assert.Nil(t, err, "")
+	user, err := FindUserByCalendarToken(ctx, &lCtx, token)
+	assert.Nil(t, err, "")
+	assert.Equal(t, john, user.Email)
+}
+
+func TestMigrateFuelTypes(t *testing.T) {
+	dbPath := "../test_migrate_fuel.sqlite3"
+	os.Remove(dbPath)
+	db, err := NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	// The fuel types and vehicles without the emissions
+	if _, err := db.Exec(baselineDdl + `
+		CREATE TABLE RefFuelType(RefCd INTEGER NOT NULL PRIMARY KEY, RefName TEXT NOT NULL UNIQUE);
+		INSERT into RefFuelType(RefCd, RefName) values (0, 'PETROL'), (1, 'DIESEL'), (2, 'HYBRID'), (3, 'ELECTRIC'), (4, 'LPG');
+		CREATE TABLE Vehicles(id INTEGER NOT NULL PRIMARY KEY, ownerEmail TEXT NOT NULL, label TEXT NOT NULL, seats INT NOT NULL,
+			fuelTypeCd INT NOT NULL, consumption REAL NULL, createTmstmp DATETIME NOT NULL, lstUpdTmstmp DATETIME NOT NULL, deleteTmstmp DATETIME NULL);
+		INSERT into Vehicles(id, ownerEmail, label, seats, fuelTypeCd, createTmstmp, lstUpdTmstmp)
+			values (1, 'john@domain.com', 'Zoe', 5, 3, '2021-03-01 08:00:00', '2021-03-01 08:00:00');
+	`); err != nil {
+		t.Fatalf("Could't create the schema - %s", err)
+	}
+	assert.Nil(t, Migrate("../assets/ddl.whosdriving-core", db), "")
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+	lCtx := LuwContext{Conn: db, Tx: tx}
+
+	distanceKm := 20.0
+	_, err = SetRotationDistance(ctx, &lCtx, 1, &distanceKm)
+	assert.Nil(t, err, "")
+	ride, err := FindRide(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 20.0, *ride.DistanceKm)
+
+	// john, the passenger, has an electric car
+	savings, err := FindRideSavings(ctx, &lCtx, 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, &model.Savings{KmShared: 20, Co2AvoidedKg: 1}, savings)
+}
+
+func TestMigrateFuelTypesVersion1(t *testing.T) {
+	dbPath := "../test_migrate_fuel_v1.sqlite3"
+	os.Remove(dbPath)
+	db, err := NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	// Migrated to the version 1 before the emissions were backfilled
+	assert.Nil(t, Migrate("../assets/ddl.whosdriving-core", db), "")
+	if _, err := db.Exec("UPDATE RefFuelType SET co2GramsPerKm = 0; PRAGMA user_version = 1"); err != nil {
+		t.Fatalf("Could't reset the emissions - %s", err)
+	}
+	assert.Nil(t, Migrate("../assets/ddl.whosdriving-core", db), "")
+	version, err := FindSchemaVersion(db)
+	assert.Nil(t, err, "")
+	assert.Equal(t, SchemaVersion, version)
+
+	var co2GramsPerKm int
+	err = db.QueryRow("select co2GramsPerKm from RefFuelType where RefName = 'ELECTRIC'").Scan(&co2GramsPerKm)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 50, co2GramsPerKm)
+}
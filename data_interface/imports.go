@@ -0,0 +1,184 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+// ImportedRide is a ride read from an import file, its driver and passengers are emails or names
+type ImportedRide struct {
+	// 1 for the first ride of the file
+	Row        int
+	Date       time.Time
+	Direction  *model.Direction
+	DistanceKm *float64
+	Driver     string
+	Passengers []string
+}
+
+// normalizeName compares the names regardless of case and spacing
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// rideImport resolves the people of the imported rides
+type rideImport struct {
+	rotationId int64
+	// Emails by normalized first and last names, loaded on the first name
+	names        map[string][]string
+	participants map[string]bool
+	report       *model.RidesImport
+}
+
+func (i *rideImport) loadNames(ctx context.Context, lCtx *LuwContext) error {
+	const q string = `select email, coalesce(firstname, ''), coalesce(lastname, '') from Users where deleteTmstmp is null`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	i.names = make(map[string][]string)
+	for rows.Next() {
+		var email, firstName, lastName string
+		if err := rows.Scan(&email, &firstName, &lastName); err != nil {
+			// Check for a scan error.
+			return err
+		}
+		name := normalizeName(firstName + " " + lastName)
+		i.names[name] = append(i.names[name], email)
+	}
+	rows.Close()
+
+	participants, err := FindRotationParticipants(ctx, lCtx, i.rotationId)
+	if err != nil {
+		return err
+	}
+	i.participants = make(map[string]bool, len(participants))
+	for _, participant := range participants {
+		i.participants[participant.Email] = true
+	}
+	return nil
+}
+
+// resolve returns the email of the person, an unknown email is created as an UNREGISTRED user.
+// A name must be of a single user, of a single participant of the rotation when homonyms.
+func (i *rideImport) resolve(ctx context.Context, lCtx *LuwContext, person string) (string, error) {
+	person = strings.TrimSpace(person)
+	if strings.Contains(person, "@") {
+		email := strings.ToLower(person)
+		_, err := FindUser(ctx, lCtx, &email)
+		if err == sql.ErrNoRows {
+			if _, err := createUnregisteredUser(ctx, lCtx, email); err != nil {
+				return "", err
+			}
+			i.report.CreatedUsers = append(i.report.CreatedUsers, email)
+			return email, nil
+		}
+		return email, err
+	}
+
+	if i.names == nil {
+		if err := i.loadNames(ctx, lCtx); err != nil {
+			return "", err
+		}
+	}
+	emails := i.names[normalizeName(person)]
+	if len(emails) > 1 {
+		participants := make([]string, 0, len(emails))
+		for _, email := range emails {
+			if i.participants[email] {
+				participants = append(participants, email)
+			}
+		}
+		emails = participants
+	}
+	switch len(emails) {
+	case 0:
+		return "", &importError{fmt.Sprintf("no user named %s, use an email", person)}
+	case 1:
+		return emails[0], nil
+	default:
+		return "", &importError{fmt.Sprintf("several users named %s, use an email", person)}
+	}
+}
+
+// importError is an error of a row, the import goes on with the other ones
+type importError struct {
+	message string
+}
+
+func (e *importError) Error() string {
+	return e.message
+}
+
+func (i *rideImport) addError(row int, err error) {
+	i.report.Errors = append(i.report.Errors, &model.ImportRowError{Row: row, Message: err.Error()})
+}
+
+// importRide records the ride, the errors of the row are reported
+func (i *rideImport) importRide(ctx context.Context, lCtx *LuwContext, ride *ImportedRide) error {
+	driver, err := i.resolve(ctx, lCtx, ride.Driver)
+	if err != nil {
+		return err
+	}
+	participants := make([]string, 0, len(ride.Passengers))
+	seen := map[string]bool{driver: true}
+	for _, passenger := range ride.Passengers {
+		email, err := i.resolve(ctx, lCtx, passenger)
+		if err != nil {
+			return err
+		}
+		if !seen[email] {
+			seen[email] = true
+			participants = append(participants, email)
+		}
+	}
+
+	date := ride.Date
+	_, err = AddRide(ctx, lCtx, &model.NewRide{
+		IDRotation:        int(i.rotationId),
+		Date:              &date,
+		Direction:         ride.Direction,
+		DistanceKm:        ride.DistanceKm,
+		EmailConductor:    driver,
+		EmailParticipants: participants,
+	})
+	if err != nil {
+		// The other errors come from the database
+		return &importError{err.Error()}
+	}
+	i.report.Imported++
+	return nil
+}
+
+// ImportRides records the rides in the rotation with AddRide, the rows in error are reported and skipped.
+// The caller commits only without errors for the import to be all or nothing.
+func ImportRides(ctx context.Context, lCtx *LuwContext, rotationId int64, rides []*ImportedRide) (*model.RidesImport, error) {
+	if _, err := FindRotation(ctx, lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	i := rideImport{
+		rotationId: rotationId,
+		report: &model.RidesImport{
+			Rows:         len(rides),
+			CreatedUsers: make([]string, 0),
+			Errors:       make([]*model.ImportRowError, 0),
+		},
+	}
+	for _, ride := range rides {
+		err := i.importRide(ctx, lCtx, ride)
+		if _, ok := err.(*importError); ok {
+			i.addError(ride.Row, err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return i.report, nil
+}
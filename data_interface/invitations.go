@@ -0,0 +1,197 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"whosdriving-be/graph/model"
+)
+
+// IssuedInvitation is an invitation with the token to send to its user
+type IssuedInvitation struct {
+	RotationID   int64
+	RotationName string
+	Invitation   *model.Invitation
+	Token        string
+}
+
+func FindInvitation(ctx context.Context, lCtx *LuwContext, id int64) (*model.Invitation, error) {
+	const q string = `select i.id, i.email, s.RefName, i.createTmstmp
+						from Invitations i left join RefInvitationStatus s on i.statusCd = s.RefCd
+						where i.id = ?`
+
+	invitation := new(model.Invitation)
+	var email string
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&invitation.ID,
+		&email,
+		&invitation.Status,
+		&invitation.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	user, err := FindUser(ctx, lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+	invitation.User = user
+
+	return invitation, nil
+}
+
+func FindPendingInvitations(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.Invitation, error) {
+	const q string = `select i.id
+						from Invitations i
+						where i.rotationId = ? and i.statusCd = (select RefCd from RefInvitationStatus where RefName='PENDING')
+						order by i.email`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitationIds := make([]int64, 0)
+	for rows.Next() {
+		var invitationId int64
+		if err := rows.Scan(&invitationId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		invitationIds = append(invitationIds, invitationId)
+	}
+	rows.Close()
+
+	invitations := make([]*model.Invitation, 0, len(invitationIds))
+	for _, invitationId := range invitationIds {
+		invitation, err := FindInvitation(ctx, lCtx, invitationId)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, nil
+}
+
+// createInvitation invites the user to the rotation, unless an invitation is already pending
+func createInvitation(ctx context.Context, lCtx *LuwContext, rotationId int64, email string) error {
+	const q string = `INSERT OR IGNORE INTO Invitations(rotationId, email, statusCd, tokenHash, createTmstmp, lstUpdTmstmp)
+						VALUES (?, ?, (select RefCd from RefInvitationStatus where RefName='PENDING'), null, DATETIME('now'), DATETIME('now'))`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, rotationId, email)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Invite %s to rotation %d", email, rotationId)
+	return nil
+}
+
+// IssueInvitationTokens generates the tokens of the pending invitations of the rotation not sent yet
+func IssueInvitationTokens(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*IssuedInvitation, error) {
+	const q string = `select i.id, r.name
+						from Invitations i join Rotations r on r.id = i.rotationId
+						where i.rotationId = ? and i.tokenHash is null
+						and i.statusCd = (select RefCd from RefInvitationStatus where RefName='PENDING')
+						order by i.id`
+	const qUpdate string = `UPDATE Invitations set tokenHash=?, lstUpdTmstmp=DATETIME('now') WHERE id=?`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issued := make([]*IssuedInvitation, 0)
+	for rows.Next() {
+		var invitationId int64
+		invitation := &IssuedInvitation{RotationID: rotationId}
+		if err := rows.Scan(&invitationId, &invitation.RotationName); err != nil {
+			return nil, err
+		}
+		invitation.Invitation = &model.Invitation{ID: int(invitationId)}
+		issued = append(issued, invitation)
+	}
+	rows.Close()
+
+	for _, invitation := range issued {
+		token, tokenHash, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := lCtx.Tx.ExecContext(ctx, qUpdate, tokenHash, invitation.Invitation.ID); err != nil {
+			return nil, err
+		}
+		invitation.Token = token
+
+		invitation.Invitation, err = FindInvitation(ctx, lCtx, int64(invitation.Invitation.ID))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return issued, nil
+}
+
+func findPendingInvitationByToken(ctx context.Context, lCtx *LuwContext, token string) (int64, int64, error) {
+	const q string = `select i.id, i.rotationId
+						from Invitations i
+						where i.tokenHash = ? and i.statusCd = (select RefCd from RefInvitationStatus where RefName='PENDING')`
+
+	var invitationId, rotationId int64
+	if err := lCtx.Tx.QueryRowContext(ctx, q, hashToken(token)).Scan(&invitationId, &rotationId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, 0, fmt.Errorf("invalid or expired invitation")
+		}
+		return 0, 0, err
+	}
+	return invitationId, rotationId, nil
+}
+
+func respondInvitation(ctx context.Context, lCtx *LuwContext, invitationId int64, status model.InvitationStatus) (*model.Invitation, error) {
+	const q string = `UPDATE Invitations set statusCd=(select RefCd from RefInvitationStatus where RefName=?),
+					lstUpdTmstmp=DATETIME('now'), respondTmstmp=DATETIME('now')
+				WHERE id=?`
+
+	if _, err := lCtx.Tx.ExecContext(ctx, q, status, invitationId); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Invitation %d %s", invitationId, status)
+	return FindInvitation(ctx, lCtx, invitationId)
+}
+
+// AcceptInvitation registers the invited user and adds them to the rotation
+func AcceptInvitation(ctx context.Context, lCtx *LuwContext, token string, firstName *string, lastName *string) (*model.Rotation, error) {
+	invitationId, rotationId, err := findPendingInvitationByToken(ctx, lCtx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation, err := respondInvitation(ctx, lCtx, invitationId, model.InvitationStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := RegisterUser(ctx, lCtx, invitation.User, firstName, lastName); err != nil {
+		return nil, err
+	}
+
+	if err := insertRotationParticipant(ctx, lCtx, rotationId, invitation.User.Email); err != nil {
+		return nil, err
+	}
+
+	return FindRotation(ctx, lCtx, rotationId)
+}
+
+func DeclineInvitation(ctx context.Context, lCtx *LuwContext, token string) (*model.Invitation, error) {
+	invitationId, _, err := findPendingInvitationByToken(ctx, lCtx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return respondInvitation(ctx, lCtx, invitationId, model.InvitationStatusDeclined)
+}
@@ -0,0 +1,317 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+const defaultJoinCodeValidDays = 7
+
+func FindJoinCode(ctx context.Context, lCtx *LuwContext, id int64) (*model.JoinCode, error) {
+	const q string = `select c.id, c.expireTmstmp, c.singleUse, c.uses
+						from JoinCodes c
+						where c.id = ? and c.revokeTmstmp is null`
+
+	joinCode := new(model.JoinCode)
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&joinCode.ID,
+		&joinCode.ExpiresAt,
+		&joinCode.SingleUse,
+		&joinCode.Uses); err != nil {
+		return nil, err
+	}
+	return joinCode, nil
+}
+
+// FindJoinCodeRotation returns the rotation id of a join code
+func FindJoinCodeRotation(ctx context.Context, lCtx *LuwContext, id int64) (int64, error) {
+	const q string = `select rotationId from JoinCodes where id = ? and revokeTmstmp is null`
+
+	var rotationId int64
+	err := lCtx.Tx.QueryRowContext(ctx, q, id).Scan(&rotationId)
+	return rotationId, err
+}
+
+// FindJoinCodes lists the codes of the rotation still usable at now
+func FindJoinCodes(ctx context.Context, lCtx *LuwContext, rotationId int64, now time.Time) ([]*model.JoinCode, error) {
+	const q string = `select c.id
+						from JoinCodes c
+						where c.rotationId = ? and c.revokeTmstmp is null and c.expireTmstmp > ?
+						and (c.singleUse = 0 or c.uses = 0)
+						order by c.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	joinCodeIds := make([]int64, 0)
+	for rows.Next() {
+		var joinCodeId int64
+		if err := rows.Scan(&joinCodeId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		joinCodeIds = append(joinCodeIds, joinCodeId)
+	}
+	rows.Close()
+
+	joinCodes := make([]*model.JoinCode, 0, len(joinCodeIds))
+	for _, joinCodeId := range joinCodeIds {
+		joinCode, err := FindJoinCode(ctx, lCtx, joinCodeId)
+		if err != nil {
+			return nil, err
+		}
+		joinCodes = append(joinCodes, joinCode)
+	}
+
+	return joinCodes, nil
+}
+
+// CreateJoinCode returns the code to share, only its hash is stored
+func CreateJoinCode(ctx context.Context, lCtx *LuwContext, newJoinCode *model.NewJoinCode) (*model.GeneratedJoinCode, error) {
+	const q string = `INSERT INTO JoinCodes(rotationId, codeHash, singleUse, uses, expireTmstmp, createTmstmp, revokeTmstmp)
+						VALUES (?, ?, ?, 0, ?, DATETIME('now'), null)`
+
+	validDays := defaultJoinCodeValidDays
+	if newJoinCode.ValidDays != nil {
+		validDays = *newJoinCode.ValidDays
+	}
+	if validDays < 1 {
+		return nil, fmt.Errorf("a join code is valid at least one day")
+	}
+	singleUse := newJoinCode.SingleUse != nil && *newJoinCode.SingleUse
+
+	code, codeHash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expireTmstmp := time.Now().UTC().Truncate(time.Second).AddDate(0, 0, validDays)
+	res, err := lCtx.Tx.ExecContext(ctx, q, newJoinCode.IDRotation, codeHash, singleUse, expireTmstmp)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create join code for rotation %d assign id %d", newJoinCode.IDRotation, id)
+	joinCode, err := FindJoinCode(ctx, lCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &model.GeneratedJoinCode{Code: code, JoinCode: joinCode}, nil
+}
+
+func RevokeJoinCode(ctx context.Context, lCtx *LuwContext, joinCode *model.JoinCode) (*model.JoinCode, error) {
+	const q string = `UPDATE JoinCodes set revokeTmstmp=DATETIME('now') WHERE id=? and revokeTmstmp is null`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, joinCode.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Revoke join code id %d", joinCode.ID)
+	return joinCode, nil
+}
+
+func SetJoinApproval(ctx context.Context, lCtx *LuwContext, rotationId int64, required bool) (*model.Rotation, error) {
+	const q string = `UPDATE Rotations set joinApproval=?, lstUpdTmstmp=DATETIME('now') WHERE id=? and deleteTmstmp is null`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, required, rotationId)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Set join approval of rotation %d to %t", rotationId, required)
+	return FindRotation(ctx, lCtx, rotationId)
+}
+
+func FindJoinRequest(ctx context.Context, lCtx *LuwContext, id int64) (*model.JoinRequest, error) {
+	const q string = `select j.id, j.email, s.RefName, j.createTmstmp
+						from JoinRequests j left join RefJoinRequestStatus s on j.statusCd = s.RefCd
+						where j.id = ?`
+
+	joinRequest := new(model.JoinRequest)
+	var email string
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&joinRequest.ID,
+		&email,
+		&joinRequest.Status,
+		&joinRequest.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	user, err := FindUser(ctx, lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+	joinRequest.User = user
+
+	return joinRequest, nil
+}
+
+// FindJoinRequestRotation returns the rotation id of a join request
+func FindJoinRequestRotation(ctx context.Context, lCtx *LuwContext, id int64) (int64, error) {
+	const q string = `select rotationId from JoinRequests where id = ?`
+
+	var rotationId int64
+	err := lCtx.Tx.QueryRowContext(ctx, q, id).Scan(&rotationId)
+	return rotationId, err
+}
+
+func FindPendingJoinRequests(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.JoinRequest, error) {
+	const q string = `select j.id
+						from JoinRequests j
+						where j.rotationId = ? and j.statusCd = (select RefCd from RefJoinRequestStatus where RefName='PENDING')
+						order by j.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	joinRequestIds := make([]int64, 0)
+	for rows.Next() {
+		var joinRequestId int64
+		if err := rows.Scan(&joinRequestId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		joinRequestIds = append(joinRequestIds, joinRequestId)
+	}
+	rows.Close()
+
+	joinRequests := make([]*model.JoinRequest, 0, len(joinRequestIds))
+	for _, joinRequestId := range joinRequestIds {
+		joinRequest, err := FindJoinRequest(ctx, lCtx, joinRequestId)
+		if err != nil {
+			return nil, err
+		}
+		joinRequests = append(joinRequests, joinRequest)
+	}
+
+	return joinRequests, nil
+}
+
+// JoinRotation uses a code to add the user to its rotation, or to request it when the rotation requires approval
+func JoinRotation(ctx context.Context, lCtx *LuwContext, code string, email string) (*model.JoinRequest, error) {
+	const qCode string = `select c.id, c.rotationId, r.joinApproval
+						from JoinCodes c join Rotations r on r.id = c.rotationId
+						where c.codeHash = ? and c.revokeTmstmp is null and c.expireTmstmp > ?
+						and (c.singleUse = 0 or c.uses = 0) and r.deleteTmstmp is null`
+	const qMember string = `select count(*) from RotationParticipants where rotationId = ? and email = ?`
+	const qPending string = `select id from JoinRequests
+						where rotationId = ? and email = ? and statusCd = (select RefCd from RefJoinRequestStatus where RefName='PENDING')`
+	const qUse string = `UPDATE JoinCodes set uses=uses+1 WHERE id=?`
+	const q string = `INSERT INTO JoinRequests(rotationId, email, joinCodeId, statusCd, createTmstmp, lstUpdTmstmp, decideTmstmp)
+						VALUES (?, ?, ?, (select RefCd from RefJoinRequestStatus where RefName=?), DATETIME('now'), DATETIME('now'),
+							case when ? then DATETIME('now') else null end)`
+
+	var joinCodeId, rotationId int64
+	var approval bool
+	err := lCtx.Tx.QueryRowContext(ctx, qCode, hashToken(code), time.Now().UTC()).Scan(&joinCodeId, &rotationId, &approval)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, fmt.Errorf("invalid or expired join code")
+	case err != nil:
+		return nil, err
+	}
+
+	user, err := FindUser(ctx, lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRotationMembers(ctx, lCtx, rotationId, []string{email}); err != nil {
+		return nil, err
+	}
+
+	var members int
+	if err := lCtx.Tx.QueryRowContext(ctx, qMember, rotationId, email).Scan(&members); err != nil {
+		return nil, err
+	}
+	if members > 0 {
+		return nil, fmt.Errorf("%s already participates in rotation %d", email, rotationId)
+	}
+
+	// Asking twice returns the pending request
+	var pendingId int64
+	err = lCtx.Tx.QueryRowContext(ctx, qPending, rotationId, email).Scan(&pendingId)
+	switch {
+	case err == nil:
+		joinRequest, err := FindJoinRequest(ctx, lCtx, pendingId)
+		return joinRequest, err
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	if _, err := lCtx.Tx.ExecContext(ctx, qUse, joinCodeId); err != nil {
+		return nil, err
+	}
+
+	if _, err := RegisterUser(ctx, lCtx, user, nil, nil); err != nil {
+		return nil, err
+	}
+
+	status := model.JoinRequestStatusPending
+	if !approval {
+		status = model.JoinRequestStatusApproved
+		if err := insertRotationParticipant(ctx, lCtx, rotationId, email); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, rotationId, email, joinCodeId, status, !approval)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("%s joins rotation %d, request %d is %s", email, rotationId, id, status)
+	joinRequest, err := FindJoinRequest(ctx, lCtx, id)
+	return joinRequest, err
+}
+
+// DecideJoinRequest approves or rejects a pending join request, the user participates once approved
+func DecideJoinRequest(ctx context.Context, lCtx *LuwContext, id int64, status model.JoinRequestStatus) (*model.JoinRequest, error) {
+	const q string = `UPDATE JoinRequests set statusCd=(select RefCd from RefJoinRequestStatus where RefName=?),
+					lstUpdTmstmp=DATETIME('now'), decideTmstmp=DATETIME('now')
+				WHERE id=?`
+
+	joinRequest, err := FindJoinRequest(ctx, lCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	if joinRequest.Status != model.JoinRequestStatusPending {
+		return nil, fmt.Errorf("join request %d is already %s", id, joinRequest.Status)
+	}
+
+	if _, err := lCtx.Tx.ExecContext(ctx, q, status, id); err != nil {
+		return nil, err
+	}
+
+	if status == model.JoinRequestStatusApproved {
+		rotationId, err := FindJoinRequestRotation(ctx, lCtx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := insertRotationParticipant(ctx, lCtx, rotationId, joinRequest.User.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Join request %d %s", id, status)
+	return FindJoinRequest(ctx, lCtx, id)
+}
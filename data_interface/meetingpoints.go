@@ -0,0 +1,337 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"whosdriving-be/graph/model"
+)
+
+func FindMeetingPoint(ctx context.Context, lCtx *LuwContext, id int64) (*model.MeetingPoint, error) {
+	const q string = `select m.id, m.name, m.latitude, m.longitude, m.address
+						from MeetingPoints m
+						where m.id = ? and m.deleteTmstmp is null`
+
+	meetingPoint := new(model.MeetingPoint)
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&meetingPoint.ID,
+		&meetingPoint.Name,
+		&meetingPoint.Latitude,
+		&meetingPoint.Longitude,
+		&meetingPoint.Address); err != nil {
+		return nil, err
+	}
+	return meetingPoint, nil
+}
+
+// FindMeetingPointRotation returns the rotation id of a meeting point
+func FindMeetingPointRotation(ctx context.Context, lCtx *LuwContext, id int64) (int64, error) {
+	const q string = `select rotationId from MeetingPoints where id = ? and deleteTmstmp is null`
+
+	var rotationId int64
+	err := lCtx.Tx.QueryRowContext(ctx, q, id).Scan(&rotationId)
+	return rotationId, err
+}
+
+// FindRoute lists the meeting points of the rotation in the order of the outbound rides
+func FindRoute(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.MeetingPoint, error) {
+	const q string = `select m.id
+						from MeetingPoints m
+						where m.rotationId = ? and m.deleteTmstmp is null
+						order by m.routeOrder, m.id`
+
+	return findMeetingPoints(ctx, lCtx, q, rotationId)
+}
+
+func findMeetingPoints(ctx context.Context, lCtx *LuwContext, q string, args ...interface{}) ([]*model.MeetingPoint, error) {
+	rows, err := lCtx.Tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	meetingPointIds := make([]int64, 0)
+	for rows.Next() {
+		var meetingPointId int64
+		if err := rows.Scan(&meetingPointId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		meetingPointIds = append(meetingPointIds, meetingPointId)
+	}
+	rows.Close()
+
+	meetingPoints := make([]*model.MeetingPoint, 0, len(meetingPointIds))
+	for _, meetingPointId := range meetingPointIds {
+		meetingPoint, err := FindMeetingPoint(ctx, lCtx, meetingPointId)
+		if err != nil {
+			return nil, err
+		}
+		meetingPoints = append(meetingPoints, meetingPoint)
+	}
+
+	return meetingPoints, nil
+}
+
+// CreateMeetingPoint adds the point at the end of the route of the rotation
+func CreateMeetingPoint(ctx context.Context, lCtx *LuwContext, newMeetingPoint *model.NewMeetingPoint) (*model.MeetingPoint, error) {
+	const q string = `INSERT INTO MeetingPoints(rotationId, name, latitude, longitude, address, routeOrder, createTmstmp, lstUpdTmstmp, deleteTmstmp)
+						VALUES (?, ?, ?, ?, ?, (select coalesce(max(routeOrder) + 1, 0) from MeetingPoints where rotationId = ? and deleteTmstmp is null),
+							DATETIME('now'), DATETIME('now'), null)`
+
+	if strings.TrimSpace(newMeetingPoint.Name) == "" {
+		return nil, fmt.Errorf("a meeting point needs a name")
+	}
+	if newMeetingPoint.Latitude < -90 || newMeetingPoint.Latitude > 90 || newMeetingPoint.Longitude < -180 || newMeetingPoint.Longitude > 180 {
+		return nil, fmt.Errorf("invalid coordinates %f, %f", newMeetingPoint.Latitude, newMeetingPoint.Longitude)
+	}
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, newMeetingPoint.IDRotation, newMeetingPoint.Name, newMeetingPoint.Latitude, newMeetingPoint.Longitude,
+		newMeetingPoint.Address, newMeetingPoint.IDRotation)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create meeting point %s for rotation %d assign id %d", newMeetingPoint.Name, newMeetingPoint.IDRotation, id)
+	return FindMeetingPoint(ctx, lCtx, id)
+}
+
+// DeleteMeetingPoint removes the point from the route and clears the pickups there
+func DeleteMeetingPoint(ctx context.Context, lCtx *LuwContext, meetingPoint *model.MeetingPoint) (*model.MeetingPoint, error) {
+	const q string = `UPDATE MeetingPoints set deleteTmstmp=DATETIME('now'), lstUpdTmstmp=DATETIME('now') WHERE id=? and deleteTmstmp is null`
+	const qRotationPickups string = `UPDATE RotationParticipants set pickupPointId=null WHERE pickupPointId=?`
+	const qRidePickups string = `UPDATE RideParticipants set pickupPointId=null WHERE pickupPointId=?`
+
+	for _, query := range []string{q, qRotationPickups, qRidePickups} {
+		if _, err := lCtx.Tx.ExecContext(ctx, query, meetingPoint.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Delete meeting point id %d", meetingPoint.ID)
+	return meetingPoint, nil
+}
+
+// SetRoute orders the meeting points of the rotation, every one of them must be given once
+func SetRoute(ctx context.Context, lCtx *LuwContext, rotationId int64, meetingPointIds []int) ([]*model.MeetingPoint, error) {
+	const q string = `UPDATE MeetingPoints set routeOrder=?, lstUpdTmstmp=DATETIME('now') WHERE id=?`
+
+	route, err := FindRoute(ctx, lCtx, rotationId)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[int]bool)
+	for _, meetingPoint := range route {
+		remaining[meetingPoint.ID] = true
+	}
+	for _, meetingPointId := range meetingPointIds {
+		if !remaining[meetingPointId] {
+			return nil, fmt.Errorf("meeting point %d is not in rotation %d or given twice", meetingPointId, rotationId)
+		}
+		delete(remaining, meetingPointId)
+	}
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("the route misses %d meeting points of rotation %d", len(remaining), rotationId)
+	}
+
+	for order, meetingPointId := range meetingPointIds {
+		if _, err := lCtx.Tx.ExecContext(ctx, q, order, meetingPointId); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Set route of rotation %d", rotationId)
+	return FindRoute(ctx, lCtx, rotationId)
+}
+
+// checkMeetingPoint rejects meeting points of other rotations
+func checkMeetingPoint(ctx context.Context, lCtx *LuwContext, rotationId int64, meetingPointId *int) error {
+	if meetingPointId == nil {
+		return nil
+	}
+
+	meetingPointRotationId, err := FindMeetingPointRotation(ctx, lCtx, int64(*meetingPointId))
+	switch {
+	case err == sql.ErrNoRows:
+		return fmt.Errorf("meeting point %d doesn't exist", *meetingPointId)
+	case err != nil:
+		return err
+	case meetingPointRotationId != rotationId:
+		return fmt.Errorf("meeting point %d is not in rotation %d", *meetingPointId, rotationId)
+	}
+	return nil
+}
+
+func findPickup(ctx context.Context, lCtx *LuwContext, email string, meetingPointId sql.NullInt64) (*model.Pickup, error) {
+	user, err := FindUser(ctx, lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+	pickup := &model.Pickup{User: user}
+
+	if meetingPointId.Valid {
+		meetingPoint, err := FindMeetingPoint(ctx, lCtx, meetingPointId.Int64)
+		if err != nil {
+			return nil, err
+		}
+		pickup.MeetingPoint = meetingPoint
+	}
+
+	return pickup, nil
+}
+
+// FindPickups lists the default pickup point of every participant of the rotation
+func FindPickups(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.Pickup, error) {
+	const q string = `select rp.email, m.id
+						from RotationParticipants rp
+						left join MeetingPoints m on m.id = rp.pickupPointId and m.deleteTmstmp is null
+						where rp.rotationId = ?
+						order by rp.email`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := make([]string, 0)
+	meetingPointIds := make([]sql.NullInt64, 0)
+	for rows.Next() {
+		var email string
+		var meetingPointId sql.NullInt64
+		if err := rows.Scan(&email, &meetingPointId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		emails = append(emails, email)
+		meetingPointIds = append(meetingPointIds, meetingPointId)
+	}
+	rows.Close()
+
+	pickups := make([]*model.Pickup, 0, len(emails))
+	for i, email := range emails {
+		pickup, err := findPickup(ctx, lCtx, email, meetingPointIds[i])
+		if err != nil {
+			return nil, err
+		}
+		pickups = append(pickups, pickup)
+	}
+
+	return pickups, nil
+}
+
+// SetPickup sets the default pickup point of a participant of the rotation, none when meetingPointId is nil
+func SetPickup(ctx context.Context, lCtx *LuwContext, rotationId int64, email string, meetingPointId *int) (*model.Pickup, error) {
+	const q string = `UPDATE RotationParticipants set pickupPointId=? WHERE rotationId=? and email=?`
+
+	if err := checkMeetingPoint(ctx, lCtx, rotationId, meetingPointId); err != nil {
+		return nil, err
+	}
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, meetingPointId, rotationId, email)
+	if err != nil {
+		return nil, err
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		if err == nil {
+			err = fmt.Errorf("%s doesn't participate in rotation %d", email, rotationId)
+		}
+		return nil, err
+	}
+
+	log.Printf("Set pickup point of %s in rotation %d", email, rotationId)
+	var pickupPointId sql.NullInt64
+	if meetingPointId != nil {
+		pickupPointId = sql.NullInt64{Int64: int64(*meetingPointId), Valid: true}
+	}
+	return findPickup(ctx, lCtx, email, pickupPointId)
+}
+
+// SetRidePickup overrides the pickup point of a passenger for one ride, back to their default one when meetingPointId is nil
+func SetRidePickup(ctx context.Context, lCtx *LuwContext, ride *model.Ride, email string, meetingPointId *int) (*model.Ride, error) {
+	const q string = `UPDATE RideParticipants set pickupPointId=? WHERE rideId=? and email=?`
+
+	rotationId, _, err := FindRideRotation(ctx, lCtx, int64(ride.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMeetingPoint(ctx, lCtx, rotationId, meetingPointId); err != nil {
+		return nil, err
+	}
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, meetingPointId, ride.ID, email)
+	if err != nil {
+		return nil, err
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		if err == nil {
+			err = fmt.Errorf("%s doesn't participate in ride %d", email, ride.ID)
+		}
+		return nil, err
+	}
+
+	log.Printf("Set pickup point of %s in ride %d", email, ride.ID)
+	return FindRide(ctx, lCtx, int64(ride.ID))
+}
+
+// FindRideStops groups the passengers of the ride by pickup point, in the order of the route
+func FindRideStops(ctx context.Context, lCtx *LuwContext, rideId int64) ([]*model.Stop, error) {
+	const q string = `select m.id, p.email
+						from Rides r
+						join RideParticipants p on p.rideId = r.id and p.email <> r.riderEmail
+						left join RotationParticipants rp on rp.rotationId = r.rotationId and rp.email = p.email
+						join MeetingPoints m on m.id = coalesce(p.pickupPointId, rp.pickupPointId) and m.deleteTmstmp is null
+						left join RefDirection d on r.directionCd = d.RefCd
+						where r.id = ?
+						order by case when d.RefName = 'RETURN' then -m.routeOrder else m.routeOrder end, m.id, p.email`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rideId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	meetingPointIds := make([]int64, 0)
+	emails := make([][]string, 0)
+	for rows.Next() {
+		var meetingPointId int64
+		var email string
+		if err := rows.Scan(&meetingPointId, &email); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		if last := len(meetingPointIds) - 1; last >= 0 && meetingPointIds[last] == meetingPointId {
+			emails[last] = append(emails[last], email)
+			continue
+		}
+		meetingPointIds = append(meetingPointIds, meetingPointId)
+		emails = append(emails, []string{email})
+	}
+	rows.Close()
+
+	stops := make([]*model.Stop, 0, len(meetingPointIds))
+	for i, meetingPointId := range meetingPointIds {
+		meetingPoint, err := FindMeetingPoint(ctx, lCtx, meetingPointId)
+		if err != nil {
+			return nil, err
+		}
+		stop := &model.Stop{MeetingPoint: meetingPoint, Passengers: make([]*model.User, 0, len(emails[i]))}
+		for _, email := range emails[i] {
+			passenger, err := FindUser(ctx, lCtx, &email)
+			if err != nil {
+				return nil, err
+			}
+			stop.Passengers = append(stop.Passengers, passenger)
+		}
+		stops = append(stops, stop)
+	}
+
+	return stops, nil
+}
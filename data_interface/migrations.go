@@ -0,0 +1,119 @@
+package data_interface
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// column added by a migration to a table created before its version
+type column struct {
+	table      string
+	name       string
+	definition string
+	// index replacing a constraint that ALTER TABLE can't add, created with the column
+	index string
+}
+
+// migration upgrades the schema from its version to the next one.
+// Its columns are added before the DDL runs, the DDL creating the missing tables as a whole,
+// then its backfills fill the data of the version.
+type migration struct {
+	columns   []column
+	backfills []string
+}
+
+// migrations from the version of their index to the next one, there are SchemaVersion of them
+var migrations = []migration{
+	// 0 to 1: databases created before the schema was versioned, at any point of its history
+	{
+		columns: []column{
+			{table: "RefFuelType", name: "co2GramsPerKm", definition: "INT NOT NULL DEFAULT 0"},
+			{table: "Users", name: "phone", definition: "TEXT NULL"},
+			{table: "Users", name: "avatar", definition: "TEXT NULL"},
+			{table: "Users", name: "calendarTokenHash", definition: "TEXT NULL",
+				index: "CREATE UNIQUE INDEX IF NOT EXISTS UsersCalendarTokenHash ON Users(calendarTokenHash)"},
+			{table: "Users", name: "notifyAddedToRotation", definition: "INT NOT NULL DEFAULT 1"},
+			{table: "Users", name: "notifyDriverReminder", definition: "INT NOT NULL DEFAULT 1"},
+			{table: "Users", name: "notifyRideCancelled", definition: "INT NOT NULL DEFAULT 1"},
+			{table: "Rotations", name: "distanceKm", definition: "REAL NULL"},
+			{table: "Rotations", name: "joinApproval", definition: "INT NOT NULL DEFAULT 0"},
+			{table: "Rotations", name: "organizationId", definition: "INT NULL"},
+			{table: "RotationParticipants", name: "pickupPointId", definition: "INT NULL"},
+			// The rides of the baseline were recorded at their creation
+			{table: "Rides", name: "rideDate", definition: "DATETIME NOT NULL DEFAULT ''"},
+			{table: "Rides", name: "directionCd", definition: "INT NULL"},
+			{table: "Rides", name: "statusCd", definition: "INT NOT NULL DEFAULT 1"},
+			{table: "Rides", name: "scheduleId", definition: "INT NULL REFERENCES Schedules (id) ON DELETE SET NULL ON UPDATE CASCADE"},
+			{table: "Rides", name: "vehicleId", definition: "INT NULL REFERENCES Vehicles (id) ON DELETE SET NULL ON UPDATE CASCADE"},
+			{table: "Rides", name: "distanceKm", definition: "REAL NULL"},
+			{table: "Rides", name: "driverRemindedTmstmp", definition: "DATETIME NULL"},
+			{table: "RideParticipants", name: "pickupPointId", definition: "INT NULL"},
+		},
+		backfills: []string{
+			"UPDATE Rides SET rideDate = createTmstmp WHERE rideDate = ''",
+			`INSERT INTO UsersSearch(docid, email, firstname, lastname)
+				select u.rowid, u.email, coalesce(u.firstname, ''), coalesce(u.lastname, '') from Users u
+				where u.rowid not in (select docid from UsersSearch)`,
+		},
+	},
+	// 1 to 2: the emissions of the DDL, the fuel types existed before them
+	{
+		backfills: []string{
+			`UPDATE RefFuelType SET co2GramsPerKm = CASE RefCd WHEN 0 THEN 170 WHEN 1 THEN 160 WHEN 2 THEN 110 WHEN 3 THEN 50 WHEN 4 THEN 145 ELSE co2GramsPerKm END
+				WHERE co2GramsPerKm = 0`,
+		},
+	},
+}
+
+// findColumns are the columns of the table, none when it doesn't exist
+func findColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query("select name from pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// addColumns adds the columns missing from the existing tables, the DDL creates the others with them
+func (m *migration) addColumns(tx *sql.Tx) error {
+	for _, c := range m.columns {
+		columns, err := findColumns(tx, c.table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 || columns[c.name] {
+			continue
+		}
+
+		log.Printf("Add column %s.%s", c.table, c.name)
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)); err != nil {
+			return err
+		}
+		if c.index != "" {
+			if _, err := tx.Exec(c.index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *migration) backfill(tx *sql.Tx) error {
+	for _, statement := range m.backfills {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
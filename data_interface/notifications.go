@@ -0,0 +1,70 @@
+package data_interface
+
+import (
+	"context"
+	"log"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+func FindNotificationPreferences(ctx context.Context, lCtx *LuwContext, email string) (*model.NotificationPreferences, error) {
+	const q string = `select notifyAddedToRotation, notifyDriverReminder, notifyRideCancelled
+						from Users
+						where email = ? and deleteTmstmp is null`
+
+	preferences := new(model.NotificationPreferences)
+	if err := lCtx.Tx.QueryRowContext(ctx, q, email).Scan(&preferences.AddedToRotation,
+		&preferences.DriverReminder,
+		&preferences.RideCancelled); err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+func UpdateNotificationPreferences(ctx context.Context, lCtx *LuwContext, newPreferences *model.NewNotificationPreferences) (*model.NotificationPreferences, error) {
+	const q string = `UPDATE Users set notifyAddedToRotation=?, notifyDriverReminder=?, notifyRideCancelled=?, lstUpdTmstmp=DATETIME('now')
+				WHERE email=? and deleteTmstmp is null`
+
+	preferences, err := FindNotificationPreferences(ctx, lCtx, newPreferences.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if newPreferences.AddedToRotation != nil {
+		preferences.AddedToRotation = *newPreferences.AddedToRotation
+	}
+	if newPreferences.DriverReminder != nil {
+		preferences.DriverReminder = *newPreferences.DriverReminder
+	}
+	if newPreferences.RideCancelled != nil {
+		preferences.RideCancelled = *newPreferences.RideCancelled
+	}
+
+	_, err = lCtx.Tx.ExecContext(ctx, q, preferences.AddedToRotation, preferences.DriverReminder, preferences.RideCancelled, newPreferences.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Update notification preferences of %s", newPreferences.Email)
+	return FindNotificationPreferences(ctx, lCtx, newPreferences.Email)
+}
+
+// FindRidesToRemind lists the rides between from and to whose conductor has not been reminded yet
+func FindRidesToRemind(ctx context.Context, lCtx *LuwContext, from time.Time, to time.Time) ([]*RotationRide, error) {
+	const q string = `select r.id, rot.name
+						from Rides r join Rotations rot on rot.id = r.rotationId
+						where r.deleteTmstmp is null and rot.deleteTmstmp is null
+						and r.driverRemindedTmstmp is null
+						and r.statusCd <> (select RefCd from RefRideStatus where RefName='CANCELLED')
+						and r.rideDate >= ? and r.rideDate < ?
+						order by r.rideDate, r.id`
+
+	return findRotationRides(ctx, lCtx, q, from.UTC(), to.UTC())
+}
+
+func MarkRideReminded(ctx context.Context, lCtx *LuwContext, rideId int64) error {
+	const q string = `UPDATE Rides set driverRemindedTmstmp=DATETIME('now') WHERE id=?`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, rideId)
+	return err
+}
@@ -0,0 +1,329 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"whosdriving-be/graph/model"
+)
+
+// Condition on the user u visible to the viewer bound twice: users of organizations are only visible
+// to the other members, users without organization to everyone
+const visibleUser string = `(u.email = ?
+							or not exists (select 1 from OrganizationMembers m where m.email = u.email)
+							or exists (select 1 from OrganizationMembers m join OrganizationMembers v on v.organizationId = m.organizationId
+								where m.email = u.email and v.email = ?))`
+
+func FindOrganization(ctx context.Context, lCtx *LuwContext, id int64) (*model.Organization, error) {
+	const q string = `select o.id, o.name
+						from Organizations o
+						where o.id = ? and o.deleteTmstmp is null`
+
+	organization := new(model.Organization)
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&organization.ID,
+		&organization.Name); err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// FindRotationOrganization returns the organization of the rotation, nil when it has none
+func FindRotationOrganization(ctx context.Context, lCtx *LuwContext, rotationId int64) (*model.Organization, error) {
+	const q string = `select organizationId from Rotations where id = ?`
+
+	var organizationId sql.NullInt64
+	if err := lCtx.Tx.QueryRowContext(ctx, q, rotationId).Scan(&organizationId); err != nil {
+		return nil, err
+	}
+	if !organizationId.Valid {
+		return nil, nil
+	}
+	return FindOrganization(ctx, lCtx, organizationId.Int64)
+}
+
+func FindUserOrganizations(ctx context.Context, lCtx *LuwContext, email string) ([]*model.Organization, error) {
+	const q string = `select o.id
+						from Organizations o join OrganizationMembers m on m.organizationId = o.id
+						where m.email = ? and o.deleteTmstmp is null
+						order by o.name`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	organizationIds := make([]int64, 0)
+	for rows.Next() {
+		var organizationId int64
+		if err := rows.Scan(&organizationId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		organizationIds = append(organizationIds, organizationId)
+	}
+	rows.Close()
+
+	organizations := make([]*model.Organization, 0, len(organizationIds))
+	for _, organizationId := range organizationIds {
+		organization, err := FindOrganization(ctx, lCtx, organizationId)
+		if err != nil {
+			return nil, err
+		}
+		organizations = append(organizations, organization)
+	}
+
+	return organizations, nil
+}
+
+func FindOrganizationMembers(ctx context.Context, lCtx *LuwContext, organizationId int64) ([]*model.OrgMember, error) {
+	const q string = `select m.email, r.RefName
+						from OrganizationMembers m left join RefOrgRole r on m.roleCd = r.RefCd
+						where m.organizationId = ?
+						order by m.email`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := make([]string, 0)
+	roles := make([]model.OrgRole, 0)
+	for rows.Next() {
+		var email string
+		var role model.OrgRole
+		if err := rows.Scan(&email, &role); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		emails = append(emails, email)
+		roles = append(roles, role)
+	}
+	rows.Close()
+
+	members := make([]*model.OrgMember, 0, len(emails))
+	for i, email := range emails {
+		user, err := FindUser(ctx, lCtx, &email)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &model.OrgMember{User: user, Role: roles[i]})
+	}
+
+	return members, nil
+}
+
+func FindOrganizationRotations(ctx context.Context, lCtx *LuwContext, organizationId int64) ([]*model.Rotation, error) {
+	const q string = `select r.id
+						from Rotations r
+						where r.organizationId = ? and r.deleteTmstmp is null
+						order by r.name`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotationIds := make([]int64, 0)
+	for rows.Next() {
+		var rotationId int64
+		if err := rows.Scan(&rotationId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		rotationIds = append(rotationIds, rotationId)
+	}
+	rows.Close()
+
+	rotations := make([]*model.Rotation, 0, len(rotationIds))
+	for _, rotationId := range rotationIds {
+		rotation, err := FindRotation(ctx, lCtx, rotationId)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	return rotations, nil
+}
+
+// FindOrganizationRole returns the role of the user in the organization, nil when not a member
+func FindOrganizationRole(ctx context.Context, lCtx *LuwContext, organizationId int64, email string) (*model.OrgRole, error) {
+	const q string = `select r.RefName
+						from OrganizationMembers m left join RefOrgRole r on m.roleCd = r.RefCd
+						where m.organizationId = ? and m.email = ?`
+
+	role := new(model.OrgRole)
+	err := lCtx.Tx.QueryRowContext(ctx, q, organizationId, email).Scan(role)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return role, nil
+}
+
+// checkOrganizationMembers rejects the users who are not members of the organization
+func checkOrganizationMembers(ctx context.Context, lCtx *LuwContext, organizationId int64, emails []string) error {
+	for _, email := range emails {
+		role, err := FindOrganizationRole(ctx, lCtx, organizationId, email)
+		if err != nil {
+			return err
+		}
+		if role == nil {
+			return fmt.Errorf("%s is not a member of organization %d", email, organizationId)
+		}
+	}
+	return nil
+}
+
+// checkRotationMembers rejects the users who are not members of the organization of the rotation, if any
+func checkRotationMembers(ctx context.Context, lCtx *LuwContext, rotationId int64, emails []string) error {
+	organization, err := FindRotationOrganization(ctx, lCtx, rotationId)
+	if err != nil || organization == nil {
+		return err
+	}
+	return checkOrganizationMembers(ctx, lCtx, int64(organization.ID), emails)
+}
+
+// IsUserVisible tells whether the viewer may see the user, see visibleUser
+func IsUserVisible(ctx context.Context, lCtx *LuwContext, viewerEmail string, email string) (bool, error) {
+	const q string = `select count(*) from Users u where u.email = ? and ` + visibleUser
+
+	var count int
+	err := lCtx.Tx.QueryRowContext(ctx, q, email, viewerEmail, viewerEmail).Scan(&count)
+	return count > 0, err
+}
+
+// CreateOrganization creates the organization with its creator as admin
+func CreateOrganization(ctx context.Context, lCtx *LuwContext, name string, creatorEmail string) (*model.Organization, error) {
+	const q string = `INSERT INTO Organizations(name, createTmstmp, lstUpdTmstmp, deleteTmstmp)
+						VALUES (?, DATETIME('now'), DATETIME('now'), null)`
+
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("an organization needs a name")
+	}
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, name)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create organization %s assign id %d", name, id)
+	if err := insertOrganizationMember(ctx, lCtx, id, creatorEmail, model.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+	return FindOrganization(ctx, lCtx, id)
+}
+
+func insertOrganizationMember(ctx context.Context, lCtx *LuwContext, organizationId int64, email string, role model.OrgRole) error {
+	const q string = `INSERT OR IGNORE INTO OrganizationMembers(organizationId, email, roleCd, createTmstmp, lstUpdTmstmp)
+						VALUES (?, ?, (select RefCd from RefOrgRole where RefName=?), DATETIME('now'), DATETIME('now'))`
+
+	if _, err := FindUser(ctx, lCtx, &email); err == sql.ErrNoRows {
+		if _, err := createUnregisteredUser(ctx, lCtx, email); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	log.Printf("Add %s to organization %d as %s", email, organizationId, role)
+	_, err := lCtx.Tx.ExecContext(ctx, q, organizationId, email, role)
+	return err
+}
+
+// AddOrganizationMembers adds the users as members, unknown ones are created as UNREGISTRED users
+func AddOrganizationMembers(ctx context.Context, lCtx *LuwContext, organizationId int64, emails []string) error {
+	for _, email := range emails {
+		if err := insertOrganizationMember(ctx, lCtx, organizationId, email, model.OrgRoleMember); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRemainingAdmin rejects the change leaving the organization without admin
+func checkRemainingAdmin(ctx context.Context, lCtx *LuwContext, organizationId int64, leavingEmails []string) error {
+	members, err := FindOrganizationMembers(ctx, lCtx, organizationId)
+	if err != nil {
+		return err
+	}
+
+	leaving := make(map[string]bool)
+	for _, email := range leavingEmails {
+		leaving[email] = true
+	}
+	for _, member := range members {
+		if member.Role == model.OrgRoleAdmin && !leaving[member.User.Email] {
+			return nil
+		}
+	}
+	return fmt.Errorf("organization %d needs an admin", organizationId)
+}
+
+// RemoveOrganizationMembers removes the members from the organization and from its rotations
+func RemoveOrganizationMembers(ctx context.Context, lCtx *LuwContext, organizationId int64, emails []string) error {
+	const q string = `DELETE from OrganizationMembers where organizationId=? and email=?`
+
+	if err := checkRemainingAdmin(ctx, lCtx, organizationId, emails); err != nil {
+		return err
+	}
+
+	rotations, err := FindOrganizationRotations(ctx, lCtx, organizationId)
+	if err != nil {
+		return err
+	}
+	for _, rotation := range rotations {
+		if err := RemoveRotationParticipants(ctx, lCtx, int64(rotation.ID), &emails); err != nil {
+			return err
+		}
+	}
+
+	for _, email := range emails {
+		log.Printf("Remove %s from organization %d", email, organizationId)
+		if _, err := lCtx.Tx.ExecContext(ctx, q, organizationId, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SetOrganizationRole(ctx context.Context, lCtx *LuwContext, organizationId int64, email string, role model.OrgRole) (*model.OrgMember, error) {
+	const q string = `UPDATE OrganizationMembers set roleCd=(select RefCd from RefOrgRole where RefName=?), lstUpdTmstmp=DATETIME('now')
+				WHERE organizationId=? and email=?`
+
+	current, err := FindOrganizationRole(ctx, lCtx, organizationId, email)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("%s is not a member of organization %d", email, organizationId)
+	}
+	if role != model.OrgRoleAdmin {
+		if err := checkRemainingAdmin(ctx, lCtx, organizationId, []string{email}); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := lCtx.Tx.ExecContext(ctx, q, role, organizationId, email); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Set role of %s in organization %d to %s", email, organizationId, role)
+	user, err := FindUser(ctx, lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+	return &model.OrgMember{User: user, Role: role}, nil
+}
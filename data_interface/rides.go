@@ -3,23 +3,40 @@ package data_interface
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"time"
 	"whosdriving-be/graph/model"
 )
 
 func FindRide(ctx context.Context, lCtx *LuwContext, id int64) (*model.Ride, error) {
-	const q string = `select id, riderEmail 
+	const q string = `select r.id, r.riderEmail, r.rideDate, d.RefName, s.RefName, r.vehicleId, coalesce(r.distanceKm, rot.distanceKm)
 						from rides r 
+						join Rotations rot on rot.id = r.rotationId
+						left join RefDirection d on r.directionCd = d.RefCd
+						left join RefRideStatus s on r.statusCd = s.RefCd
 						where r.id=? and r.deleteTmstmp is null`
 
 	ride := new(model.Ride)
 	var riderEmail sql.NullString
+	var vehicleId sql.NullInt64
 
 	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&ride.ID,
-		&riderEmail); err != nil {
+		&riderEmail,
+		&ride.Date,
+		&ride.Direction,
+		&ride.Status,
+		&vehicleId,
+		&ride.DistanceKm); err != nil {
 		return nil, err
 	}
 
+	vehicle, err := findRideVehicle(ctx, lCtx, vehicleId)
+	if err != nil {
+		return nil, err
+	}
+	ride.Vehicle = vehicle
+
 	log.Printf("User lookup rider %s", riderEmail.String)
 	rider, err := FindUser(ctx, lCtx, &riderEmail.String)
 	if err != nil {
@@ -39,7 +56,8 @@ func FindRide(ctx context.Context, lCtx *LuwContext, id int64) (*model.Ride, err
 func FindRides(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.Ride, error) {
 	const q string = `select id 
 						from rides r 
-						where r.rotationId=? and r.deleteTmstmp is null`
+						where r.rotationId=? and r.deleteTmstmp is null
+						order by r.rideDate, r.id`
 	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
 	switch {
 	case err == sql.ErrNoRows:
@@ -76,9 +94,116 @@ func FindRides(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*mode
 	return rides, nil
 }
 
+// FindRideRotation returns the id and name of the rotation of a ride
+func FindRideRotation(ctx context.Context, lCtx *LuwContext, rideId int64) (int64, string, error) {
+	const q string = `select rot.id, rot.name
+						from Rides r join Rotations rot on rot.id = r.rotationId
+						where r.id = ?`
+
+	var rotationId int64
+	var rotationName string
+	if err := lCtx.Tx.QueryRowContext(ctx, q, rideId).Scan(&rotationId, &rotationName); err != nil {
+		return 0, "", err
+	}
+	return rotationId, rotationName, nil
+}
+
+// RotationRide is a ride along with the name of its rotation
+type RotationRide struct {
+	RotationName string
+	Ride         *model.Ride
+}
+
+// FindUserRides lists the rides of every rotation where the user is conductor or participant
+func FindUserRides(ctx context.Context, lCtx *LuwContext, email string) ([]*RotationRide, error) {
+	const q string = `select r.id, rot.name
+						from Rides r join Rotations rot on rot.id = r.rotationId
+						where r.deleteTmstmp is null and rot.deleteTmstmp is null
+						and (r.riderEmail = ? or exists (select 1 from RideParticipants p where p.rideId = r.id and p.email = ?))
+						order by r.rideDate, r.id`
+
+	return findRotationRides(ctx, lCtx, q, email, email)
+}
+
+// findRotationRides loads the rides of a query selecting the ride id and rotation name
+func findRotationRides(ctx context.Context, lCtx *LuwContext, q string, args ...interface{}) ([]*RotationRide, error) {
+	rows, err := lCtx.Tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rideIds := make([]int64, 0)
+	rotationNames := make([]string, 0)
+	for rows.Next() {
+		var rideId int64
+		var rotationName string
+		if err := rows.Scan(&rideId, &rotationName); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		rideIds = append(rideIds, rideId)
+		rotationNames = append(rotationNames, rotationName)
+	}
+	rows.Close()
+
+	rides := make([]*RotationRide, 0, len(rideIds))
+	for i, rideId := range rideIds {
+		ride, err := FindRide(ctx, lCtx, rideId)
+		if err != nil {
+			return nil, err
+		}
+		rides = append(rides, &RotationRide{RotationName: rotationNames[i], Ride: ride})
+	}
+
+	return rides, nil
+}
+
 func AddRide(ctx context.Context, lCtx *LuwContext, newRide *model.NewRide) (*model.Ride, error) {
-	const q string = `INSERT INTO Rides(rotationId, riderEmail, createTmstmp, lstUpdTmstmp, deleteTmstmp) 
-						VALUES (?, ?, DATETIME('now'), DATETIME('now'), null)`
+	date := time.Now().UTC().Truncate(time.Second)
+	if newRide.Date != nil {
+		date = newRide.Date.UTC()
+	}
+	if newRide.DistanceKm != nil && *newRide.DistanceKm < 0 {
+		return nil, fmt.Errorf("distance can't be negative")
+	}
+
+	var vehicleId *int64
+	if newRide.IDVehicle != nil {
+		vehicle, err := FindVehicle(ctx, lCtx, int64(*newRide.IDVehicle))
+		if err != nil {
+			return nil, err
+		}
+
+		err = CheckVehicleOwner(vehicle, newRide.EmailConductor, newRide.EmailParticipants)
+		if err != nil {
+			return nil, err
+		}
+		err = CheckVehicleCapacity(vehicle, newRide.EmailConductor, newRide.EmailParticipants)
+		if err != nil {
+			return nil, err
+		}
+		id := int64(vehicle.ID)
+		vehicleId = &id
+	}
+
+	ride, err := insertRide(ctx, lCtx, int64(newRide.IDRotation), newRide.EmailConductor, date, newRide.Direction,
+		model.RideStatusRecorded, nil, vehicleId, newRide.DistanceKm, &newRide.EmailParticipants)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RecordRideCosts(ctx, lCtx, int64(ride.ID)); err != nil {
+		return nil, err
+	}
+	return ride, nil
+}
+
+func insertRide(ctx context.Context, lCtx *LuwContext, rotationId int64, conductorEmail string, date time.Time,
+	direction *model.Direction, status model.RideStatus, scheduleId *int64, vehicleId *int64, distanceKm *float64, participantsEmails *[]string) (*model.Ride, error) {
+	const q string = `INSERT INTO Rides(rotationId, riderEmail, rideDate, directionCd, statusCd, scheduleId, vehicleId, distanceKm, createTmstmp, lstUpdTmstmp, deleteTmstmp) 
+						VALUES (?, ?, ?, (select RefCd from RefDirection where RefName=?), (select RefCd from RefRideStatus where RefName=?), ?, ?, ?,
+							DATETIME('now'), DATETIME('now'), null)`
 
 	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
 	if err != nil {
@@ -86,7 +211,7 @@ func AddRide(ctx context.Context, lCtx *LuwContext, newRide *model.NewRide) (*mo
 	}
 	defer stmt.Close()
 
-	res, err := stmt.ExecContext(ctx, newRide.IDRotation, newRide.EmailConductor)
+	res, err := stmt.ExecContext(ctx, rotationId, conductorEmail, date, direction, status, scheduleId, vehicleId, distanceKm)
 	if err != nil {
 		return nil, err
 	}
@@ -96,17 +221,27 @@ func AddRide(ctx context.Context, lCtx *LuwContext, newRide *model.NewRide) (*mo
 		return nil, err
 	}
 
-	log.Printf("Create ride %s assign id %d", newRide.EmailConductor, id)
-	err = CreateRideParticipants(ctx, lCtx, id, &newRide.EmailParticipants)
+	log.Printf("Create %s ride %s assign id %d", status, conductorEmail, id)
+	err = CreateRideParticipants(ctx, lCtx, id, participantsEmails)
+	if err != nil {
+		return nil, err
+	}
+
+	ride, err := FindRide(ctx, lCtx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	return FindRide(ctx, lCtx, id)
+	if err := enqueueRideEvent(ctx, lCtx, rotationId, model.WebhookEventRideCreated, ride); err != nil {
+		return nil, err
+	}
+	return ride, nil
 }
 
 func UpdateRide(ctx context.Context, lCtx *LuwContext, ride *model.Ride) (*model.Ride, error) {
-	const q string = `UPDATE Rides set riderEmail=?, lstUpdTmstmp=DATETIME('now') 
+	// A new conductor has to be reminded again
+	const q string = `UPDATE Rides set riderEmail=?, lstUpdTmstmp=DATETIME('now'),
+				driverRemindedTmstmp=(case when riderEmail=? then driverRemindedTmstmp else null end)
 				WHERE id=? and deleteTmstmp is null`
 
 	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
@@ -115,13 +250,67 @@ func UpdateRide(ctx context.Context, lCtx *LuwContext, ride *model.Ride) (*model
 	}
 	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx, ride.Conductor.Email, ride.ID)
+	_, err = stmt.ExecContext(ctx, ride.Conductor.Email, ride.Conductor.Email, ride.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := RecordRideCosts(ctx, lCtx, int64(ride.ID)); err != nil {
+		return nil, err
+	}
+
 	log.Printf("Update rotation id %d", ride.ID)
-	return FindRide(ctx, lCtx, int64(ride.ID))
+	return findRideAndNotify(ctx, lCtx, int64(ride.ID), model.WebhookEventRideUpdated)
+}
+
+// findRideAndNotify reloads a changed ride and enqueues its webhook event
+func findRideAndNotify(ctx context.Context, lCtx *LuwContext, rideId int64, event model.WebhookEvent) (*model.Ride, error) {
+	ride, err := FindRide(ctx, lCtx, rideId)
+	if err != nil {
+		return nil, err
+	}
+
+	rotationId, _, err := FindRideRotation(ctx, lCtx, rideId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := enqueueRideEvent(ctx, lCtx, rotationId, event, ride); err != nil {
+		return nil, err
+	}
+	return ride, nil
+}
+
+// ChangeRideStatus moves a ride to status, only planned rides can be confirmed or cancelled
+func ChangeRideStatus(ctx context.Context, lCtx *LuwContext, ride *model.Ride, status model.RideStatus) (*model.Ride, error) {
+	const q string = `UPDATE Rides set statusCd=(select RefCd from RefRideStatus where RefName=?), lstUpdTmstmp=DATETIME('now') 
+				WHERE id=? and deleteTmstmp is null`
+
+	if ride.Status != model.RideStatusPlanned {
+		return nil, fmt.Errorf("ride %d is %s, only planned rides can be changed to %s", ride.ID, ride.Status, status)
+	}
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, status, ride.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RecordRideCosts(ctx, lCtx, int64(ride.ID)); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Change ride id %d to %s", ride.ID, status)
+	event := model.WebhookEventRideUpdated
+	if status == model.RideStatusCancelled {
+		event = model.WebhookEventRideCancelled
+	}
+	return findRideAndNotify(ctx, lCtx, int64(ride.ID), event)
 }
 
 func DeleteRide(ctx context.Context, lCtx *LuwContext, ride *model.Ride) (*model.Ride, error) {
@@ -139,6 +328,10 @@ func DeleteRide(ctx context.Context, lCtx *LuwContext, ride *model.Ride) (*model
 		return nil, err
 	}
 
+	if err := RecordRideCosts(ctx, lCtx, int64(ride.ID)); err != nil {
+		return nil, err
+	}
+
 	log.Printf("Delete rotation id %d", ride.ID)
 	return ride, nil
 }
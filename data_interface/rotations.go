@@ -8,7 +8,7 @@ import (
 )
 
 func FindRotation(ctx context.Context, lCtx *LuwContext, id int64) (*model.Rotation, error) {
-	const q string = `select id, name, creatorEmail 
+	const q string = `select id, name, creatorEmail, distanceKm, joinApproval 
 						from Rotations r 
 					   	where r.id = ? and r.deleteTmstmp is null`
 
@@ -17,7 +17,9 @@ func FindRotation(ctx context.Context, lCtx *LuwContext, id int64) (*model.Rotat
 
 	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&rotation.ID,
 		&rotation.Name,
-		&creatorEmail); err != nil {
+		&creatorEmail,
+		&rotation.DistanceKm,
+		&rotation.JoinApproval); err != nil {
 		return nil, err
 	}
 
@@ -44,6 +46,12 @@ func FindRotation(ctx context.Context, lCtx *LuwContext, id int64) (*model.Rotat
 	}
 	rotation.Rides = rides
 
+	schedules, err := FindSchedules(ctx, lCtx, int64(rotation.ID))
+	if err != nil {
+		return nil, err
+	}
+	rotation.Schedules = schedules
+
 	return rotation, nil
 }
 
@@ -84,9 +92,116 @@ func FindRotations(ctx context.Context, lCtx *LuwContext, email *string) ([]*mod
 	return rotations, nil
 }
 
+// FindAllRotations lists every rotation, whoever created it
+func FindAllRotations(ctx context.Context, lCtx *LuwContext) ([]*model.Rotation, error) {
+	const q string = `select id from Rotations where deleteTmstmp is null order by id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotationIds := make([]int64, 0)
+	for rows.Next() {
+		var rotationId int64
+		if err := rows.Scan(&rotationId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		rotationIds = append(rotationIds, rotationId)
+	}
+	rows.Close()
+
+	rotations := make([]*model.Rotation, 0, len(rotationIds))
+	for _, rotationId := range rotationIds {
+		rotation, err := FindRotation(ctx, lCtx, rotationId)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	return rotations, nil
+}
+
+// Condition on the rotation r visible to the viewer bound three times: to its participants, its creator
+// and the members of its organization
+const visibleRotation string = `(r.creatorEmail = ?
+							or exists (select 1 from RotationParticipants p where p.rotationId = r.id and p.email = ?)
+							or exists (select 1 from OrganizationMembers m where m.organizationId = r.organizationId and m.email = ?))`
+
+// FindVisibleRotations lists the rotations visible to the viewer, only the ones created by email when given
+func FindVisibleRotations(ctx context.Context, lCtx *LuwContext, viewerEmail string, email *string) ([]*model.Rotation, error) {
+	const q string = `select r.id
+						from Rotations r
+						where (? is null or r.creatorEmail = ?) and r.deleteTmstmp is null and ` + visibleRotation + `
+						order by r.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, email, email, viewerEmail, viewerEmail, viewerEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotationIds := make([]int64, 0)
+	for rows.Next() {
+		var rotationId int64
+		if err := rows.Scan(&rotationId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		rotationIds = append(rotationIds, rotationId)
+	}
+	rows.Close()
+
+	rotations := make([]*model.Rotation, 0, len(rotationIds))
+	for _, rotationId := range rotationIds {
+		rotation, err := FindRotation(ctx, lCtx, rotationId)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	return rotations, nil
+}
+
+// FindParticipantRotationIds lists the ids of the rotations the user participates in
+func FindParticipantRotationIds(ctx context.Context, lCtx *LuwContext, email string) ([]int64, error) {
+	const q string = `select r.id
+						from Rotations r join RotationParticipants p on p.rotationId = r.id
+						where p.email = ? and r.deleteTmstmp is null
+						order by r.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotationIds := make([]int64, 0)
+	for rows.Next() {
+		var rotationId int64
+		if err := rows.Scan(&rotationId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		rotationIds = append(rotationIds, rotationId)
+	}
+	return rotationIds, rows.Err()
+}
+
 func CreateRotation(ctx context.Context, lCtx *LuwContext, newRot *model.NewRotation) (*model.Rotation, error) {
-	const q string = `INSERT INTO Rotations(name, creatorEmail, createTmstmp, lstUpdTmstmp, deleteTmstmp) 
-						VALUES (?, ?, DATETIME('now'), DATETIME('now'), null)`
+	const q string = `INSERT INTO Rotations(name, creatorEmail, organizationId, createTmstmp, lstUpdTmstmp, deleteTmstmp) 
+						VALUES (?, ?, ?, DATETIME('now'), DATETIME('now'), null)`
+
+	if newRot.IDOrganization != nil {
+		members := append([]string{newRot.EmailCreator}, newRot.EmailParticipants...)
+		if err := checkOrganizationMembers(ctx, lCtx, int64(*newRot.IDOrganization), members); err != nil {
+			return nil, err
+		}
+	}
 
 	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
 	if err != nil {
@@ -94,7 +209,7 @@ func CreateRotation(ctx context.Context, lCtx *LuwContext, newRot *model.NewRota
 	}
 	defer stmt.Close()
 
-	res, err := stmt.ExecContext(ctx, newRot.Name, newRot.EmailCreator)
+	res, err := stmt.ExecContext(ctx, newRot.Name, newRot.EmailCreator, newRot.IDOrganization)
 	if err != nil {
 		return nil, err
 	}
@@ -194,19 +309,43 @@ func FindRotationParticipants(ctx context.Context, lCtx *LuwContext, id int64) (
 	return participants, nil
 }
 
-func CreateRotationParticipants(ctx context.Context, lCtx *LuwContext, rotationId int64, participantsEmails *[]string) error {
-	const q string = `INSERT INTO RotationParticipants (rotationId, email) VALUES (?, ?)`
+// IsRotationParticipant tells whether the user takes part in the rotation
+func IsRotationParticipant(ctx context.Context, lCtx *LuwContext, rotationId int64, email string) (bool, error) {
+	const q string = `select count(*) from RotationParticipants where rotationId = ? and email = ?`
 
-	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
-	if err != nil {
+	var count int
+	err := lCtx.Tx.QueryRowContext(ctx, q, rotationId, email).Scan(&count)
+	return count > 0, err
+}
+
+// CreateRotationParticipants adds the registered users to the rotation, the others are invited
+// and created as UNREGISTRED users when unknown. Only the members of the organization of the
+// rotation can participate.
+func CreateRotationParticipants(ctx context.Context, lCtx *LuwContext, rotationId int64, participantsEmails *[]string) error {
+	if err := checkRotationMembers(ctx, lCtx, rotationId, *participantsEmails); err != nil {
 		return err
 	}
-	defer stmt.Close()
 
 	for _, participantEmail := range *participantsEmails {
-		log.Printf("Add participant for rotation %d - %s", rotationId, participantEmail)
-		_, err = stmt.ExecContext(ctx, rotationId, participantEmail)
-		if err != nil {
+		user, err := FindUser(ctx, lCtx, &participantEmail)
+		switch {
+		case err == sql.ErrNoRows:
+			user, err = createUnregisteredUser(ctx, lCtx, participantEmail)
+			if err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+
+		if user.Role == model.RoleUnregistred {
+			if err := createInvitation(ctx, lCtx, rotationId, participantEmail); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := insertRotationParticipant(ctx, lCtx, rotationId, participantEmail); err != nil {
 			return err
 		}
 	}
@@ -214,6 +353,22 @@ func CreateRotationParticipants(ctx context.Context, lCtx *LuwContext, rotationI
 	return nil
 }
 
+func insertRotationParticipant(ctx context.Context, lCtx *LuwContext, rotationId int64, participantEmail string) error {
+	const q string = `INSERT OR IGNORE INTO RotationParticipants (rotationId, email) VALUES (?, ?)`
+
+	log.Printf("Add participant for rotation %d - %s", rotationId, participantEmail)
+	res, err := lCtx.Tx.ExecContext(ctx, q, rotationId, participantEmail)
+	if err != nil {
+		return err
+	}
+
+	// Already a participant
+	if added, err := res.RowsAffected(); err != nil || added == 0 {
+		return err
+	}
+	return enqueueMemberEvent(ctx, lCtx, rotationId, model.WebhookEventMemberAdded, participantEmail)
+}
+
 func RemoveRotationParticipants(ctx context.Context, lCtx *LuwContext, rotationId int64, participantsEmails *[]string) error {
 	const q string = `DELETE from RotationParticipants where rotationId=? and email=?`
 
@@ -225,10 +380,17 @@ func RemoveRotationParticipants(ctx context.Context, lCtx *LuwContext, rotationI
 
 	for _, participantEmail := range *participantsEmails {
 		log.Printf("Remove participant for rotation %d - %s", rotationId, participantEmail)
-		_, err = stmt.ExecContext(ctx, rotationId, participantEmail)
+		res, err := stmt.ExecContext(ctx, rotationId, participantEmail)
 		if err != nil {
 			return err
 		}
+		if removed, err := res.RowsAffected(); err != nil {
+			return err
+		} else if removed > 0 {
+			if err := enqueueMemberEvent(ctx, lCtx, rotationId, model.WebhookEventMemberRemoved, participantEmail); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,104 @@
+package data_interface
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+// Emission of an average car, for passengers without vehicle
+const defaultCo2GramsPerKm = 160
+
+// Distance each passenger of the rides didn't drive, with the emission of their first vehicle.
+// Rides without distance count for 0 km, cancelled rides are not counted.
+const sharedKm string = `select r.id rideId, r.rotationId, r.rideDate, p.email,
+							coalesce(r.distanceKm, rot.distanceKm, 0) km,
+							coalesce((select f.co2GramsPerKm from Vehicles v join RefFuelType f on v.fuelTypeCd = f.RefCd
+								where v.ownerEmail = p.email and v.deleteTmstmp is null
+								order by v.id limit 1), ?) co2GramsPerKm
+						from Rides r join Rotations rot on rot.id = r.rotationId
+						join RideParticipants p on p.rideId = r.id and p.email <> r.riderEmail
+						where r.deleteTmstmp is null
+						and r.statusCd <> (select RefCd from RefRideStatus where RefName='CANCELLED')`
+
+// newSavings rounds the sums to the meter and to the gram
+func newSavings(km float64, co2Grams float64) *model.Savings {
+	return &model.Savings{
+		KmShared:     math.Round(km*1000) / 1000,
+		Co2AvoidedKg: math.Round(co2Grams) / 1000,
+	}
+}
+
+// savings sums passenger rides before rounding
+type savings struct {
+	km, co2Grams float64
+}
+
+func (s *savings) toModel() *model.Savings {
+	if s == nil {
+		return newSavings(0, 0)
+	}
+	return newSavings(s.km, s.co2Grams)
+}
+
+func addSavings(sums map[string]*savings, key string, km float64, co2Grams float64) {
+	if _, found := sums[key]; !found {
+		sums[key] = new(savings)
+	}
+	sums[key].km += km
+	sums[key].co2Grams += co2Grams
+}
+
+func FindRideSavings(ctx context.Context, lCtx *LuwContext, rideId int64) (*model.Savings, error) {
+	const q string = `select coalesce(sum(km), 0), coalesce(sum(km * co2GramsPerKm), 0)
+						from (` + sharedKm + `)
+						where rideId = ?`
+
+	var km, co2Grams float64
+	if err := lCtx.Tx.QueryRowContext(ctx, q, defaultCo2GramsPerKm, rideId).Scan(&km, &co2Grams); err != nil {
+		return nil, err
+	}
+	return newSavings(km, co2Grams), nil
+}
+
+func FindRotationSavings(ctx context.Context, lCtx *LuwContext, rotationId int64) (*model.Savings, error) {
+	const q string = `select coalesce(sum(km), 0), coalesce(sum(km * co2GramsPerKm), 0)
+						from (` + sharedKm + `)
+						where rotationId = ?`
+
+	var km, co2Grams float64
+	if err := lCtx.Tx.QueryRowContext(ctx, q, defaultCo2GramsPerKm, rotationId).Scan(&km, &co2Grams); err != nil {
+		return nil, err
+	}
+	return newSavings(km, co2Grams), nil
+}
+
+// findPeriodSavings sums the savings of the rotation rides between from and to by bucket start and by passenger
+func findPeriodSavings(ctx context.Context, lCtx *LuwContext, startExpr string, rotationId int64, from time.Time, to time.Time) (map[string]*savings, map[string]*savings, error) {
+	q := fmt.Sprintf(`select %s bucket, email, sum(km), sum(km * co2GramsPerKm)
+						from (`+sharedKm+`)
+						where rotationId = ? and rideDate >= ? and rideDate < ?
+						group by bucket, email`, startExpr)
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, defaultCo2GramsPerKm, rotationId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	byBucket := make(map[string]*savings)
+	byEmail := make(map[string]*savings)
+	for rows.Next() {
+		var bucket, email string
+		var km, co2Grams float64
+		if err := rows.Scan(&bucket, &email, &km, &co2Grams); err != nil {
+			return nil, nil, err
+		}
+		addSavings(byBucket, bucket, km, co2Grams)
+		addSavings(byEmail, email, km, co2Grams)
+	}
+
+	return byBucket, byEmail, rows.Err()
+}
@@ -0,0 +1,421 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+const defaultTimezone = "UTC"
+
+// Weekdays are stored the RRULE way (BYDAY=MO,TU,...)
+var weekdayRuleCodes = map[model.Weekday]string{
+	model.WeekdayMonday:    "MO",
+	model.WeekdayTuesday:   "TU",
+	model.WeekdayWednesday: "WE",
+	model.WeekdayThursday:  "TH",
+	model.WeekdayFriday:    "FR",
+	model.WeekdaySaturday:  "SA",
+	model.WeekdaySunday:    "SU",
+}
+
+var weekdayTimes = map[model.Weekday]time.Weekday{
+	model.WeekdayMonday:    time.Monday,
+	model.WeekdayTuesday:   time.Tuesday,
+	model.WeekdayWednesday: time.Wednesday,
+	model.WeekdayThursday:  time.Thursday,
+	model.WeekdayFriday:    time.Friday,
+	model.WeekdaySaturday:  time.Saturday,
+	model.WeekdaySunday:    time.Sunday,
+}
+
+func encodeByDay(days []model.Weekday) (string, error) {
+	codes := make([]string, 0, len(days))
+	for _, day := range days {
+		code, found := weekdayRuleCodes[day]
+		if !found {
+			return "", fmt.Errorf("invalid weekday %s", day)
+		}
+		codes = append(codes, code)
+	}
+	return strings.Join(codes, ","), nil
+}
+
+func decodeByDay(byDay string) []model.Weekday {
+	days := make([]model.Weekday, 0)
+	for _, code := range strings.Split(byDay, ",") {
+		for day, dayCode := range weekdayRuleCodes {
+			if dayCode == code {
+				days = append(days, day)
+			}
+		}
+	}
+	return days
+}
+
+func parseTimeOfDay(timeOfDay string) (int, int, error) {
+	t, err := time.Parse("15:04", timeOfDay)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %q, expected HH:MM", timeOfDay)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// ScheduleOccurrences lists the slots of a schedule between from and until (both included)
+func ScheduleOccurrences(schedule *model.Schedule, from time.Time, until time.Time) ([]time.Time, error) {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	hour, minute, err := parseTimeOfDay(schedule.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make(map[time.Weekday]bool)
+	for _, day := range schedule.Days {
+		days[weekdayTimes[day]] = true
+	}
+
+	occurrences := make([]time.Time, 0)
+	start := from.In(loc)
+	for day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc); !day.After(until); day = day.AddDate(0, 0, 1) {
+		slot := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+		switch {
+		case !days[slot.Weekday()]:
+			continue
+		case slot.Before(from) || slot.After(until):
+			continue
+		case slot.Before(schedule.StartDate):
+			continue
+		case schedule.EndDate != nil && slot.After(*schedule.EndDate):
+			continue
+		}
+		occurrences = append(occurrences, slot.UTC())
+	}
+
+	return occurrences, nil
+}
+
+// FindScheduleRotation returns the rotation id of a schedule
+func FindScheduleRotation(ctx context.Context, lCtx *LuwContext, id int64) (int64, error) {
+	const q string = `select rotationId from Schedules where id = ? and deleteTmstmp is null`
+
+	var rotationId int64
+	err := lCtx.Tx.QueryRowContext(ctx, q, id).Scan(&rotationId)
+	return rotationId, err
+}
+
+func FindSchedule(ctx context.Context, lCtx *LuwContext, id int64) (*model.Schedule, error) {
+	const q string = `select s.id, s.byDay, s.timeOfDay, s.timezone, d.RefName, s.startDate, s.endDate
+						from Schedules s left join RefDirection d on s.directionCd = d.RefCd
+						where s.id = ? and s.deleteTmstmp is null`
+
+	schedule := new(model.Schedule)
+	var byDay string
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&schedule.ID,
+		&byDay,
+		&schedule.Time,
+		&schedule.Timezone,
+		&schedule.Direction,
+		&schedule.StartDate,
+		&schedule.EndDate); err != nil {
+		return nil, err
+	}
+	schedule.Days = decodeByDay(byDay)
+
+	return schedule, nil
+}
+
+// FindRideTimezone is the time zone of the schedule of the ride, else of the last schedule of its rotation,
+// empty when the rotation never had one
+func FindRideTimezone(ctx context.Context, lCtx *LuwContext, rideId int64) (string, error) {
+	const q string = `select s.timezone
+						from Rides r join Schedules s on s.rotationId = r.rotationId
+						where r.id = ?
+						order by s.id = r.scheduleId desc, s.deleteTmstmp is null desc, s.id desc
+						limit 1`
+
+	var timezone string
+	err := lCtx.Tx.QueryRowContext(ctx, q, rideId).Scan(&timezone)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return timezone, err
+}
+
+func FindSchedules(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.Schedule, error) {
+	const q string = `select id
+						from Schedules s
+						where s.rotationId = ? and s.deleteTmstmp is null
+						order by s.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
+	switch {
+	case err == sql.ErrNoRows:
+		rows.Close()
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	defer rows.Close()
+
+	scheduleIds := make([]int64, 0)
+	for rows.Next() {
+		var scheduleId int64
+		if err := rows.Scan(&scheduleId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		scheduleIds = append(scheduleIds, scheduleId)
+	}
+	rows.Close()
+
+	if len(scheduleIds) == 0 {
+		return nil, nil
+	}
+
+	schedules := make([]*model.Schedule, 0, len(scheduleIds))
+	for _, scheduleId := range scheduleIds {
+		schedule, err := FindSchedule(ctx, lCtx, scheduleId)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+func CreateSchedule(ctx context.Context, lCtx *LuwContext, newSchedule *model.NewSchedule) (*model.Schedule, error) {
+	const q string = `INSERT INTO Schedules(rotationId, byDay, timeOfDay, timezone, directionCd, startDate, endDate, createTmstmp, lstUpdTmstmp, deleteTmstmp)
+						VALUES (?, ?, ?, ?, (select RefCd from RefDirection where RefName=?), ?, ?, DATETIME('now'), DATETIME('now'), null)`
+
+	if len(newSchedule.Days) == 0 {
+		return nil, fmt.Errorf("a schedule needs at least one day")
+	}
+	byDay, err := encodeByDay(newSchedule.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := parseTimeOfDay(newSchedule.Time); err != nil {
+		return nil, err
+	}
+
+	timezone := defaultTimezone
+	if newSchedule.Timezone != nil {
+		timezone = *newSchedule.Timezone
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, err
+	}
+
+	startDate := time.Now().UTC().Truncate(24 * time.Hour)
+	if newSchedule.StartDate != nil {
+		startDate = newSchedule.StartDate.UTC()
+	}
+
+	var endDate *time.Time
+	if newSchedule.EndDate != nil {
+		utcEndDate := newSchedule.EndDate.UTC()
+		endDate = &utcEndDate
+	}
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, newSchedule.IDRotation, byDay, newSchedule.Time, timezone, newSchedule.Direction, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create schedule %s %s for rotation %d assign id %d", byDay, newSchedule.Time, newSchedule.IDRotation, id)
+	return FindSchedule(ctx, lCtx, id)
+}
+
+// DeleteSchedule also drops the rides still planned from this schedule
+func DeleteSchedule(ctx context.Context, lCtx *LuwContext, schedule *model.Schedule) (*model.Schedule, error) {
+	const q string = `UPDATE Schedules set lstUpdTmstmp=DATETIME('now'), deleteTmstmp=DATETIME('now')
+				WHERE id=? and deleteTmstmp is null`
+	const qRides string = `UPDATE Rides set lstUpdTmstmp=DATETIME('now'), deleteTmstmp=DATETIME('now')
+				WHERE scheduleId=? and statusCd=(select RefCd from RefRideStatus where RefName='PLANNED') and deleteTmstmp is null`
+
+	if _, err := lCtx.Tx.ExecContext(ctx, q, schedule.ID); err != nil {
+		return nil, err
+	}
+
+	if _, err := lCtx.Tx.ExecContext(ctx, qRides, schedule.ID); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Delete schedule id %d", schedule.ID)
+	return schedule, nil
+}
+
+// SuggestDriver picks the participant who drove the least in the rotation,
+// the one who did not drive for the longest time first on equality.
+// Participants unavailable at date are skipped.
+func SuggestDriver(ctx context.Context, lCtx *LuwContext, rotationId int64, date time.Time) (*model.User, error) {
+	const q string = `select p.email, count(r.id) as drives, max(r.rideDate) as lastDrive
+						from RotationParticipants p
+						left join Rides r on r.rotationId = p.rotationId and r.riderEmail = p.email
+							and r.deleteTmstmp is null
+							and r.statusCd <> (select RefCd from RefRideStatus where RefName='CANCELLED')
+							and r.rideDate < ?
+						where p.rotationId = ?
+						group by p.email
+						order by drives, lastDrive, p.email`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, date.UTC(), rotationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make([]string, 0)
+	for rows.Next() {
+		var email string
+		var drives int
+		var lastDrive interface{}
+		if err := rows.Scan(&email, &drives, &lastDrive); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, email)
+	}
+	rows.Close()
+
+	for _, email := range candidates {
+		unavailable, err := IsUnavailable(ctx, lCtx, email, date)
+		if err != nil {
+			return nil, err
+		}
+		if unavailable {
+			log.Printf("Skip unavailable driver %s at %s", email, date)
+			continue
+		}
+
+		user, err := FindUser(ctx, lCtx, &email)
+		switch {
+		case err == sql.ErrNoRows:
+			continue
+		case err != nil:
+			return nil, err
+		}
+		return user, nil
+	}
+
+	return nil, nil
+}
+
+// PlanRides materialises the planned rides of every schedule of the rotation up to until,
+// slots already planned (or confirmed, cancelled) are left untouched.
+// Absent participants are left out of the planned rides.
+func PlanRides(ctx context.Context, lCtx *LuwContext, rotationId int64, until time.Time) ([]*model.Ride, error) {
+	const qExists string = `select count(*) from Rides where scheduleId=? and rideDate=?`
+
+	schedules, err := FindSchedules(ctx, lCtx, rotationId)
+	if err != nil {
+		return nil, err
+	}
+
+	participants, err := FindRotationParticipants(ctx, lCtx, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	rides := make([]*model.Ride, 0)
+	for _, schedule := range schedules {
+		occurrences, err := ScheduleOccurrences(schedule, time.Now(), until)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, occurrence := range occurrences {
+			var count int
+			if err := lCtx.Tx.QueryRowContext(ctx, qExists, schedule.ID, occurrence).Scan(&count); err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				continue
+			}
+
+			driver, err := SuggestDriver(ctx, lCtx, rotationId, occurrence)
+			if err != nil {
+				return nil, err
+			}
+			if driver == nil {
+				log.Printf("No driver available for rotation %d at %s", rotationId, occurrence)
+				continue
+			}
+
+			participantEmails := make([]string, 0, len(participants))
+			for _, participant := range participants {
+				absent, err := IsUnavailable(ctx, lCtx, participant.Email, occurrence, model.AvailabilityKindAbsent)
+				if err != nil {
+					return nil, err
+				}
+				if !absent {
+					participantEmails = append(participantEmails, participant.Email)
+				}
+			}
+
+			scheduleId := int64(schedule.ID)
+			direction := schedule.Direction
+			ride, err := insertRide(ctx, lCtx, rotationId, driver.Email, occurrence, &direction, model.RideStatusPlanned, &scheduleId, nil, nil, &participantEmails)
+			if err != nil {
+				return nil, err
+			}
+			rides = append(rides, ride)
+		}
+	}
+
+	return rides, nil
+}
+
+// PlanAllRides materialises the planned rides of every rotation having a schedule
+func PlanAllRides(ctx context.Context, lCtx *LuwContext, until time.Time) ([]*model.Ride, error) {
+	const q string = `select distinct s.rotationId
+						from Schedules s join Rotations r on r.id = s.rotationId
+						where s.deleteTmstmp is null and r.deleteTmstmp is null`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotationIds := make([]int64, 0)
+	for rows.Next() {
+		var rotationId int64
+		if err := rows.Scan(&rotationId); err != nil {
+			return nil, err
+		}
+		rotationIds = append(rotationIds, rotationId)
+	}
+	rows.Close()
+
+	rides := make([]*model.Ride, 0)
+	for _, rotationId := range rotationIds {
+		planned, err := PlanRides(ctx, lCtx, rotationId, until)
+		if err != nil {
+			return nil, err
+		}
+		rides = append(rides, planned...)
+	}
+
+	return rides, nil
+}
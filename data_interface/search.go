@@ -0,0 +1,153 @@
+package data_interface
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"whosdriving-be/graph/model"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// indexUser refreshes the full text index of the user, UsersSearch has no trigger
+func indexUser(ctx context.Context, lCtx *LuwContext, email string) error {
+	const qDelete string = `DELETE FROM UsersSearch WHERE docid = (select rowid from Users where email = ?)`
+	const qInsert string = `INSERT INTO UsersSearch(docid, email, firstname, lastname)
+						select u.rowid, u.email, coalesce(u.firstname, ''), coalesce(u.lastname, '') from Users u where u.email = ?`
+
+	if _, err := lCtx.Tx.ExecContext(ctx, qDelete, email); err != nil {
+		return err
+	}
+	_, err := lCtx.Tx.ExecContext(ctx, qInsert, email)
+	return err
+}
+
+// searchMatch turns the words of the search into a full text query matching their prefixes,
+// empty when the search has no word
+func searchMatch(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// searchPattern is the LIKE pattern of the users containing search
+func searchPattern(search string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + escaper.Replace(strings.ToLower(search)) + "%"
+}
+
+// The cursor is the rank and the email of the last user of the page
+func encodeSearchCursor(rank int, email string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", rank, email)))
+}
+
+func decodeSearchCursor(cursor string) (int, string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) == 2 {
+			rank, err := strconv.Atoi(parts[0])
+			if err == nil {
+				return rank, parts[1], nil
+			}
+		}
+	}
+	return 0, "", fmt.Errorf("invalid cursor %q", cursor)
+}
+
+// SearchUsers pages through the users matching search, those starting with its words before those only
+// containing it. Only the users visible to the viewer are listed, see visibleUser.
+func SearchUsers(ctx context.Context, lCtx *LuwContext, viewerEmail string, search *string, first *int, after *string) (*model.UserConnection, error) {
+	pageSize := defaultSearchPageSize
+	if first != nil {
+		pageSize = *first
+	}
+	if pageSize < 1 || pageSize > maxSearchPageSize {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxSearchPageSize)
+	}
+
+	afterRank, afterEmail := -1, ""
+	if after != nil {
+		var err error
+		if afterRank, afterEmail, err = decodeSearchCursor(*after); err != nil {
+			return nil, err
+		}
+	}
+
+	rank := "0"
+	conditions := []string{"u.deleteTmstmp is null"}
+	args := make([]interface{}, 0)
+	if search != nil && strings.TrimSpace(*search) != "" {
+		const contains string = `lower(u.email || ' ' || coalesce(u.firstname, '') || ' ' || coalesce(u.lastname, '')) like ? escape '\'`
+		const matches string = `u.rowid in (select docid from UsersSearch where UsersSearch match ?)`
+
+		pattern := searchPattern(strings.TrimSpace(*search))
+		if match := searchMatch(*search); match != "" {
+			rank = "case when " + matches + " then 0 else 1 end"
+			conditions = append(conditions, "("+matches+" or "+contains+")")
+			args = append(args, match, match, pattern)
+		} else {
+			rank = "1"
+			conditions = append(conditions, contains)
+			args = append(args, pattern)
+		}
+	}
+	conditions = append(conditions, visibleUser)
+	args = append(args, viewerEmail, viewerEmail)
+
+	q := fmt.Sprintf(`select email, rnk
+						from (select u.email, %s rnk from Users u where %s)
+						where (rnk, email) > (?, ?)
+						order by rnk, email
+						limit ?`, rank, strings.Join(conditions, " and "))
+	args = append(args, afterRank, afterEmail, pageSize+1)
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := make([]string, 0)
+	ranks := make([]int, 0)
+	for rows.Next() {
+		var email string
+		var rank int
+		if err := rows.Scan(&email, &rank); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		emails = append(emails, email)
+		ranks = append(ranks, rank)
+	}
+	rows.Close()
+
+	connection := &model.UserConnection{Users: make([]*model.User, 0, pageSize)}
+	if len(emails) > pageSize {
+		connection.HasNextPage = true
+		emails, ranks = emails[:pageSize], ranks[:pageSize]
+	}
+	for _, email := range emails {
+		user, err := FindUser(ctx, lCtx, &email)
+		if err != nil {
+			return nil, err
+		}
+		connection.Users = append(connection.Users, user)
+	}
+	if last := len(emails) - 1; last >= 0 {
+		cursor := encodeSearchCursor(ranks[last], emails[last])
+		connection.EndCursor = &cursor
+	}
+
+	return connection, nil
+}
@@ -0,0 +1,48 @@
+package data_interface
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// CreateSession opens a session of the user lasting duration, returns its token. Expired sessions are purged.
+func CreateSession(ctx context.Context, lCtx *LuwContext, email string, duration time.Duration) (string, error) {
+	const qPurge string = `DELETE FROM Sessions WHERE expireTmstmp <= ?`
+	const qInsert string = `INSERT INTO Sessions(tokenHash, email, expireTmstmp, createTmstmp)
+							VALUES (?, ?, ?, DATETIME('now'))`
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := lCtx.Tx.ExecContext(ctx, qPurge, now); err != nil {
+		return "", err
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := lCtx.Tx.ExecContext(ctx, qInsert, tokenHash, email, now.Add(duration)); err != nil {
+		return "", err
+	}
+
+	log.Printf("Open session of %s", email)
+	return token, nil
+}
+
+// FindSessionUser returns the email of the user of the session, sql.ErrNoRows when unknown or expired
+func FindSessionUser(ctx context.Context, lCtx *LuwContext, token string) (string, error) {
+	const q string = `select s.email
+						from Sessions s join Users u on u.email = s.email
+						where s.tokenHash = ? and s.expireTmstmp > ? and u.deleteTmstmp is null`
+
+	var email string
+	err := lCtx.Tx.QueryRowContext(ctx, q, hashToken(token), time.Now().UTC()).Scan(&email)
+	return email, err
+}
+
+func DeleteSession(ctx context.Context, lCtx *LuwContext, token string) error {
+	const q string = `DELETE FROM Sessions WHERE tokenHash = ?`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, hashToken(token))
+	return err
+}
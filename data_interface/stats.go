@@ -0,0 +1,239 @@
+package data_interface
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+	"whosdriving-be/graph/model"
+)
+
+// Rides of the rotation counted in the statistics, with their number of passengers
+const statsRides string = `with counted as (
+						select r.id, r.riderEmail, r.rideDate,
+							(select count(*) from RideParticipants p where p.rideId = r.id and p.email <> r.riderEmail) passengers
+						from Rides r
+						where r.rotationId = ? and r.deleteTmstmp is null
+						and r.statusCd <> (select RefCd from RefRideStatus where RefName='CANCELLED')
+						and r.rideDate >= ? and r.rideDate < ?)
+					`
+
+// SQL expression of the first day of the bucket of a ride, in UTC
+var bucketStarts = map[model.Granularity]string{
+	model.GranularityWeek:  `date(rideDate, 'weekday 0', '-6 days')`,
+	model.GranularityMonth: `strftime('%Y-%m-01', rideDate)`,
+}
+
+func bucketStart(t time.Time, granularity model.Granularity) time.Time {
+	year, month, day := t.UTC().Date()
+	if granularity == model.GranularityMonth {
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	start := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+}
+
+func nextBucket(start time.Time, granularity model.Granularity) time.Time {
+	if granularity == model.GranularityMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 7)
+}
+
+func average(total int, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(total) / float64(count)
+}
+
+// FindRotationStats aggregates the rides between from and to, every bucket of the period is listed even without rides
+func FindRotationStats(ctx context.Context, lCtx *LuwContext, rotationId int64, from time.Time, to time.Time, granularity model.Granularity) (*model.RotationStats, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("the period must end after it starts")
+	}
+	startExpr, found := bucketStarts[granularity]
+	if !found {
+		return nil, fmt.Errorf("invalid granularity %s", granularity)
+	}
+
+	stats := &model.RotationStats{From: from, To: to}
+	buckets, err := findStatsBuckets(ctx, lCtx, startExpr, rotationId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	bucketSavings, memberSavings, err := findPeriodSavings(ctx, lCtx, startExpr, rotationId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var passengers int
+	var total savings
+	stats.Buckets = make([]*model.StatsBucket, 0)
+	for start := bucketStart(from, granularity); start.Before(to); start = nextBucket(start, granularity) {
+		key := start.Format("2006-01-02")
+		bucket, found := buckets[key]
+		if !found {
+			bucket = &model.StatsBucket{}
+		}
+		bucket.Start = start
+		bucket.AveragePassengers = average(bucket.Passengers, bucket.Rides)
+		bucket.Savings = bucketSavings[key].toModel()
+		stats.Buckets = append(stats.Buckets, bucket)
+
+		stats.Rides += bucket.Rides
+		passengers += bucket.Passengers
+		if sums, found := bucketSavings[key]; found {
+			total.km += sums.km
+			total.co2Grams += sums.co2Grams
+		}
+	}
+	stats.AveragePassengers = average(passengers, stats.Rides)
+	stats.Savings = total.toModel()
+
+	members, err := findMemberStats(ctx, lCtx, rotationId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.DrivingShare = average(member.Drives, stats.Rides)
+		member.Savings = memberSavings[member.User.Email].toModel()
+	}
+	stats.Members = members
+
+	return stats, nil
+}
+
+func findStatsBuckets(ctx context.Context, lCtx *LuwContext, startExpr string, rotationId int64, from time.Time, to time.Time) (map[string]*model.StatsBucket, error) {
+	q := statsRides + fmt.Sprintf(`select %s bucket, count(*), sum(passengers)
+						from counted
+						group by bucket`, startExpr)
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make(map[string]*model.StatsBucket)
+	for rows.Next() {
+		var start string
+		bucket := new(model.StatsBucket)
+		if err := rows.Scan(&start, &bucket.Rides, &bucket.Passengers); err != nil {
+			return nil, err
+		}
+		buckets[start] = bucket
+	}
+
+	return buckets, rows.Err()
+}
+
+// findMemberStats returns the rotation participants, and former ones who rode in the period, most drives first
+func findMemberStats(ctx context.Context, lCtx *LuwContext, rotationId int64, from time.Time, to time.Time) ([]*model.MemberStats, error) {
+	const qCounts string = statsRides + `select email, sum(drives), sum(passengerRides)
+						from (select riderEmail email, 1 drives, 0 passengerRides from counted
+							union all
+							select p.email, 0, 1 from counted c join RideParticipants p on p.rideId = c.id and p.email <> c.riderEmail)
+						group by email`
+	// Gaps and islands, consecutive rides of a conductor share the same difference of row numbers
+	const qStreaks string = statsRides + `, runs as (
+							select riderEmail, rideDate, id,
+								row_number() over (order by rideDate, id)
+								- row_number() over (partition by riderEmail order by rideDate, id) run
+							from counted)
+						select riderEmail, count(*), max(rideDate || printf('%020d', id)) last
+						from runs
+						group by riderEmail, run
+						order by last`
+
+	participants, err := FindRotationParticipants(ctx, lCtx, rotationId)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]*model.MemberStats)
+	for _, participant := range participants {
+		members[participant.Email] = &model.MemberStats{User: participant}
+	}
+
+	member := func(email string) (*model.MemberStats, error) {
+		if stats, found := members[email]; found {
+			return stats, nil
+		}
+		user, err := FindUser(ctx, lCtx, &email)
+		if err != nil {
+			return nil, err
+		}
+		members[email] = &model.MemberStats{User: user}
+		return members[email], nil
+	}
+
+	rows, err := lCtx.Tx.QueryContext(ctx, qCounts, rotationId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type counts struct {
+		email                  string
+		drives, passengerRides int
+	}
+	allCounts := make([]counts, 0)
+	for rows.Next() {
+		var c counts
+		if err := rows.Scan(&c.email, &c.drives, &c.passengerRides); err != nil {
+			return nil, err
+		}
+		allCounts = append(allCounts, c)
+	}
+	rows.Close()
+
+	for _, c := range allCounts {
+		stats, err := member(c.email)
+		if err != nil {
+			return nil, err
+		}
+		stats.Drives = c.drives
+		stats.PassengerRides = c.passengerRides
+	}
+
+	rows, err = lCtx.Tx.QueryContext(ctx, qStreaks, rotationId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Runs come in order, the last one is the current streak
+	var lastEmail string
+	var lastLength int
+	for rows.Next() {
+		var email, last string
+		var length int
+		if err := rows.Scan(&email, &length, &last); err != nil {
+			return nil, err
+		}
+		if stats, found := members[email]; found && length > stats.LongestStreak {
+			stats.LongestStreak = length
+		}
+		lastEmail, lastLength = email, length
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if stats, found := members[lastEmail]; found {
+		stats.CurrentStreak = lastLength
+	}
+
+	sorted := make([]*model.MemberStats, 0, len(members))
+	for _, stats := range members {
+		sorted = append(sorted, stats)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Drives != sorted[j].Drives {
+			return sorted[i].Drives > sorted[j].Drives
+		}
+		return sorted[i].User.Email < sorted[j].User.Email
+	})
+
+	return sorted, nil
+}
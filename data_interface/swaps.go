@@ -0,0 +1,309 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"whosdriving-be/graph/model"
+)
+
+func FindSwapRequest(ctx context.Context, lCtx *LuwContext, id int64) (*model.SwapRequest, error) {
+	const q string = `select sr.id, sr.rideId, sr.requesterEmail, sr.targetEmail, sr.exchangeRideId, s.RefName, sr.createTmstmp
+						from SwapRequests sr left join RefSwapStatus s on sr.statusCd = s.RefCd
+						where sr.id = ?`
+
+	swap := new(model.SwapRequest)
+	var rideId int64
+	var requesterEmail, targetEmail string
+	var exchangeRideId sql.NullInt64
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&swap.ID,
+		&rideId,
+		&requesterEmail,
+		&targetEmail,
+		&exchangeRideId,
+		&swap.Status,
+		&swap.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	ride, err := FindRide(ctx, lCtx, rideId)
+	if err != nil {
+		return nil, err
+	}
+	swap.Ride = ride
+
+	if exchangeRideId.Valid {
+		exchangeRide, err := FindRide(ctx, lCtx, exchangeRideId.Int64)
+		if err != nil {
+			return nil, err
+		}
+		swap.ExchangeRide = exchangeRide
+	}
+
+	requester, err := FindUser(ctx, lCtx, &requesterEmail)
+	if err != nil {
+		return nil, err
+	}
+	swap.Requester = requester
+
+	target, err := FindUser(ctx, lCtx, &targetEmail)
+	if err != nil {
+		return nil, err
+	}
+	swap.Target = target
+
+	return swap, nil
+}
+
+// FindUserSwapRequests lists the pending swaps asked by or to the user
+func FindUserSwapRequests(ctx context.Context, lCtx *LuwContext, email string) ([]*model.SwapRequest, error) {
+	const q string = `select sr.id
+						from SwapRequests sr
+						where (sr.requesterEmail = ? or sr.targetEmail = ?)
+						and sr.statusCd = (select RefCd from RefSwapStatus where RefName='PENDING')
+						order by sr.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, email, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	swapIds := make([]int64, 0)
+	for rows.Next() {
+		var swapId int64
+		if err := rows.Scan(&swapId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		swapIds = append(swapIds, swapId)
+	}
+	rows.Close()
+
+	swaps := make([]*model.SwapRequest, 0, len(swapIds))
+	for _, swapId := range swapIds {
+		swap, err := FindSwapRequest(ctx, lCtx, swapId)
+		if err != nil {
+			return nil, err
+		}
+		swaps = append(swaps, swap)
+	}
+
+	return swaps, nil
+}
+
+// checkSwappable rejects cancelled rides and rides not driven by conductorEmail
+func checkSwappable(ride *model.Ride, conductorEmail string) error {
+	if ride.Status == model.RideStatusCancelled {
+		return fmt.Errorf("ride %d is cancelled", ride.ID)
+	}
+	if ride.Conductor.Email != conductorEmail {
+		return fmt.Errorf("ride %d is not driven by %s", ride.ID, conductorEmail)
+	}
+	return nil
+}
+
+// CreateSwapRequest asks the target to drive the ride of the requester, in exchange of a ride of the target when given
+func CreateSwapRequest(ctx context.Context, lCtx *LuwContext, requesterEmail string, newSwap *model.NewSwapRequest) (*model.SwapRequest, error) {
+	const q string = `INSERT INTO SwapRequests(rideId, requesterEmail, targetEmail, exchangeRideId, statusCd, createTmstmp, lstUpdTmstmp, respondTmstmp)
+						VALUES (?, ?, ?, ?, (select RefCd from RefSwapStatus where RefName='PENDING'), DATETIME('now'), DATETIME('now'), null)`
+
+	if newSwap.EmailTarget == requesterEmail {
+		return nil, fmt.Errorf("can't swap a ride with oneself")
+	}
+
+	ride, err := FindRide(ctx, lCtx, int64(newSwap.IDRide))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSwappable(ride, requesterEmail); err != nil {
+		return nil, err
+	}
+
+	rotationId, _, err := FindRideRotation(ctx, lCtx, int64(ride.ID))
+	if err != nil {
+		return nil, err
+	}
+	participant, err := IsRotationParticipant(ctx, lCtx, rotationId, newSwap.EmailTarget)
+	if err != nil {
+		return nil, err
+	}
+	if !participant {
+		return nil, fmt.Errorf("%s doesn't participate in rotation %d", newSwap.EmailTarget, rotationId)
+	}
+
+	if newSwap.IDExchangeRide != nil {
+		exchangeRide, err := FindRide(ctx, lCtx, int64(*newSwap.IDExchangeRide))
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSwappable(exchangeRide, newSwap.EmailTarget); err != nil {
+			return nil, err
+		}
+		exchangeRotationId, _, err := FindRideRotation(ctx, lCtx, int64(exchangeRide.ID))
+		if err != nil {
+			return nil, err
+		}
+		if exchangeRotationId != rotationId {
+			return nil, fmt.Errorf("ride %d is not in rotation %d", exchangeRide.ID, rotationId)
+		}
+	}
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, newSwap.IDRide, requesterEmail, newSwap.EmailTarget, newSwap.IDExchangeRide)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("%s asks %s to drive ride %d, swap id %d", requesterEmail, newSwap.EmailTarget, newSwap.IDRide, id)
+	return FindSwapRequest(ctx, lCtx, id)
+}
+
+func changeSwapStatus(ctx context.Context, lCtx *LuwContext, swap *model.SwapRequest, status model.SwapStatus) error {
+	const q string = `UPDATE SwapRequests set statusCd=(select RefCd from RefSwapStatus where RefName=?),
+					lstUpdTmstmp=DATETIME('now'), respondTmstmp=DATETIME('now')
+				WHERE id=?`
+
+	if swap.Status != model.SwapStatusPending {
+		return fmt.Errorf("swap %d is already %s", swap.ID, swap.Status)
+	}
+
+	if _, err := lCtx.Tx.ExecContext(ctx, q, status, swap.ID); err != nil {
+		return err
+	}
+
+	log.Printf("Swap %d %s", swap.ID, status)
+	return nil
+}
+
+// takeOver makes the user the conductor of the ride, as a participant too
+func takeOver(ctx context.Context, lCtx *LuwContext, ride *model.Ride, conductor *model.User) error {
+	participant := false
+	for _, p := range ride.Participants {
+		participant = participant || p.Email == conductor.Email
+	}
+	if !participant {
+		if err := CreateRideParticipants(ctx, lCtx, int64(ride.ID), &[]string{conductor.Email}); err != nil {
+			return err
+		}
+	}
+
+	ride.Conductor = conductor
+	_, err := UpdateRide(ctx, lCtx, ride)
+	return err
+}
+
+// AcceptSwapRequest hands the ride over to the target, and the exchange ride to the requester,
+// the costs of both rides are recorded again with their new conductor
+func AcceptSwapRequest(ctx context.Context, lCtx *LuwContext, swap *model.SwapRequest) (*model.SwapRequest, error) {
+	const qCancel string = `UPDATE SwapRequests set statusCd=(select RefCd from RefSwapStatus where RefName='CANCELLED'),
+					lstUpdTmstmp=DATETIME('now')
+				WHERE id<>? and statusCd=(select RefCd from RefSwapStatus where RefName='PENDING')
+				and (rideId in (?, ?) or exchangeRideId in (?, ?))`
+
+	if err := checkSwappable(swap.Ride, swap.Requester.Email); err != nil {
+		return nil, err
+	}
+	exchangeRideId := int64(swap.Ride.ID)
+	if swap.ExchangeRide != nil {
+		if err := checkSwappable(swap.ExchangeRide, swap.Target.Email); err != nil {
+			return nil, err
+		}
+		exchangeRideId = int64(swap.ExchangeRide.ID)
+	}
+
+	if err := changeSwapStatus(ctx, lCtx, swap, model.SwapStatusAccepted); err != nil {
+		return nil, err
+	}
+
+	if err := takeOver(ctx, lCtx, swap.Ride, swap.Target); err != nil {
+		return nil, err
+	}
+	if swap.ExchangeRide != nil {
+		if err := takeOver(ctx, lCtx, swap.ExchangeRide, swap.Requester); err != nil {
+			return nil, err
+		}
+	}
+
+	// The other requests on these rides are outdated
+	if _, err := lCtx.Tx.ExecContext(ctx, qCancel, swap.ID, swap.Ride.ID, exchangeRideId, swap.Ride.ID, exchangeRideId); err != nil {
+		return nil, err
+	}
+
+	return FindSwapRequest(ctx, lCtx, int64(swap.ID))
+}
+
+// HandOverRide gives the ride to another participant of the rotation without swap request, as the
+// owner does, the costs are recorded again with the new conductor and the pending requests are outdated
+func HandOverRide(ctx context.Context, lCtx *LuwContext, ride *model.Ride, conductorEmail string) (*model.Ride, error) {
+	const qCancel string = `UPDATE SwapRequests set statusCd=(select RefCd from RefSwapStatus where RefName='CANCELLED'),
+					lstUpdTmstmp=DATETIME('now')
+				WHERE statusCd=(select RefCd from RefSwapStatus where RefName='PENDING')
+				and (rideId = ? or exchangeRideId = ?)`
+
+	if ride.Status == model.RideStatusCancelled {
+		return nil, fmt.Errorf("ride %d is cancelled", ride.ID)
+	}
+
+	rotationId, _, err := FindRideRotation(ctx, lCtx, int64(ride.ID))
+	if err != nil {
+		return nil, err
+	}
+	participant, err := IsRotationParticipant(ctx, lCtx, rotationId, conductorEmail)
+	if err != nil {
+		return nil, err
+	}
+	if !participant {
+		return nil, fmt.Errorf("%s doesn't participate in rotation %d", conductorEmail, rotationId)
+	}
+	conductor, err := FindUser(ctx, lCtx, &conductorEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := takeOver(ctx, lCtx, ride, conductor); err != nil {
+		return nil, err
+	}
+	if _, err := lCtx.Tx.ExecContext(ctx, qCancel, ride.ID, ride.ID); err != nil {
+		return nil, err
+	}
+
+	return FindRide(ctx, lCtx, int64(ride.ID))
+}
+
+func DeclineSwapRequest(ctx context.Context, lCtx *LuwContext, swap *model.SwapRequest) (*model.SwapRequest, error) {
+	if err := changeSwapStatus(ctx, lCtx, swap, model.SwapStatusDeclined); err != nil {
+		return nil, err
+	}
+	return FindSwapRequest(ctx, lCtx, int64(swap.ID))
+}
+
+func CancelSwapRequest(ctx context.Context, lCtx *LuwContext, swap *model.SwapRequest) (*model.SwapRequest, error) {
+	if err := changeSwapStatus(ctx, lCtx, swap, model.SwapStatusCancelled); err != nil {
+		return nil, err
+	}
+	return FindSwapRequest(ctx, lCtx, int64(swap.ID))
+}
+
+// swappedWith returns whom the conductor of the ride took it over from with an accepted swap, if any
+func swappedWith(ctx context.Context, lCtx *LuwContext, rideId int64) (string, error) {
+	const q string = `select case when sr.rideId = ? then sr.requesterEmail else sr.targetEmail end
+						from SwapRequests sr
+						where (sr.rideId = ? or sr.exchangeRideId = ?)
+						and sr.statusCd = (select RefCd from RefSwapStatus where RefName='ACCEPTED')
+						order by sr.id desc
+						limit 1`
+
+	var email string
+	err := lCtx.Tx.QueryRowContext(ctx, q, rideId, rideId, rideId).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return email, err
+}
@@ -3,22 +3,40 @@ package data_interface
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"regexp"
+	"strings"
+	"whosdriving-be/avatar"
 	"whosdriving-be/graph/model"
 )
 
+// Digits, optionally international, with the usual separators
+var phoneNumber = regexp.MustCompile(`^\+?[0-9(][0-9 ().-]{4,23}$`)
+
 func FindUser(ctx context.Context, lCtx *LuwContext, email *string) (*model.User, error) {
-	const q string = `select email, firstname, lastname, profile, RefRole.RefName
+	return findUser(ctx, lCtx, email, false)
+}
+
+func findUser(ctx context.Context, lCtx *LuwContext, email *string, withDeleted bool) (*model.User, error) {
+	const q string = `select email, firstname, lastname, profile, phone, avatar, RefRole.RefName
 						from Users left join RefRole on Users.roleCd = RefRole.RefCd 
-						where email = ? and deleteTmstmp is null`
+						where email = ? and (deleteTmstmp is null or ?)`
 	user := new(model.User)
-	if err := lCtx.Tx.QueryRowContext(ctx, q, &email).Scan(&user.Email,
+	var avatarName sql.NullString
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &email, withDeleted).Scan(&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Profile,
+		&user.Phone,
+		&avatarName,
 		&user.Role); err != nil {
 		return nil, err
 	}
+	if avatarName.Valid {
+		url, thumbnailUrl := avatar.URL(avatarName.String), avatar.ThumbnailURL(avatarName.String)
+		user.AvatarURL, user.AvatarThumbnailURL = &url, &thumbnailUrl
+	}
 	return user, nil
 }
 
@@ -54,11 +72,66 @@ func CreateUser(ctx context.Context, lCtx *LuwContext, newUser *model.NewUser) (
 		return nil, err
 	}
 
+	if err := indexUser(ctx, lCtx, newUser.Email); err != nil {
+		return nil, err
+	}
 	return FindUser(ctx, lCtx, &newUser.Email)
 }
 
+// FindOrCreateUser returns the user, created when unknown and registered when only invited
+func FindOrCreateUser(ctx context.Context, lCtx *LuwContext, newUser *model.NewUser) (*model.User, error) {
+	user, err := FindUser(ctx, lCtx, &newUser.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			user, err = CreateUser(ctx, lCtx, newUser)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Invited before signing up
+	return RegisterUser(ctx, lCtx, user, newUser.FirstName, newUser.LastName)
+}
+
+// createUnregisteredUser records a person invited before having an account
+func createUnregisteredUser(ctx context.Context, lCtx *LuwContext, email string) (*model.User, error) {
+	const q string = `INSERT INTO Users(email, roleCd, createTmstmp, lstUpdTmstmp, deleteTmstmp) 
+	VALUES (?, (select RefCd from RefRole where RefName='UNREGISTRED'), DATETIME('now'), DATETIME('now'), null)`
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, email)
+	if err != nil {
+		return nil, err
+	}
+	if err := indexUser(ctx, lCtx, email); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create unregistered user %s", email)
+	return FindUser(ctx, lCtx, &email)
+}
+
+// RegisterUser turns an UNREGISTRED user into a STANDARD one, the names are only set when given
+func RegisterUser(ctx context.Context, lCtx *LuwContext, user *model.User, firstName *string, lastName *string) (*model.User, error) {
+	if user.Role != model.RoleUnregistred {
+		return user, nil
+	}
+
+	registered := *user
+	registered.Role = model.RoleStandard
+	if firstName != nil {
+		registered.FirstName = firstName
+	}
+	if lastName != nil {
+		registered.LastName = lastName
+	}
+
+	log.Printf("Register user %s", user.Email)
+	return UpdateUser(ctx, lCtx, &registered)
+}
+
 func UpdateUser(ctx context.Context, lCtx *LuwContext, user *model.User) (*model.User, error) {
-	const q string = `UPDATE Users set firstname=?, lastname=?, profile=?, roleCd=(select refCd from RefRole where RefName=?), lstUpdTmstmp=DATETIME('now') 
+	const q string = `UPDATE Users set firstname=?, lastname=?, profile=?, phone=?, roleCd=(select refCd from RefRole where RefName=?), lstUpdTmstmp=DATETIME('now') 
 				WHERE email=? and deleteTmstmp is null`
 
 	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
@@ -67,14 +140,83 @@ func UpdateUser(ctx context.Context, lCtx *LuwContext, user *model.User) (*model
 	}
 	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx, user.FirstName, user.LastName, user.Profile, user.Role, user.Email)
+	_, err = stmt.ExecContext(ctx, user.FirstName, user.LastName, user.Profile, user.Phone, user.Role, user.Email)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := indexUser(ctx, lCtx, user.Email); err != nil {
+		return nil, err
+	}
 	return FindUser(ctx, lCtx, &user.Email)
 }
 
+// profileValue is the new value of a profile field, nil when cleared by an empty string
+func profileValue(value string) *string {
+	if value = strings.TrimSpace(value); value == "" {
+		return nil
+	}
+	return &value
+}
+
+// UpdateProfile changes the fields of the profile given, the preferences included
+func UpdateProfile(ctx context.Context, lCtx *LuwContext, email string, newProfile *model.NewProfile) (*model.User, error) {
+	user, err := FindUser(ctx, lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+
+	if newProfile.FirstName != nil {
+		user.FirstName = profileValue(*newProfile.FirstName)
+	}
+	if newProfile.LastName != nil {
+		user.LastName = profileValue(*newProfile.LastName)
+	}
+	if newProfile.Profile != nil {
+		user.Profile = profileValue(*newProfile.Profile)
+	}
+	if newProfile.Phone != nil {
+		user.Phone = profileValue(*newProfile.Phone)
+		if user.Phone != nil && !phoneNumber.MatchString(*user.Phone) {
+			return nil, fmt.Errorf("invalid phone number %q", *user.Phone)
+		}
+	}
+
+	if preferences := newProfile.NotificationPreferences; preferences != nil {
+		if _, err := UpdateNotificationPreferences(ctx, lCtx, &model.NewNotificationPreferences{
+			Email:           email,
+			AddedToRotation: preferences.AddedToRotation,
+			DriverReminder:  preferences.DriverReminder,
+			RideCancelled:   preferences.RideCancelled,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Update profile of %s", email)
+	return UpdateUser(ctx, lCtx, user)
+}
+
+// SetUserAvatar replaces the avatar of the user, removed when nil. Returns the previous one for its files to be deleted.
+func SetUserAvatar(ctx context.Context, lCtx *LuwContext, email string, avatarName *string) (*string, error) {
+	const qFind string = `select avatar from Users where email=? and deleteTmstmp is null`
+	const qUpdate string = `UPDATE Users set avatar=?, lstUpdTmstmp=DATETIME('now') WHERE email=? and deleteTmstmp is null`
+
+	var previous sql.NullString
+	if err := lCtx.Tx.QueryRowContext(ctx, qFind, email).Scan(&previous); err != nil {
+		return nil, err
+	}
+	if _, err := lCtx.Tx.ExecContext(ctx, qUpdate, avatarName, email); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Set avatar of %s", email)
+	if !previous.Valid {
+		return nil, nil
+	}
+	return &previous.String, nil
+}
+
 func DeleteUser(ctx context.Context, lCtx *LuwContext, user *model.User) (*model.User, error) {
 	const q string = `UPDATE Users set deleteTmstmp=DATETIME('now'), lstUpdTmstmp=DATETIME('now') WHERE email=? and deleteTmstmp is null`
 
@@ -91,3 +233,40 @@ func DeleteUser(ctx context.Context, lCtx *LuwContext, user *model.User) (*model
 
 	return user, nil
 }
+
+// GenerateCalendarToken replaces the token of the user calendar feed
+func GenerateCalendarToken(ctx context.Context, lCtx *LuwContext, email string) (string, error) {
+	const q string = `UPDATE Users set calendarTokenHash=?, lstUpdTmstmp=DATETIME('now') WHERE email=? and deleteTmstmp is null`
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := lCtx.Tx.ExecContext(ctx, q, tokenHash, email)
+	if err != nil {
+		return "", err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	log.Printf("Generate calendar token for %s", email)
+	return token, nil
+}
+
+func FindUserByCalendarToken(ctx context.Context, lCtx *LuwContext, token string) (*model.User, error) {
+	const q string = `select email from Users where calendarTokenHash = ? and deleteTmstmp is null`
+
+	var email string
+	if err := lCtx.Tx.QueryRowContext(ctx, q, hashToken(token)).Scan(&email); err != nil {
+		return nil, err
+	}
+
+	return FindUser(ctx, lCtx, &email)
+}
@@ -0,0 +1,159 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"whosdriving-be/graph/model"
+)
+
+func FindVehicle(ctx context.Context, lCtx *LuwContext, id int64) (*model.Vehicle, error) {
+	return findVehicle(ctx, lCtx, id, false)
+}
+
+func findVehicle(ctx context.Context, lCtx *LuwContext, id int64, withDeleted bool) (*model.Vehicle, error) {
+	const q string = `select v.id, v.ownerEmail, v.label, v.seats, f.RefName, v.consumption
+						from Vehicles v left join RefFuelType f on v.fuelTypeCd = f.RefCd
+						where v.id = ? and (v.deleteTmstmp is null or ?)`
+
+	vehicle := new(model.Vehicle)
+	var ownerEmail string
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id, withDeleted).Scan(&vehicle.ID,
+		&ownerEmail,
+		&vehicle.Label,
+		&vehicle.Seats,
+		&vehicle.FuelType,
+		&vehicle.Consumption); err != nil {
+		return nil, err
+	}
+
+	owner, err := FindUser(ctx, lCtx, &ownerEmail)
+	if err != nil {
+		return nil, err
+	}
+	vehicle.Owner = owner
+
+	return vehicle, nil
+}
+
+func FindUserVehicles(ctx context.Context, lCtx *LuwContext, email string) ([]*model.Vehicle, error) {
+	const q string = `select id
+						from Vehicles v
+						where v.ownerEmail = ? and v.deleteTmstmp is null
+						order by v.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vehicleIds := make([]int64, 0)
+	for rows.Next() {
+		var vehicleId int64
+		if err := rows.Scan(&vehicleId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		vehicleIds = append(vehicleIds, vehicleId)
+	}
+	rows.Close()
+
+	vehicles := make([]*model.Vehicle, 0, len(vehicleIds))
+	for _, vehicleId := range vehicleIds {
+		vehicle, err := FindVehicle(ctx, lCtx, vehicleId)
+		if err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+
+	return vehicles, nil
+}
+
+func CreateVehicle(ctx context.Context, lCtx *LuwContext, newVehicle *model.NewVehicle) (*model.Vehicle, error) {
+	const q string = `INSERT INTO Vehicles(ownerEmail, label, seats, fuelTypeCd, consumption, createTmstmp, lstUpdTmstmp, deleteTmstmp)
+						VALUES (?, ?, ?, (select RefCd from RefFuelType where RefName=?), ?, DATETIME('now'), DATETIME('now'), null)`
+
+	if newVehicle.Seats < 1 {
+		return nil, fmt.Errorf("a vehicle needs at least one seat")
+	}
+	if newVehicle.Consumption != nil && *newVehicle.Consumption < 0 {
+		return nil, fmt.Errorf("consumption can't be negative")
+	}
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, newVehicle.EmailOwner, newVehicle.Label, newVehicle.Seats, newVehicle.FuelType, newVehicle.Consumption)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create vehicle %s for %s assign id %d", newVehicle.Label, newVehicle.EmailOwner, id)
+	return FindVehicle(ctx, lCtx, id)
+}
+
+func DeleteVehicle(ctx context.Context, lCtx *LuwContext, vehicle *model.Vehicle) (*model.Vehicle, error) {
+	const q string = `UPDATE Vehicles set lstUpdTmstmp=DATETIME('now'), deleteTmstmp=DATETIME('now')
+				WHERE id=? and deleteTmstmp is null`
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, vehicle.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Delete vehicle id %d", vehicle.ID)
+	return vehicle, nil
+}
+
+// CheckVehicleCapacity rejects rides carrying more people, conductor included, than the vehicle seats
+func CheckVehicleCapacity(vehicle *model.Vehicle, conductorEmail string, participantsEmails []string) error {
+	people := map[string]bool{conductorEmail: true}
+	for _, email := range participantsEmails {
+		people[email] = true
+	}
+
+	if len(people) > vehicle.Seats {
+		return fmt.Errorf("%d people don't fit in %s, it has %d seats", len(people), vehicle.Label, vehicle.Seats)
+	}
+	return nil
+}
+
+// CheckVehicleOwner rejects the vehicles owned by someone else than the conductor and the participants of the ride
+func CheckVehicleOwner(vehicle *model.Vehicle, conductorEmail string, participantsEmails []string) error {
+	if vehicle.Owner.Email == conductorEmail {
+		return nil
+	}
+	for _, email := range participantsEmails {
+		if vehicle.Owner.Email == email {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s isn't owned by someone on the ride", vehicle.Label)
+}
+
+func findRideVehicle(ctx context.Context, lCtx *LuwContext, vehicleId sql.NullInt64) (*model.Vehicle, error) {
+	if !vehicleId.Valid {
+		return nil, nil
+	}
+
+	// The ride keeps its vehicle even once deleted
+	return findVehicle(ctx, lCtx, vehicleId.Int64, true)
+}
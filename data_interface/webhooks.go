@@ -0,0 +1,409 @@
+package data_interface
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"whosdriving-be/graph/model"
+	"whosdriving-be/security"
+)
+
+// Payloads posted to the webhooks, kept apart from the graphQL model so the contract stays stable
+type webhookRotation struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type webhookRide struct {
+	ID           int       `json:"id"`
+	Date         time.Time `json:"date"`
+	Direction    *string   `json:"direction"`
+	Status       string    `json:"status"`
+	Conductor    string    `json:"conductor"`
+	Participants []string  `json:"participants"`
+	VehicleID    *int      `json:"vehicleId"`
+}
+
+type webhookMember struct {
+	Email string `json:"email"`
+}
+
+type webhookPayload struct {
+	Event      model.WebhookEvent `json:"event"`
+	OccurredAt time.Time          `json:"occurredAt"`
+	Rotation   webhookRotation    `json:"rotation"`
+	Ride       *webhookRide       `json:"ride,omitempty"`
+	Member     *webhookMember     `json:"member,omitempty"`
+}
+
+// PendingDelivery is a delivery due to be posted to its webhook
+type PendingDelivery struct {
+	ID       int64
+	Event    model.WebhookEvent
+	URL      string
+	Secret   string
+	Payload  []byte
+	Attempts int
+}
+
+func encodeEvents(events []model.WebhookEvent) (string, error) {
+	if len(events) == 0 {
+		return "", fmt.Errorf("a webhook needs at least one event")
+	}
+
+	names := make([]string, 0, len(events))
+	for _, event := range events {
+		if !event.IsValid() {
+			return "", fmt.Errorf("invalid event %s", event)
+		}
+		names = append(names, event.String())
+	}
+	return strings.Join(names, ","), nil
+}
+
+func decodeEvents(events string) []model.WebhookEvent {
+	names := strings.Split(events, ",")
+	decoded := make([]model.WebhookEvent, 0, len(names))
+	for _, name := range names {
+		decoded = append(decoded, model.WebhookEvent(name))
+	}
+	return decoded
+}
+
+func FindWebhook(ctx context.Context, lCtx *LuwContext, id int64) (*model.Webhook, error) {
+	const q string = `select w.id, w.url, w.events
+						from Webhooks w
+						where w.id = ? and w.deleteTmstmp is null`
+
+	webhook := new(model.Webhook)
+	var events string
+
+	if err := lCtx.Tx.QueryRowContext(ctx, q, &id).Scan(&webhook.ID,
+		&webhook.URL,
+		&events); err != nil {
+		return nil, err
+	}
+	webhook.Events = decodeEvents(events)
+
+	return webhook, nil
+}
+
+// FindWebhookRotation returns the rotation id of a webhook
+func FindWebhookRotation(ctx context.Context, lCtx *LuwContext, id int64) (int64, error) {
+	const q string = `select rotationId from Webhooks where id = ? and deleteTmstmp is null`
+
+	var rotationId int64
+	err := lCtx.Tx.QueryRowContext(ctx, q, id).Scan(&rotationId)
+	return rotationId, err
+}
+
+func FindRotationWebhooks(ctx context.Context, lCtx *LuwContext, rotationId int64) ([]*model.Webhook, error) {
+	const q string = `select w.id
+						from Webhooks w
+						where w.rotationId = ? and w.deleteTmstmp is null
+						order by w.id`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, rotationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhookIds := make([]int64, 0)
+	for rows.Next() {
+		var webhookId int64
+		if err := rows.Scan(&webhookId); err != nil {
+			// Check for a scan error.
+			return nil, err
+		}
+		webhookIds = append(webhookIds, webhookId)
+	}
+	rows.Close()
+
+	webhooks := make([]*model.Webhook, 0, len(webhookIds))
+	for _, webhookId := range webhookIds {
+		webhook, err := FindWebhook(ctx, lCtx, webhookId)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, nil
+}
+
+func CreateWebhook(ctx context.Context, lCtx *LuwContext, newWebhook *model.NewWebhook) (*model.Webhook, error) {
+	const q string = `INSERT INTO Webhooks(rotationId, url, secret, events, createTmstmp, lstUpdTmstmp, deleteTmstmp)
+						VALUES (?, ?, ?, ?, DATETIME('now'), DATETIME('now'), null)`
+
+	target, err := url.Parse(newWebhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %q", newWebhook.URL)
+	}
+	if err := security.CheckPublicHost(target.Hostname()); err != nil {
+		return nil, fmt.Errorf("invalid webhook url %q - %w", newWebhook.URL, err)
+	}
+	if newWebhook.Secret == "" {
+		return nil, fmt.Errorf("a webhook needs a secret")
+	}
+	events, err := encodeEvents(newWebhook.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := FindRotation(ctx, lCtx, int64(newWebhook.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, newWebhook.IDRotation, newWebhook.URL, newWebhook.Secret, events)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Create webhook for rotation %d assign id %d", newWebhook.IDRotation, id)
+	return FindWebhook(ctx, lCtx, id)
+}
+
+// DeleteWebhook removes the subscription, its pending deliveries are not posted anymore
+func DeleteWebhook(ctx context.Context, lCtx *LuwContext, webhook *model.Webhook) (*model.Webhook, error) {
+	const q string = `UPDATE Webhooks set lstUpdTmstmp=DATETIME('now'), deleteTmstmp=DATETIME('now')
+				WHERE id=? and deleteTmstmp is null`
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, webhook.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Delete webhook id %d", webhook.ID)
+	return webhook, nil
+}
+
+// FindWebhookDeliveries lists the deliveries of a webhook, most recent first
+func FindWebhookDeliveries(ctx context.Context, lCtx *LuwContext, webhookId int64, limit *int) ([]*model.WebhookDelivery, error) {
+	const q string = `select d.id, e.RefName, s.RefName, d.attempts, d.responseStatus, d.lastError,
+							d.createTmstmp, d.lastAttemptTmstmp, d.nextAttemptTmstmp
+						from WebhookDeliveries d
+						left join RefWebhookEvent e on d.eventCd = e.RefCd
+						left join RefDeliveryStatus s on d.statusCd = s.RefCd
+						where d.webhookId = ?
+						order by d.id desc
+						limit ?`
+
+	rowLimit := -1
+	if limit != nil {
+		if *limit < 0 {
+			return nil, fmt.Errorf("limit must be positive")
+		}
+		rowLimit = *limit
+	}
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, webhookId, rowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*model.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := new(model.WebhookDelivery)
+		var responseStatus sql.NullInt64
+		var lastError sql.NullString
+		var lastAttempt, nextAttempt sql.NullTime
+
+		if err := rows.Scan(&delivery.ID,
+			&delivery.Event,
+			&delivery.Status,
+			&delivery.Attempts,
+			&responseStatus,
+			&lastError,
+			&delivery.CreatedAt,
+			&lastAttempt,
+			&nextAttempt); err != nil {
+			return nil, err
+		}
+
+		if responseStatus.Valid {
+			status := int(responseStatus.Int64)
+			delivery.ResponseStatus = &status
+		}
+		if lastError.Valid {
+			delivery.LastError = &lastError.String
+		}
+		if lastAttempt.Valid {
+			delivery.LastAttemptAt = &lastAttempt.Time
+		}
+		if nextAttempt.Valid {
+			delivery.NextAttemptAt = &nextAttempt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// enqueueWebhookEvent stores a pending delivery for every webhook of the rotation subscribed to the event,
+// in the transaction of the change so no event is lost nor posted for a rolled back change
+func enqueueWebhookEvent(ctx context.Context, lCtx *LuwContext, rotationId int64, payload *webhookPayload) error {
+	const qWebhooks string = `select w.id
+						from Webhooks w
+						where w.rotationId = ? and w.deleteTmstmp is null
+						and instr(',' || w.events || ',', ',' || ? || ',') > 0
+						order by w.id`
+	const qRotation string = `select name from Rotations where id = ?`
+	const q string = `INSERT INTO WebhookDeliveries(webhookId, eventCd, payload, statusCd, attempts, createTmstmp, nextAttemptTmstmp)
+						VALUES (?, (select RefCd from RefWebhookEvent where RefName=?), ?,
+							(select RefCd from RefDeliveryStatus where RefName='PENDING'), 0, DATETIME('now'), ?)`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, qWebhooks, rotationId, payload.Event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	webhookIds := make([]int64, 0)
+	for rows.Next() {
+		var webhookId int64
+		if err := rows.Scan(&webhookId); err != nil {
+			return err
+		}
+		webhookIds = append(webhookIds, webhookId)
+	}
+	rows.Close()
+
+	if len(webhookIds) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	payload.OccurredAt = now.Truncate(time.Second)
+	payload.Rotation.ID = rotationId
+	if err := lCtx.Tx.QueryRowContext(ctx, qRotation, rotationId).Scan(&payload.Rotation.Name); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := lCtx.Tx.PrepareContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, webhookId := range webhookIds {
+		if _, err := stmt.ExecContext(ctx, webhookId, payload.Event, string(body), now); err != nil {
+			return err
+		}
+		log.Printf("Enqueue %s for webhook %d", payload.Event, webhookId)
+	}
+	return nil
+}
+
+func enqueueRideEvent(ctx context.Context, lCtx *LuwContext, rotationId int64, event model.WebhookEvent, ride *model.Ride) error {
+	rideData := &webhookRide{
+		ID:           ride.ID,
+		Date:         ride.Date,
+		Status:       ride.Status.String(),
+		Conductor:    ride.Conductor.Email,
+		Participants: make([]string, 0, len(ride.Participants)),
+	}
+	if ride.Direction != nil {
+		direction := ride.Direction.String()
+		rideData.Direction = &direction
+	}
+	if ride.Vehicle != nil {
+		rideData.VehicleID = &ride.Vehicle.ID
+	}
+	for _, participant := range ride.Participants {
+		rideData.Participants = append(rideData.Participants, participant.Email)
+	}
+
+	return enqueueWebhookEvent(ctx, lCtx, rotationId, &webhookPayload{Event: event, Ride: rideData})
+}
+
+func enqueueMemberEvent(ctx context.Context, lCtx *LuwContext, rotationId int64, event model.WebhookEvent, email string) error {
+	return enqueueWebhookEvent(ctx, lCtx, rotationId, &webhookPayload{Event: event, Member: &webhookMember{Email: email}})
+}
+
+// FindDueDeliveries lists the pending deliveries whose next attempt is due, oldest first
+func FindDueDeliveries(ctx context.Context, lCtx *LuwContext, now time.Time, limit int) ([]*PendingDelivery, error) {
+	const q string = `select d.id, e.RefName, w.url, w.secret, d.payload, d.attempts
+						from WebhookDeliveries d
+						join Webhooks w on w.id = d.webhookId
+						left join RefWebhookEvent e on d.eventCd = e.RefCd
+						where d.statusCd = (select RefCd from RefDeliveryStatus where RefName='PENDING')
+						and w.deleteTmstmp is null
+						and d.nextAttemptTmstmp <= ?
+						order by d.nextAttemptTmstmp, d.id
+						limit ?`
+
+	rows, err := lCtx.Tx.QueryContext(ctx, q, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*PendingDelivery, 0)
+	for rows.Next() {
+		delivery := new(PendingDelivery)
+		var payload string
+		if err := rows.Scan(&delivery.ID,
+			&delivery.Event,
+			&delivery.URL,
+			&delivery.Secret,
+			&payload,
+			&delivery.Attempts); err != nil {
+			return nil, err
+		}
+		delivery.Payload = []byte(payload)
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RecordDeliveryAttempt logs the outcome of an attempt, nextAttempt is only kept for pending deliveries
+func RecordDeliveryAttempt(ctx context.Context, lCtx *LuwContext, id int64, status model.DeliveryStatus,
+	responseStatus *int, lastError *string, nextAttempt *time.Time) error {
+	const q string = `UPDATE WebhookDeliveries set statusCd=(select RefCd from RefDeliveryStatus where RefName=?),
+					attempts=attempts+1, responseStatus=?, lastError=?, lastAttemptTmstmp=?, nextAttemptTmstmp=?
+				WHERE id=?`
+
+	var next *time.Time
+	if status == model.DeliveryStatusPending && nextAttempt != nil {
+		utc := nextAttempt.UTC()
+		next = &utc
+	}
+
+	_, err := lCtx.Tx.ExecContext(ctx, q, status, responseStatus, lastError, time.Now().UTC(), next, id)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Delivery %d is %s", id, status)
+	return nil
+}
@@ -0,0 +1,198 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"whosdriving-be/graph/generated"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Assumed length of the lists without page size
+const defaultListSize = 20
+
+// Page size of the users search without first, and its maximum, as data_interface.SearchUsers
+const defaultUsersPageSize = 20
+const maxUsersPageSize = 100
+
+// listCost is the cost of a list of size elements
+func listCost(childComplexity int, size int) int {
+	return 1 + size*childComplexity
+}
+
+func list(childComplexity int) int {
+	return listCost(childComplexity, defaultListSize)
+}
+
+// pageSize is the size of a page given or its default, at least 1 so a negative size can't lower the cost
+func pageSize(size *int, defaultSize int) int {
+	if size == nil {
+		return defaultSize
+	}
+	if *size < 1 {
+		return 1
+	}
+	return *size
+}
+
+// NewComplexity weights the lists by their length, by their page size when they have one.
+// The other fields cost 1.
+func NewComplexity() generated.ComplexityRoot {
+	var c generated.ComplexityRoot
+
+	c.Query.Rotations = func(childComplexity int, email *string) int {
+		return list(childComplexity)
+	}
+	// The connection holds a page of users
+	c.Query.Users = func(childComplexity int, search *string, first *int, after *string) int {
+		size := pageSize(first, defaultUsersPageSize)
+		if size > maxUsersPageSize {
+			size = maxUsersPageSize
+		}
+		return listCost(childComplexity, size)
+	}
+	c.Query.APITokens = list
+
+	c.Mutation.PlanRides = func(childComplexity int, idRotation int, until time.Time) int {
+		return list(childComplexity)
+	}
+	c.Mutation.SetRoute = func(childComplexity int, idRotation int, idMeetingPoints []int) int {
+		return listCost(childComplexity, len(idMeetingPoints))
+	}
+
+	c.User.Vehicles = list
+	c.User.SwapRequests = list
+	c.User.Organizations = list
+
+	c.Rotation.Participants = list
+	c.Rotation.Rides = list
+	c.Rotation.Schedules = list
+	c.Rotation.Availability = func(childComplexity int, from time.Time, to time.Time) int {
+		return list(childComplexity)
+	}
+	c.Rotation.Balances = list
+	c.Rotation.Ledger = func(childComplexity int, email *string) int {
+		return list(childComplexity)
+	}
+	c.Rotation.Webhooks = list
+	c.Rotation.PendingInvitations = list
+	c.Rotation.JoinCodes = list
+	c.Rotation.PendingJoinRequests = list
+	c.Rotation.Route = list
+	c.Rotation.Pickups = list
+
+	c.Ride.Participants = list
+	c.Ride.Stops = list
+	c.Stop.Passengers = list
+
+	c.Webhook.Deliveries = func(childComplexity int, limit *int) int {
+		return listCost(childComplexity, pageSize(limit, defaultListSize))
+	}
+
+	c.RotationStats.Buckets = list
+	c.RotationStats.Members = list
+
+	c.Organization.Members = list
+	c.Organization.Rotations = list
+
+	c.RidesImport.Errors = list
+
+	return c
+}
+
+const depthExtension = "DepthLimit"
+
+// DepthLimit refuses the operations whose fields are nested deeper than Max, introspection excepted
+type DepthLimit struct {
+	Max int
+}
+
+var _ interface {
+	graphql.OperationContextMutator
+	graphql.HandlerExtension
+} = &DepthLimit{}
+
+type DepthStats struct {
+	// The depth of the operation, introspection excepted
+	Depth int
+
+	DepthLimit int
+}
+
+func (d DepthLimit) ExtensionName() string {
+	return depthExtension
+}
+
+func (d DepthLimit) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (d DepthLimit) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	depth := selectionDepth(rc.Operation.SelectionSet)
+	rc.Stats.SetExtension(depthExtension, &DepthStats{Depth: depth, DepthLimit: d.Max})
+
+	if depth > d.Max {
+		err := gqlerror.Errorf("operation has depth %d, which exceeds the limit of %d", depth, d.Max)
+		errcode.Set(err, "DEPTH_LIMIT_EXCEEDED")
+		return err
+	}
+	return nil
+}
+
+// selectionDepth is the number of nested fields of the deepest selection, fragments are inlined
+func selectionDepth(selectionSet ast.SelectionSet) int {
+	depth := 0
+	for _, selection := range selectionSet {
+		var nested int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name, "__") {
+				continue
+			}
+			nested = 1 + selectionDepth(selection.SelectionSet)
+		case *ast.InlineFragment:
+			nested = selectionDepth(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			nested = selectionDepth(selection.Definition.SelectionSet)
+		default:
+			panic(fmt.Errorf("unknown selection %T", selection))
+		}
+		if nested > depth {
+			depth = nested
+		}
+	}
+	return depth
+}
+
+func GetDepthStats(ctx context.Context) *DepthStats {
+	rc := graphql.GetOperationContext(ctx)
+	if rc == nil {
+		return nil
+	}
+
+	s, _ := rc.Stats.GetExtension(depthExtension).(*DepthStats)
+	return s
+}
+
+// ReportCost adds the complexity and the depth of the operation, and their limits, to the response extensions
+func ReportCost(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	cost := make(map[string]int)
+	if stats := extension.GetComplexityStats(ctx); stats != nil {
+		cost["complexity"] = stats.Complexity
+		cost["maxComplexity"] = stats.ComplexityLimit
+	}
+	if stats := GetDepthStats(ctx); stats != nil {
+		cost["depth"] = stats.Depth
+		cost["maxDepth"] = stats.DepthLimit
+	}
+	if len(cost) > 0 {
+		graphql.RegisterExtension(ctx, "cost", cost)
+	}
+	return next(ctx)
+}
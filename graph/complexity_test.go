@@ -0,0 +1,72 @@
+package graph
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"whosdriving-be/graph/generated"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+type response struct {
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+	Extensions struct {
+		Cost map[string]int `json:"cost"`
+	} `json:"extensions"`
+}
+
+func TestQueryLimits(t *testing.T) {
+	srv := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: &Resolver{}, Complexity: NewComplexity()}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.FixedComplexityLimit(1000))
+	srv.Use(DepthLimit{Max: 3})
+	srv.AroundResponses(ReportCost)
+
+	post := func(query string) response {
+		body, _ := json.Marshal(map[string]string{"query": query})
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		srv.ServeHTTP(res, req)
+
+		var decoded response
+		json.NewDecoder(res.Body).Decode(&decoded)
+		return decoded
+	}
+
+	body := post("{ __typename }")
+	assert.Empty(t, body.Errors)
+	assert.Equal(t, map[string]int{"complexity": 1, "maxComplexity": 1000, "depth": 0, "maxDepth": 3}, body.Extensions.Cost)
+
+	// Lists are weighted by their page size
+	body = post("query { users(first: 5) { users { email vehicles { label } } } }")
+	assert.Equal(t, 1+5*(1+1+(1+20*1)), body.Extensions.Cost["complexity"])
+	assert.Equal(t, 4, body.Extensions.Cost["depth"])
+	assert.Equal(t, 1, len(body.Errors))
+	assert.Equal(t, "DEPTH_LIMIT_EXCEEDED", body.Errors[0].Extensions["code"])
+
+	// Negative or oversized pages can't lower the cost
+	body = post("query { users(first: -1000) { users { email } } }")
+	assert.Equal(t, 1+1*(1+1), body.Extensions.Cost["complexity"])
+	body = post("query { users(first: 1000) { users { email } } }")
+	assert.Equal(t, 1+100*(1+1), body.Extensions.Cost["complexity"])
+
+	body = post("{ rotations { rides { participants { email } } } }")
+	assert.Equal(t, 1+20*(1+20*(1+20*1)), body.Extensions.Cost["complexity"])
+	assert.Equal(t, 1, len(body.Errors))
+	assert.Equal(t, "COMPLEXITY_LIMIT_EXCEEDED", body.Errors[0].Extensions["code"])
+
+	// Fragments are counted where they are spread, introspection isn't
+	body = post("query { rotations { ...r } __schema { types { fields { type { name } } } } } fragment r on Rotation { rides { id } }")
+	assert.Equal(t, 3, body.Extensions.Cost["depth"])
+}
@@ -6,12 +6,211 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
 )
 
+type APIToken struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	Scopes     []TokenScope `json:"scopes"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt"`
+	ExpiresAt  *time.Time   `json:"expiresAt"`
+}
+
+type Availability struct {
+	ID       int              `json:"id"`
+	User     *User            `json:"user"`
+	Kind     AvailabilityKind `json:"kind"`
+	From     *time.Time       `json:"from"`
+	To       *time.Time       `json:"to"`
+	Days     []Weekday        `json:"days"`
+	Timezone string           `json:"timezone"`
+	Reason   *string          `json:"reason"`
+}
+
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Balance struct {
+	User   *User   `json:"user"`
+	Amount float64 `json:"amount"`
+}
+
+type CostRule struct {
+	Kind      CostRuleKind `json:"kind"`
+	Amount    *float64     `json:"amount"`
+	FuelPrice *float64     `json:"fuelPrice"`
+	Currency  string       `json:"currency"`
+}
+
+type Expense struct {
+	ID     int       `json:"id"`
+	Payer  *User     `json:"payer"`
+	Amount float64   `json:"amount"`
+	Label  string    `json:"label"`
+	Date   time.Time `json:"date"`
+}
+
+type GeneratedAPIToken struct {
+	Token    string    `json:"token"`
+	APIToken *APIToken `json:"apiToken"`
+}
+
+type GeneratedJoinCode struct {
+	Code     string    `json:"code"`
+	JoinCode *JoinCode `json:"joinCode"`
+}
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+type Invitation struct {
+	ID        int              `json:"id"`
+	User      *User            `json:"user"`
+	Status    InvitationStatus `json:"status"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+type JoinCode struct {
+	ID        int       `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	SingleUse bool      `json:"singleUse"`
+	Uses      int       `json:"uses"`
+}
+
+type JoinRequest struct {
+	ID        int               `json:"id"`
+	User      *User             `json:"user"`
+	Status    JoinRequestStatus `json:"status"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+type LedgerEntry struct {
+	ID     int             `json:"id"`
+	User   *User           `json:"user"`
+	Kind   LedgerEntryKind `json:"kind"`
+	Amount float64         `json:"amount"`
+	Date   time.Time       `json:"date"`
+	Label  *string         `json:"label"`
+	IDRide *int            `json:"idRide"`
+}
+
+type MeetingPoint struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Address   *string `json:"address"`
+}
+
+type MemberStats struct {
+	User           *User    `json:"user"`
+	Drives         int      `json:"drives"`
+	PassengerRides int      `json:"passengerRides"`
+	DrivingShare   float64  `json:"drivingShare"`
+	LongestStreak  int      `json:"longestStreak"`
+	CurrentStreak  int      `json:"currentStreak"`
+	Savings        *Savings `json:"savings"`
+}
+
+type NewAPIToken struct {
+	Name      string       `json:"name"`
+	Scopes    []TokenScope `json:"scopes"`
+	ValidDays *int         `json:"validDays"`
+}
+
+type NewAvailability struct {
+	Email    string           `json:"email"`
+	Kind     AvailabilityKind `json:"kind"`
+	From     *time.Time       `json:"from"`
+	To       *time.Time       `json:"to"`
+	Days     []Weekday        `json:"days"`
+	Timezone *string          `json:"timezone"`
+	Reason   *string          `json:"reason"`
+}
+
+type NewCostRule struct {
+	IDRotation int          `json:"idRotation"`
+	Kind       CostRuleKind `json:"kind"`
+	Amount     *float64     `json:"amount"`
+	FuelPrice  *float64     `json:"fuelPrice"`
+	Currency   *string      `json:"currency"`
+}
+
+type NewExpense struct {
+	IDRotation    int        `json:"idRotation"`
+	EmailPayer    string     `json:"emailPayer"`
+	Amount        float64    `json:"amount"`
+	Label         string     `json:"label"`
+	Date          *time.Time `json:"date"`
+	EmailSharedBy []string   `json:"emailSharedBy"`
+}
+
+type NewJoinCode struct {
+	IDRotation int   `json:"idRotation"`
+	ValidDays  *int  `json:"validDays"`
+	SingleUse  *bool `json:"singleUse"`
+}
+
+type NewMeetingPoint struct {
+	IDRotation int     `json:"idRotation"`
+	Name       string  `json:"name"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Address    *string `json:"address"`
+}
+
+type NewNotificationPreferences struct {
+	Email           string `json:"email"`
+	AddedToRotation *bool  `json:"addedToRotation"`
+	DriverReminder  *bool  `json:"driverReminder"`
+	RideCancelled   *bool  `json:"rideCancelled"`
+}
+
+type NewPayment struct {
+	IDRotation int     `json:"idRotation"`
+	EmailFrom  string  `json:"emailFrom"`
+	EmailTo    string  `json:"emailTo"`
+	Amount     float64 `json:"amount"`
+}
+
+type NewProfile struct {
+	FirstName               *string                            `json:"firstName"`
+	LastName                *string                            `json:"lastName"`
+	Profile                 *string                            `json:"profile"`
+	Phone                   *string                            `json:"phone"`
+	NotificationPreferences *NewProfileNotificationPreferences `json:"notificationPreferences"`
+}
+
+type NewProfileNotificationPreferences struct {
+	AddedToRotation *bool `json:"addedToRotation"`
+	DriverReminder  *bool `json:"driverReminder"`
+	RideCancelled   *bool `json:"rideCancelled"`
+}
+
 type NewRide struct {
-	IDRotation        int      `json:"idRotation"`
-	EmailConductor    string   `json:"emailConductor"`
-	EmailParticipants []string `json:"emailParticipants"`
+	IDRotation        int        `json:"idRotation"`
+	Date              *time.Time `json:"date"`
+	Direction         *Direction `json:"direction"`
+	IDVehicle         *int       `json:"idVehicle"`
+	DistanceKm        *float64   `json:"distanceKm"`
+	EmailConductor    string     `json:"emailConductor"`
+	EmailParticipants []string   `json:"emailParticipants"`
+}
+
+type NewRidesImport struct {
+	IDRotation int            `json:"idRotation"`
+	File       graphql.Upload `json:"file"`
+	Format     *ImportFormat  `json:"format"`
+	DryRun     *bool          `json:"dryRun"`
 }
 
 type NewRole struct {
@@ -23,6 +222,23 @@ type NewRotation struct {
 	Name              string   `json:"name"`
 	EmailCreator      string   `json:"emailCreator"`
 	EmailParticipants []string `json:"emailParticipants"`
+	IDOrganization    *int     `json:"idOrganization"`
+}
+
+type NewSchedule struct {
+	IDRotation int        `json:"idRotation"`
+	Days       []Weekday  `json:"days"`
+	Time       string     `json:"time"`
+	Timezone   *string    `json:"timezone"`
+	Direction  Direction  `json:"direction"`
+	StartDate  *time.Time `json:"startDate"`
+	EndDate    *time.Time `json:"endDate"`
+}
+
+type NewSwapRequest struct {
+	IDRide         int    `json:"idRide"`
+	EmailTarget    string `json:"emailTarget"`
+	IDExchangeRide *int   `json:"idExchangeRide"`
 }
 
 type NewUser struct {
@@ -32,67 +248,932 @@ type NewUser struct {
 	Profile   *string `json:"profile"`
 }
 
+type NewVehicle struct {
+	EmailOwner  string   `json:"emailOwner"`
+	Label       string   `json:"label"`
+	Seats       int      `json:"seats"`
+	FuelType    FuelType `json:"fuelType"`
+	Consumption *float64 `json:"consumption"`
+}
+
+type NewWebhook struct {
+	IDRotation int            `json:"idRotation"`
+	URL        string         `json:"url"`
+	Secret     string         `json:"secret"`
+	Events     []WebhookEvent `json:"events"`
+}
+
+type NotificationPreferences struct {
+	AddedToRotation bool `json:"addedToRotation"`
+	DriverReminder  bool `json:"driverReminder"`
+	RideCancelled   bool `json:"rideCancelled"`
+}
+
+type OrgMember struct {
+	User *User   `json:"user"`
+	Role OrgRole `json:"role"`
+}
+
+type Organization struct {
+	ID        int          `json:"id"`
+	Name      string       `json:"name"`
+	Members   []*OrgMember `json:"members"`
+	Rotations []*Rotation  `json:"rotations"`
+}
+
+type Payment struct {
+	ID     int       `json:"id"`
+	From   *User     `json:"from"`
+	To     *User     `json:"to"`
+	Amount float64   `json:"amount"`
+	Date   time.Time `json:"date"`
+}
+
+type Pickup struct {
+	User         *User         `json:"user"`
+	MeetingPoint *MeetingPoint `json:"meetingPoint"`
+}
+
 type Ride struct {
-	ID           int     `json:"id"`
-	Conductor    *User   `json:"conductor"`
-	Participants []*User `json:"participants"`
+	ID           int        `json:"id"`
+	Date         time.Time  `json:"date"`
+	Direction    *Direction `json:"direction"`
+	Status       RideStatus `json:"status"`
+	Vehicle      *Vehicle   `json:"vehicle"`
+	Conductor    *User      `json:"conductor"`
+	Participants []*User    `json:"participants"`
+	DistanceKm   *float64   `json:"distanceKm"`
+	Savings      *Savings   `json:"savings"`
+	Stops        []*Stop    `json:"stops"`
+}
+
+type RidesImport struct {
+	DryRun       bool              `json:"dryRun"`
+	Rows         int               `json:"rows"`
+	Imported     int               `json:"imported"`
+	CreatedUsers []string          `json:"createdUsers"`
+	Errors       []*ImportRowError `json:"errors"`
 }
 
 type Rotation struct {
-	ID           int     `json:"id"`
-	Name         string  `json:"name"`
-	Creator      *User   `json:"creator"`
-	Participants []*User `json:"participants"`
-	Rides        []*Ride `json:"rides"`
+	ID                  int             `json:"id"`
+	Name                string          `json:"name"`
+	Creator             *User           `json:"creator"`
+	Participants        []*User         `json:"participants"`
+	Rides               []*Ride         `json:"rides"`
+	Schedules           []*Schedule     `json:"schedules"`
+	SuggestedDriver     *User           `json:"suggestedDriver"`
+	Availability        []*Availability `json:"availability"`
+	DistanceKm          *float64        `json:"distanceKm"`
+	CostRule            *CostRule       `json:"costRule"`
+	Balances            []*Balance      `json:"balances"`
+	Ledger              []*LedgerEntry  `json:"ledger"`
+	Webhooks            []*Webhook      `json:"webhooks"`
+	PendingInvitations  []*Invitation   `json:"pendingInvitations"`
+	JoinApproval        bool            `json:"joinApproval"`
+	JoinCodes           []*JoinCode     `json:"joinCodes"`
+	PendingJoinRequests []*JoinRequest  `json:"pendingJoinRequests"`
+	Stats               *RotationStats  `json:"stats"`
+	Savings             *Savings        `json:"savings"`
+	Route               []*MeetingPoint `json:"route"`
+	Pickups             []*Pickup       `json:"pickups"`
+	Organization        *Organization   `json:"organization"`
+}
+
+type RotationStats struct {
+	From              time.Time      `json:"from"`
+	To                time.Time      `json:"to"`
+	Rides             int            `json:"rides"`
+	AveragePassengers float64        `json:"averagePassengers"`
+	Savings           *Savings       `json:"savings"`
+	Buckets           []*StatsBucket `json:"buckets"`
+	Members           []*MemberStats `json:"members"`
+}
+
+type Savings struct {
+	KmShared     float64 `json:"kmShared"`
+	Co2AvoidedKg float64 `json:"co2AvoidedKg"`
+}
+
+type Schedule struct {
+	ID        int        `json:"id"`
+	Days      []Weekday  `json:"days"`
+	Time      string     `json:"time"`
+	Timezone  string     `json:"timezone"`
+	Direction Direction  `json:"direction"`
+	StartDate time.Time  `json:"startDate"`
+	EndDate   *time.Time `json:"endDate"`
+}
+
+type StatsBucket struct {
+	Start             time.Time `json:"start"`
+	Rides             int       `json:"rides"`
+	Passengers        int       `json:"passengers"`
+	AveragePassengers float64   `json:"averagePassengers"`
+	Savings           *Savings  `json:"savings"`
+}
+
+type Stop struct {
+	MeetingPoint *MeetingPoint `json:"meetingPoint"`
+	Passengers   []*User       `json:"passengers"`
+}
+
+type SwapRequest struct {
+	ID           int        `json:"id"`
+	Ride         *Ride      `json:"ride"`
+	Requester    *User      `json:"requester"`
+	Target       *User      `json:"target"`
+	ExchangeRide *Ride      `json:"exchangeRide"`
+	Status       SwapStatus `json:"status"`
+	CreatedAt    time.Time  `json:"createdAt"`
 }
 
 type User struct {
-	Email     string  `json:"email"`
-	FirstName *string `json:"firstName"`
-	LastName  *string `json:"lastName"`
-	Profile   *string `json:"profile"`
-	Role      Role    `json:"role"`
+	Email                   string                   `json:"email"`
+	FirstName               *string                  `json:"firstName"`
+	LastName                *string                  `json:"lastName"`
+	Profile                 *string                  `json:"profile"`
+	Phone                   *string                  `json:"phone"`
+	AvatarURL               *string                  `json:"avatarUrl"`
+	AvatarThumbnailURL      *string                  `json:"avatarThumbnailUrl"`
+	Role                    Role                     `json:"role"`
+	Vehicles                []*Vehicle               `json:"vehicles"`
+	NotificationPreferences *NotificationPreferences `json:"notificationPreferences"`
+	SwapRequests            []*SwapRequest           `json:"swapRequests"`
+	Organizations           []*Organization          `json:"organizations"`
 }
 
-type Role string
+type UserConnection struct {
+	Users       []*User `json:"users"`
+	EndCursor   *string `json:"endCursor"`
+	HasNextPage bool    `json:"hasNextPage"`
+}
+
+type Vehicle struct {
+	ID          int      `json:"id"`
+	Owner       *User    `json:"owner"`
+	Label       string   `json:"label"`
+	Seats       int      `json:"seats"`
+	FuelType    FuelType `json:"fuelType"`
+	Consumption *float64 `json:"consumption"`
+}
+
+type Webhook struct {
+	ID         int                `json:"id"`
+	URL        string             `json:"url"`
+	Events     []WebhookEvent     `json:"events"`
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+}
+
+type WebhookDelivery struct {
+	ID             int            `json:"id"`
+	Event          WebhookEvent   `json:"event"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	ResponseStatus *int           `json:"responseStatus"`
+	LastError      *string        `json:"lastError"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	LastAttemptAt  *time.Time     `json:"lastAttemptAt"`
+	NextAttemptAt  *time.Time     `json:"nextAttemptAt"`
+}
+
+type AvailabilityKind string
 
 const (
-	RoleAdmin       Role = "ADMIN"
-	RoleStandard    Role = "STANDARD"
-	RoleUnregistred Role = "UNREGISTRED"
+	AvailabilityKindAbsent      AvailabilityKind = "ABSENT"
+	AvailabilityKindCannotDrive AvailabilityKind = "CANNOT_DRIVE"
 )
 
-var AllRole = []Role{
-	RoleAdmin,
-	RoleStandard,
-	RoleUnregistred,
+var AllAvailabilityKind = []AvailabilityKind{
+	AvailabilityKindAbsent,
+	AvailabilityKindCannotDrive,
 }
 
-func (e Role) IsValid() bool {
+func (e AvailabilityKind) IsValid() bool {
 	switch e {
-	case RoleAdmin, RoleStandard, RoleUnregistred:
+	case AvailabilityKindAbsent, AvailabilityKindCannotDrive:
 		return true
 	}
 	return false
 }
 
-func (e Role) String() string {
+func (e AvailabilityKind) String() string {
 	return string(e)
 }
 
-func (e *Role) UnmarshalGQL(v interface{}) error {
+func (e *AvailabilityKind) UnmarshalGQL(v interface{}) error {
 	str, ok := v.(string)
 	if !ok {
 		return fmt.Errorf("enums must be strings")
 	}
 
-	*e = Role(str)
+	*e = AvailabilityKind(str)
 	if !e.IsValid() {
-		return fmt.Errorf("%s is not a valid Role", str)
+		return fmt.Errorf("%s is not a valid AvailabilityKind", str)
 	}
 	return nil
 }
 
-func (e Role) MarshalGQL(w io.Writer) {
+func (e AvailabilityKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type CostRuleKind string
+
+const (
+	CostRuleKindNone         CostRuleKind = "NONE"
+	CostRuleKindFixedPerRide CostRuleKind = "FIXED_PER_RIDE"
+	CostRuleKindPerKm        CostRuleKind = "PER_KM"
+	CostRuleKindManual       CostRuleKind = "MANUAL"
+)
+
+var AllCostRuleKind = []CostRuleKind{
+	CostRuleKindNone,
+	CostRuleKindFixedPerRide,
+	CostRuleKindPerKm,
+	CostRuleKindManual,
+}
+
+func (e CostRuleKind) IsValid() bool {
+	switch e {
+	case CostRuleKindNone, CostRuleKindFixedPerRide, CostRuleKindPerKm, CostRuleKindManual:
+		return true
+	}
+	return false
+}
+
+func (e CostRuleKind) String() string {
+	return string(e)
+}
+
+func (e *CostRuleKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = CostRuleKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid CostRuleKind", str)
+	}
+	return nil
+}
+
+func (e CostRuleKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "PENDING"
+	DeliveryStatusDelivered DeliveryStatus = "DELIVERED"
+	DeliveryStatusFailed    DeliveryStatus = "FAILED"
+)
+
+var AllDeliveryStatus = []DeliveryStatus{
+	DeliveryStatusPending,
+	DeliveryStatusDelivered,
+	DeliveryStatusFailed,
+}
+
+func (e DeliveryStatus) IsValid() bool {
+	switch e {
+	case DeliveryStatusPending, DeliveryStatusDelivered, DeliveryStatusFailed:
+		return true
+	}
+	return false
+}
+
+func (e DeliveryStatus) String() string {
+	return string(e)
+}
+
+func (e *DeliveryStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = DeliveryStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid DeliveryStatus", str)
+	}
+	return nil
+}
+
+func (e DeliveryStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Direction string
+
+const (
+	DirectionOutbound Direction = "OUTBOUND"
+	DirectionReturn   Direction = "RETURN"
+)
+
+var AllDirection = []Direction{
+	DirectionOutbound,
+	DirectionReturn,
+}
+
+func (e Direction) IsValid() bool {
+	switch e {
+	case DirectionOutbound, DirectionReturn:
+		return true
+	}
+	return false
+}
+
+func (e Direction) String() string {
+	return string(e)
+}
+
+func (e *Direction) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Direction(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Direction", str)
+	}
+	return nil
+}
+
+func (e Direction) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type FuelType string
+
+const (
+	FuelTypePetrol   FuelType = "PETROL"
+	FuelTypeDiesel   FuelType = "DIESEL"
+	FuelTypeHybrid   FuelType = "HYBRID"
+	FuelTypeElectric FuelType = "ELECTRIC"
+	FuelTypeLpg      FuelType = "LPG"
+)
+
+var AllFuelType = []FuelType{
+	FuelTypePetrol,
+	FuelTypeDiesel,
+	FuelTypeHybrid,
+	FuelTypeElectric,
+	FuelTypeLpg,
+}
+
+func (e FuelType) IsValid() bool {
+	switch e {
+	case FuelTypePetrol, FuelTypeDiesel, FuelTypeHybrid, FuelTypeElectric, FuelTypeLpg:
+		return true
+	}
+	return false
+}
+
+func (e FuelType) String() string {
+	return string(e)
+}
+
+func (e *FuelType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = FuelType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid FuelType", str)
+	}
+	return nil
+}
+
+func (e FuelType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Granularity string
+
+const (
+	GranularityWeek  Granularity = "WEEK"
+	GranularityMonth Granularity = "MONTH"
+)
+
+var AllGranularity = []Granularity{
+	GranularityWeek,
+	GranularityMonth,
+}
+
+func (e Granularity) IsValid() bool {
+	switch e {
+	case GranularityWeek, GranularityMonth:
+		return true
+	}
+	return false
+}
+
+func (e Granularity) String() string {
+	return string(e)
+}
+
+func (e *Granularity) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Granularity(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Granularity", str)
+	}
+	return nil
+}
+
+func (e Granularity) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ImportFormat string
+
+const (
+	ImportFormatCSV  ImportFormat = "CSV"
+	ImportFormatJSON ImportFormat = "JSON"
+)
+
+var AllImportFormat = []ImportFormat{
+	ImportFormatCSV,
+	ImportFormatJSON,
+}
+
+func (e ImportFormat) IsValid() bool {
+	switch e {
+	case ImportFormatCSV, ImportFormatJSON:
+		return true
+	}
+	return false
+}
+
+func (e ImportFormat) String() string {
+	return string(e)
+}
+
+func (e *ImportFormat) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ImportFormat(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ImportFormat", str)
+	}
+	return nil
+}
+
+func (e ImportFormat) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type InvitationStatus string
+
+const (
+	InvitationStatusPending  InvitationStatus = "PENDING"
+	InvitationStatusAccepted InvitationStatus = "ACCEPTED"
+	InvitationStatusDeclined InvitationStatus = "DECLINED"
+)
+
+var AllInvitationStatus = []InvitationStatus{
+	InvitationStatusPending,
+	InvitationStatusAccepted,
+	InvitationStatusDeclined,
+}
+
+func (e InvitationStatus) IsValid() bool {
+	switch e {
+	case InvitationStatusPending, InvitationStatusAccepted, InvitationStatusDeclined:
+		return true
+	}
+	return false
+}
+
+func (e InvitationStatus) String() string {
+	return string(e)
+}
+
+func (e *InvitationStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = InvitationStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid InvitationStatus", str)
+	}
+	return nil
+}
+
+func (e InvitationStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type JoinRequestStatus string
+
+const (
+	JoinRequestStatusPending  JoinRequestStatus = "PENDING"
+	JoinRequestStatusApproved JoinRequestStatus = "APPROVED"
+	JoinRequestStatusRejected JoinRequestStatus = "REJECTED"
+)
+
+var AllJoinRequestStatus = []JoinRequestStatus{
+	JoinRequestStatusPending,
+	JoinRequestStatusApproved,
+	JoinRequestStatusRejected,
+}
+
+func (e JoinRequestStatus) IsValid() bool {
+	switch e {
+	case JoinRequestStatusPending, JoinRequestStatusApproved, JoinRequestStatusRejected:
+		return true
+	}
+	return false
+}
+
+func (e JoinRequestStatus) String() string {
+	return string(e)
+}
+
+func (e *JoinRequestStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = JoinRequestStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid JoinRequestStatus", str)
+	}
+	return nil
+}
+
+func (e JoinRequestStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type LedgerEntryKind string
+
+const (
+	LedgerEntryKindRide    LedgerEntryKind = "RIDE"
+	LedgerEntryKindExpense LedgerEntryKind = "EXPENSE"
+	LedgerEntryKindPayment LedgerEntryKind = "PAYMENT"
+)
+
+var AllLedgerEntryKind = []LedgerEntryKind{
+	LedgerEntryKindRide,
+	LedgerEntryKindExpense,
+	LedgerEntryKindPayment,
+}
+
+func (e LedgerEntryKind) IsValid() bool {
+	switch e {
+	case LedgerEntryKindRide, LedgerEntryKindExpense, LedgerEntryKindPayment:
+		return true
+	}
+	return false
+}
+
+func (e LedgerEntryKind) String() string {
+	return string(e)
+}
+
+func (e *LedgerEntryKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = LedgerEntryKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid LedgerEntryKind", str)
+	}
+	return nil
+}
+
+func (e LedgerEntryKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type OrgRole string
+
+const (
+	OrgRoleAdmin  OrgRole = "ADMIN"
+	OrgRoleMember OrgRole = "MEMBER"
+)
+
+var AllOrgRole = []OrgRole{
+	OrgRoleAdmin,
+	OrgRoleMember,
+}
+
+func (e OrgRole) IsValid() bool {
+	switch e {
+	case OrgRoleAdmin, OrgRoleMember:
+		return true
+	}
+	return false
+}
+
+func (e OrgRole) String() string {
+	return string(e)
+}
+
+func (e *OrgRole) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = OrgRole(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid OrgRole", str)
+	}
+	return nil
+}
+
+func (e OrgRole) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type RideStatus string
+
+const (
+	RideStatusPlanned   RideStatus = "PLANNED"
+	RideStatusRecorded  RideStatus = "RECORDED"
+	RideStatusCancelled RideStatus = "CANCELLED"
+)
+
+var AllRideStatus = []RideStatus{
+	RideStatusPlanned,
+	RideStatusRecorded,
+	RideStatusCancelled,
+}
+
+func (e RideStatus) IsValid() bool {
+	switch e {
+	case RideStatusPlanned, RideStatusRecorded, RideStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (e RideStatus) String() string {
+	return string(e)
+}
+
+func (e *RideStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = RideStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid RideStatus", str)
+	}
+	return nil
+}
+
+func (e RideStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Role string
+
+const (
+	RoleAdmin       Role = "ADMIN"
+	RoleStandard    Role = "STANDARD"
+	RoleUnregistred Role = "UNREGISTRED"
+)
+
+var AllRole = []Role{
+	RoleAdmin,
+	RoleStandard,
+	RoleUnregistred,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleAdmin, RoleStandard, RoleUnregistred:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type SwapStatus string
+
+const (
+	SwapStatusPending   SwapStatus = "PENDING"
+	SwapStatusAccepted  SwapStatus = "ACCEPTED"
+	SwapStatusDeclined  SwapStatus = "DECLINED"
+	SwapStatusCancelled SwapStatus = "CANCELLED"
+)
+
+var AllSwapStatus = []SwapStatus{
+	SwapStatusPending,
+	SwapStatusAccepted,
+	SwapStatusDeclined,
+	SwapStatusCancelled,
+}
+
+func (e SwapStatus) IsValid() bool {
+	switch e {
+	case SwapStatusPending, SwapStatusAccepted, SwapStatusDeclined, SwapStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (e SwapStatus) String() string {
+	return string(e)
+}
+
+func (e *SwapStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SwapStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SwapStatus", str)
+	}
+	return nil
+}
+
+func (e SwapStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type TokenScope string
+
+const (
+	TokenScopeReadOnly   TokenScope = "READ_ONLY"
+	TokenScopeRidesWrite TokenScope = "RIDES_WRITE"
+	TokenScopeAdmin      TokenScope = "ADMIN"
+)
+
+var AllTokenScope = []TokenScope{
+	TokenScopeReadOnly,
+	TokenScopeRidesWrite,
+	TokenScopeAdmin,
+}
+
+func (e TokenScope) IsValid() bool {
+	switch e {
+	case TokenScopeReadOnly, TokenScopeRidesWrite, TokenScopeAdmin:
+		return true
+	}
+	return false
+}
+
+func (e TokenScope) String() string {
+	return string(e)
+}
+
+func (e *TokenScope) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TokenScope(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TokenScope", str)
+	}
+	return nil
+}
+
+func (e TokenScope) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type WebhookEvent string
+
+const (
+	WebhookEventRideCreated   WebhookEvent = "RIDE_CREATED"
+	WebhookEventRideUpdated   WebhookEvent = "RIDE_UPDATED"
+	WebhookEventRideCancelled WebhookEvent = "RIDE_CANCELLED"
+	WebhookEventMemberAdded   WebhookEvent = "MEMBER_ADDED"
+	WebhookEventMemberRemoved WebhookEvent = "MEMBER_REMOVED"
+)
+
+var AllWebhookEvent = []WebhookEvent{
+	WebhookEventRideCreated,
+	WebhookEventRideUpdated,
+	WebhookEventRideCancelled,
+	WebhookEventMemberAdded,
+	WebhookEventMemberRemoved,
+}
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookEventRideCreated, WebhookEventRideUpdated, WebhookEventRideCancelled, WebhookEventMemberAdded, WebhookEventMemberRemoved:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEvent) String() string {
+	return string(e)
+}
+
+func (e *WebhookEvent) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEvent(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEvent", str)
+	}
+	return nil
+}
+
+func (e WebhookEvent) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Weekday string
+
+const (
+	WeekdayMonday    Weekday = "MONDAY"
+	WeekdayTuesday   Weekday = "TUESDAY"
+	WeekdayWednesday Weekday = "WEDNESDAY"
+	WeekdayThursday  Weekday = "THURSDAY"
+	WeekdayFriday    Weekday = "FRIDAY"
+	WeekdaySaturday  Weekday = "SATURDAY"
+	WeekdaySunday    Weekday = "SUNDAY"
+)
+
+var AllWeekday = []Weekday{
+	WeekdayMonday,
+	WeekdayTuesday,
+	WeekdayWednesday,
+	WeekdayThursday,
+	WeekdayFriday,
+	WeekdaySaturday,
+	WeekdaySunday,
+}
+
+func (e Weekday) IsValid() bool {
+	switch e {
+	case WeekdayMonday, WeekdayTuesday, WeekdayWednesday, WeekdayThursday, WeekdayFriday, WeekdaySaturday, WeekdaySunday:
+		return true
+	}
+	return false
+}
+
+func (e Weekday) String() string {
+	return string(e)
+}
+
+func (e *Weekday) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Weekday(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Weekday", str)
+	}
+	return nil
+}
+
+func (e Weekday) MarshalGQL(w io.Writer) {
 	fmt.Fprint(w, strconv.Quote(e.String()))
 }
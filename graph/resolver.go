@@ -1,6 +1,17 @@
 package graph
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"whosdriving-be/auth"
+	"whosdriving-be/avatar"
+	"whosdriving-be/backup"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+	"whosdriving-be/notification"
+)
 
 // This file will not be regenerated automatically.
 //
@@ -8,4 +19,216 @@ import "database/sql"
 
 type Resolver struct {
 	DB *sql.DB
+	// How far ahead planned rides are materialised from the schedules
+	PlanHorizon time.Duration
+	Notifier    notification.Notifier
+	// Local storage of the uploaded avatars, uploads are refused without
+	Avatars *avatar.Store
+	// Snapshots of the database, taken on demand of the admins
+	Backups *backup.Store
+}
+
+var errAvatarsDisabled = errors.New("avatar uploads are disabled")
+var errBackupsDisabled = errors.New("backups are disabled")
+
+// setAvatar replaces the avatar of the authenticated user, the files of the replaced one are deleted
+// once committed, those of the new one when the replacement fails
+func (r *Resolver) setAvatar(ctx context.Context, name *string) (*model.User, error) {
+	email, _ := auth.ForContext(ctx)
+	committed := false
+	defer func() {
+		if !committed && name != nil {
+			r.Avatars.Remove(*name)
+		}
+	}()
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	previous, err := data_interface.SetUserAvatar(ctx, &lCtx, email, name)
+	if err != nil {
+		return nil, err
+	}
+	user, err := data_interface.FindUser(ctx, &lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+	if previous != nil {
+		r.Avatars.Remove(*previous)
+	}
+	return user, nil
+}
+
+// notify sends the notifications in background, once the transaction is committed
+func (r *Resolver) notify(notifications []*notification.Notification) {
+	if r.Notifier == nil || len(notifications) == 0 {
+		return
+	}
+	go notification.Send(context.Background(), r.DB, r.Notifier, notifications)
+}
+
+// invite issues the tokens of the new invitations of the rotation, they are emailed once committed
+func (r *Resolver) invite(ctx context.Context, lCtx *data_interface.LuwContext, rotationId int64) ([]*notification.Notification, error) {
+	issued, err := data_interface.IssueInvitationTokens(ctx, lCtx, rotationId)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]*notification.Notification, 0, len(issued))
+	for _, invitation := range issued {
+		notifications = append(notifications, &notification.Notification{
+			Event:        notification.EventInvited,
+			To:           invitation.Invitation.User,
+			RotationName: invitation.RotationName,
+			Token:        invitation.Token,
+		})
+	}
+	return notifications, nil
+}
+
+// requireOwner checks the authenticated user created the rotation or is an admin,
+// of its organization when the rotation has one
+func requireOwner(ctx context.Context, lCtx *data_interface.LuwContext, rotationId int64) error {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return auth.ErrUnauthenticated
+	}
+
+	rotation, err := data_interface.FindRotation(ctx, lCtx, rotationId)
+	if err != nil {
+		return err
+	}
+	if rotation.Creator.Email == email {
+		return nil
+	}
+
+	organization, err := data_interface.FindRotationOrganization(ctx, lCtx, rotationId)
+	if err != nil {
+		return err
+	}
+	if organization != nil {
+		return requireOrgAdmin(ctx, lCtx, int64(organization.ID))
+	}
+
+	user, err := data_interface.FindUser(ctx, lCtx, &email)
+	switch {
+	case err == sql.ErrNoRows:
+		return auth.ErrForbidden
+	case err != nil:
+		return err
+	case user.Role != model.RoleAdmin:
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// requireMember checks the authenticated user participates in the rotation, or owns it
+func requireMember(ctx context.Context, lCtx *data_interface.LuwContext, rotationId int64) error {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return auth.ErrUnauthenticated
+	}
+
+	participant, err := data_interface.IsRotationParticipant(ctx, lCtx, rotationId, email)
+	if err != nil || participant {
+		return err
+	}
+	return requireOwner(ctx, lCtx, rotationId)
+}
+
+// requireSelfOrOwner checks the authenticated user is the given one, or owns the rotation
+func requireSelfOrOwner(ctx context.Context, lCtx *data_interface.LuwContext, rotationId int64, email string) error {
+	authEmail, found := auth.ForContext(ctx)
+	if !found {
+		return auth.ErrUnauthenticated
+	}
+	if authEmail == email {
+		return nil
+	}
+	return requireOwner(ctx, lCtx, rotationId)
+}
+
+// requireSelfOrAnyOwner checks the authenticated user is the given one, or owns one of their rotations
+func requireSelfOrAnyOwner(ctx context.Context, lCtx *data_interface.LuwContext, email string) error {
+	authEmail, found := auth.ForContext(ctx)
+	if !found {
+		return auth.ErrUnauthenticated
+	}
+	if authEmail == email {
+		return nil
+	}
+
+	rotationIds, err := data_interface.FindParticipantRotationIds(ctx, lCtx, email)
+	if err != nil {
+		return err
+	}
+	for _, rotationId := range rotationIds {
+		if err := requireOwner(ctx, lCtx, rotationId); err != auth.ErrForbidden {
+			return err
+		}
+	}
+	return auth.ErrForbidden
+}
+
+// requireAdmin checks the authenticated user has the ADMIN role
+func requireAdmin(ctx context.Context, lCtx *data_interface.LuwContext) error {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return auth.ErrUnauthenticated
+	}
+
+	user, err := data_interface.FindUser(ctx, lCtx, &email)
+	switch {
+	case err == sql.ErrNoRows:
+		return auth.ErrForbidden
+	case err != nil:
+		return err
+	case user.Role != model.RoleAdmin:
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// requireOrgAdmin checks the authenticated user is an admin of the organization, global admins are not
+func requireOrgAdmin(ctx context.Context, lCtx *data_interface.LuwContext, organizationId int64) error {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return auth.ErrUnauthenticated
+	}
+
+	role, err := data_interface.FindOrganizationRole(ctx, lCtx, organizationId, email)
+	switch {
+	case err != nil:
+		return err
+	case role == nil || *role != model.OrgRoleAdmin:
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// requireOrgMember checks the authenticated user is a member of the organization, whatever their role
+func requireOrgMember(ctx context.Context, lCtx *data_interface.LuwContext, organizationId int64) error {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return auth.ErrUnauthenticated
+	}
+
+	role, err := data_interface.FindOrganizationRole(ctx, lCtx, organizationId, email)
+	switch {
+	case err != nil:
+		return err
+	case role == nil:
+		return auth.ErrForbidden
+	}
+	return nil
 }
@@ -7,9 +7,15 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"time"
+	"whosdriving-be/auth"
 	"whosdriving-be/data_interface"
 	"whosdriving-be/graph/generated"
 	"whosdriving-be/graph/model"
+	"whosdriving-be/importer"
+	"whosdriving-be/notification"
+
+	"github.com/99designs/gqlgen/graphql"
 )
 
 // FindOrCreateUser is the resolver for the findOrCreateUser field.
@@ -21,14 +27,9 @@ func (r *mutationResolver) FindOrCreateUser(ctx context.Context, input model.New
 	defer tx.Rollback()
 
 	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
-	user, err := data_interface.FindUser(ctx, &lCtx, &input.Email)
+	user, err := data_interface.FindOrCreateUser(ctx, &lCtx, &input)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			user, err = data_interface.CreateUser(ctx, &lCtx, &input)
-		}
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
 	}
 
 	err = tx.Commit()
@@ -47,6 +48,10 @@ func (r *mutationResolver) ChangeUserRole(ctx context.Context, input model.NewRo
 	defer tx.Rollback()
 
 	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireAdmin(ctx, &lCtx); err != nil {
+		return nil, err
+	}
+
 	user, err := data_interface.FindUser(ctx, &lCtx, &input.Email)
 	if err != nil {
 		return nil, err
@@ -65,6 +70,187 @@ func (r *mutationResolver) ChangeUserRole(ctx context.Context, input model.NewRo
 	return usr, err
 }
 
+// UpdateMyProfile is the resolver for the updateMyProfile field.
+func (r *mutationResolver) UpdateMyProfile(ctx context.Context, input model.NewProfile) (*model.User, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	user, err := data_interface.UpdateProfile(ctx, &lCtx, email, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UploadAvatar is the resolver for the uploadAvatar field.
+func (r *mutationResolver) UploadAvatar(ctx context.Context, file graphql.Upload) (*model.User, error) {
+	if _, found := auth.ForContext(ctx); !found {
+		return nil, auth.ErrUnauthenticated
+	}
+	if r.Avatars == nil {
+		return nil, errAvatarsDisabled
+	}
+
+	name, err := r.Avatars.Save(file.File)
+	if err != nil {
+		return nil, err
+	}
+	return r.setAvatar(ctx, &name)
+}
+
+// RemoveAvatar is the resolver for the removeAvatar field.
+func (r *mutationResolver) RemoveAvatar(ctx context.Context) (*model.User, error) {
+	if _, found := auth.ForContext(ctx); !found {
+		return nil, auth.ErrUnauthenticated
+	}
+	if r.Avatars == nil {
+		return nil, errAvatarsDisabled
+	}
+	return r.setAvatar(ctx, nil)
+}
+
+// BackupDatabase is the resolver for the backupDatabase field.
+func (r *mutationResolver) BackupDatabase(ctx context.Context) (*model.Backup, error) {
+	if r.Backups == nil {
+		return nil, errBackupsDisabled
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireAdmin(ctx, &lCtx); err != nil {
+		return nil, err
+	}
+	// The snapshot is taken out of the transaction
+	tx.Rollback()
+
+	return r.Backups.Create(ctx, r.DB, time.Now())
+}
+
+// ImportRides is the resolver for the importRides field.
+func (r *mutationResolver) ImportRides(ctx context.Context, input model.NewRidesImport) (*model.RidesImport, error) {
+	format := input.Format
+	if format == nil {
+		fromName, err := importer.FormatOf(input.File.Filename)
+		if err != nil {
+			return nil, err
+		}
+		format = &fromName
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	report, err := importer.Import(ctx, &lCtx, int64(input.IDRotation), *format, input.File.File)
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = input.DryRun != nil && *input.DryRun
+	if report.DryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CreateAPIToken is the resolver for the createApiToken field.
+func (r *mutationResolver) CreateAPIToken(ctx context.Context, input model.NewAPIToken) (*model.GeneratedAPIToken, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	apiToken, err := data_interface.CreateAPIToken(ctx, &lCtx, email, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return apiToken, nil
+}
+
+// RevokeAPIToken is the resolver for the revokeApiToken field.
+func (r *mutationResolver) RevokeAPIToken(ctx context.Context, id int) (*model.APIToken, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	owner, err := data_interface.FindAPITokenOwner(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	if owner != email {
+		return nil, auth.ErrForbidden
+	}
+
+	apiToken, err := data_interface.FindAPIToken(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	apiToken, err = data_interface.RevokeAPIToken(ctx, &lCtx, apiToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return apiToken, nil
+}
+
 // AddRotation is the resolver for the addRotation field.
 func (r *mutationResolver) AddRotation(ctx context.Context, input model.NewRotation) (*model.Rotation, error) {
 	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
@@ -74,6 +260,14 @@ func (r *mutationResolver) AddRotation(ctx context.Context, input model.NewRotat
 	defer tx.Rollback()
 
 	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	// The rotations of an organization are created by its members for themselves
+	if input.IDOrganization != nil {
+		if err := requireOrgMember(ctx, &lCtx, int64(*input.IDOrganization)); err != nil {
+			return nil, err
+		}
+		input.EmailCreator, _ = auth.ForContext(ctx)
+	}
+
 	rotation, err := data_interface.CreateRotation(ctx, &lCtx, &input)
 	if err != nil {
 		return nil, err
@@ -81,10 +275,27 @@ func (r *mutationResolver) AddRotation(ctx context.Context, input model.NewRotat
 
 	log.Printf("Create successfuly new rotation %T", rotation)
 
+	notifications, err := r.invite(ctx, &lCtx, int64(rotation.ID))
+	if err != nil {
+		return nil, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
 	}
+
+	for _, participant := range rotation.Participants {
+		if participant.Email != rotation.Creator.Email {
+			notifications = append(notifications, &notification.Notification{
+				Event:        notification.EventAddedToRotation,
+				To:           participant,
+				RotationName: rotation.Name,
+			})
+		}
+	}
+	r.notify(notifications)
+
 	return rotation, nil
 }
 
@@ -97,6 +308,10 @@ func (r *mutationResolver) AddRide(ctx context.Context, input model.NewRide) (*m
 	defer tx.Rollback()
 
 	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
 	ride, err := data_interface.AddRide(ctx, &lCtx, &input)
 	if err != nil {
 		return nil, err
@@ -111,19 +326,25 @@ func (r *mutationResolver) AddRide(ctx context.Context, input model.NewRide) (*m
 	return ride, nil
 }
 
-// User is the resolver for the user field.
-func (r *queryResolver) User(ctx context.Context, email string) (*model.User, error) {
-	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
-		Isolation: sql.LevelReadCommitted,
-		ReadOnly:  true,
-	})
+// AddSchedule is the resolver for the addSchedule field.
+func (r *mutationResolver) AddSchedule(ctx context.Context, input model.NewSchedule) (*model.Schedule, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
 	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
-	user, err := data_interface.FindUser(ctx, &lCtx, &email)
+	if err := requireOwner(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	schedule, err := data_interface.CreateSchedule(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = data_interface.PlanRides(ctx, &lCtx, int64(input.IDRotation), time.Now().Add(r.PlanHorizon))
 	if err != nil {
 		return nil, err
 	}
@@ -132,22 +353,33 @@ func (r *queryResolver) User(ctx context.Context, email string) (*model.User, er
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	return schedule, nil
 }
 
-// Rotations is the resolver for the rotations field.
-func (r *queryResolver) Rotations(ctx context.Context, email *string) ([]*model.Rotation, error) {
-	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
-		Isolation: sql.LevelReadCommitted,
-		ReadOnly:  true,
-	})
+// RemoveSchedule is the resolver for the removeSchedule field.
+func (r *mutationResolver) RemoveSchedule(ctx context.Context, id int) (*model.Schedule, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
 	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
-	rotations, err := data_interface.FindRotations(ctx, &lCtx, email)
+	rotationId, err := data_interface.FindScheduleRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	schedule, err := data_interface.FindSchedule(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err = data_interface.DeleteSchedule(ctx, &lCtx, schedule)
 	if err != nil {
 		return nil, err
 	}
@@ -156,14 +388,2003 @@ func (r *queryResolver) Rotations(ctx context.Context, email *string) ([]*model.
 	if err != nil {
 		return nil, err
 	}
-	return rotations, nil
+	return schedule, nil
 }
 
-// Mutation returns generated.MutationResolver implementation.
-func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
+// PlanRides is the resolver for the planRides field.
+func (r *mutationResolver) PlanRides(ctx context.Context, idRotation int, until time.Time) ([]*model.Ride, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-// Query returns generated.QueryResolver implementation.
-func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(idRotation)); err != nil {
+		return nil, err
+	}
+
+	rides, err := data_interface.PlanRides(ctx, &lCtx, int64(idRotation), until)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Planned %d rides for rotation %d", len(rides), idRotation)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return rides, nil
+}
+
+// ConfirmRide is the resolver for the confirmRide field.
+func (r *mutationResolver) ConfirmRide(ctx context.Context, id int) (*model.Ride, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, _, err := data_interface.FindRideRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireMember(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	ride, err := data_interface.FindRide(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	ride, err = data_interface.ChangeRideStatus(ctx, &lCtx, ride, model.RideStatusRecorded)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return ride, nil
+}
+
+// SwapRide is the resolver for the swapRide field.
+func (r *mutationResolver) SwapRide(ctx context.Context, id int, emailConductor string) (*model.Ride, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, _, err := data_interface.FindRideRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	// The members swap their rides with swap requests
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	ride, err := data_interface.FindRide(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	ride, err = data_interface.HandOverRide(ctx, &lCtx, ride, emailConductor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return ride, nil
+}
+
+// CancelRide is the resolver for the cancelRide field.
+func (r *mutationResolver) CancelRide(ctx context.Context, id int) (*model.Ride, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, rotationName, err := data_interface.FindRideRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireMember(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	ride, err := data_interface.FindRide(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	ride, err = data_interface.ChangeRideStatus(ctx, &lCtx, ride, model.RideStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	timezone, err := data_interface.FindRideTimezone(ctx, &lCtx, int64(ride.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []*notification.Notification{{
+		Event:        notification.EventRideCancelled,
+		To:           ride.Conductor,
+		RotationName: rotationName,
+		Ride:         ride,
+		Timezone:     timezone,
+	}}
+	for _, participant := range ride.Participants {
+		if participant.Email != ride.Conductor.Email {
+			notifications = append(notifications, &notification.Notification{
+				Event:        notification.EventRideCancelled,
+				To:           participant,
+				RotationName: rotationName,
+				Ride:         ride,
+				Timezone:     timezone,
+			})
+		}
+	}
+	r.notify(notifications)
+
+	return ride, nil
+}
+
+// AddAvailability is the resolver for the addAvailability field.
+func (r *mutationResolver) AddAvailability(ctx context.Context, input model.NewAvailability) (*model.Availability, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireSelfOrAnyOwner(ctx, &lCtx, input.Email); err != nil {
+		return nil, err
+	}
+
+	availability, err := data_interface.CreateAvailability(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return availability, nil
+}
+
+// RemoveAvailability is the resolver for the removeAvailability field.
+func (r *mutationResolver) RemoveAvailability(ctx context.Context, id int) (*model.Availability, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	availability, err := data_interface.FindAvailability(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireSelfOrAnyOwner(ctx, &lCtx, availability.User.Email); err != nil {
+		return nil, err
+	}
+
+	availability, err = data_interface.DeleteAvailability(ctx, &lCtx, availability)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return availability, nil
+}
+
+// AddVehicle is the resolver for the addVehicle field.
+func (r *mutationResolver) AddVehicle(ctx context.Context, input model.NewVehicle) (*model.Vehicle, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+	// The vehicles are registered by their owner
+	input.EmailOwner = email
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	vehicle, err := data_interface.CreateVehicle(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return vehicle, nil
+}
+
+// RemoveVehicle is the resolver for the removeVehicle field.
+func (r *mutationResolver) RemoveVehicle(ctx context.Context, id int) (*model.Vehicle, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	vehicle, err := data_interface.FindVehicle(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.Owner.Email != email {
+		return nil, auth.ErrForbidden
+	}
+
+	vehicle, err = data_interface.DeleteVehicle(ctx, &lCtx, vehicle)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return vehicle, nil
+}
+
+// SetRotationDistance is the resolver for the setRotationDistance field.
+func (r *mutationResolver) SetRotationDistance(ctx context.Context, idRotation int, distanceKm *float64) (*model.Rotation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(idRotation)); err != nil {
+		return nil, err
+	}
+
+	rotation, err := data_interface.SetRotationDistance(ctx, &lCtx, int64(idRotation), distanceKm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// SetCostRule is the resolver for the setCostRule field.
+func (r *mutationResolver) SetCostRule(ctx context.Context, input model.NewCostRule) (*model.CostRule, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	costRule, err := data_interface.SetCostRule(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return costRule, nil
+}
+
+// AddExpense is the resolver for the addExpense field.
+func (r *mutationResolver) AddExpense(ctx context.Context, input model.NewExpense) (*model.Expense, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	expense, err := data_interface.AddExpense(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return expense, nil
+}
+
+// SettleUp is the resolver for the settleUp field.
+func (r *mutationResolver) SettleUp(ctx context.Context, input model.NewPayment) (*model.Payment, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireSelfOrOwner(ctx, &lCtx, int64(input.IDRotation), input.EmailTo); err != nil {
+		return nil, err
+	}
+
+	payment, err := data_interface.SettleUp(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// GenerateCalendarToken is the resolver for the generateCalendarToken field.
+func (r *mutationResolver) GenerateCalendarToken(ctx context.Context, email string) (string, error) {
+	authEmail, found := auth.ForContext(ctx)
+	if !found {
+		return "", auth.ErrUnauthenticated
+	}
+	if email != authEmail {
+		return "", auth.ErrForbidden
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	token, err := data_interface.GenerateCalendarToken(ctx, &lCtx, email)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// SetNotificationPreferences is the resolver for the setNotificationPreferences field.
+func (r *mutationResolver) SetNotificationPreferences(ctx context.Context, input model.NewNotificationPreferences) (*model.NotificationPreferences, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+	// The users only set their own preferences
+	input.Email = email
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	preferences, err := data_interface.UpdateNotificationPreferences(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// AddParticipants is the resolver for the addParticipants field.
+func (r *mutationResolver) AddParticipants(ctx context.Context, idRotation int, emails []string) (*model.Rotation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(idRotation)); err != nil {
+		return nil, err
+	}
+
+	rotation, err := data_interface.FindRotation(ctx, &lCtx, int64(idRotation))
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]bool, len(rotation.Participants))
+	for _, participant := range rotation.Participants {
+		members[participant.Email] = true
+	}
+	newEmails := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if !members[email] {
+			members[email] = true
+			newEmails = append(newEmails, email)
+		}
+	}
+
+	if err := data_interface.CreateRotationParticipants(ctx, &lCtx, int64(idRotation), &newEmails); err != nil {
+		return nil, err
+	}
+
+	rotation, err = data_interface.FindRotation(ctx, &lCtx, int64(idRotation))
+	if err != nil {
+		return nil, err
+	}
+
+	notifications, err := r.invite(ctx, &lCtx, int64(idRotation))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, participant := range rotation.Participants {
+		for _, email := range newEmails {
+			if participant.Email == email {
+				notifications = append(notifications, &notification.Notification{
+					Event:        notification.EventAddedToRotation,
+					To:           participant,
+					RotationName: rotation.Name,
+				})
+			}
+		}
+	}
+	r.notify(notifications)
+
+	return rotation, nil
+}
+
+// RemoveParticipants is the resolver for the removeParticipants field.
+func (r *mutationResolver) RemoveParticipants(ctx context.Context, idRotation int, emails []string) (*model.Rotation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if _, err := data_interface.FindRotation(ctx, &lCtx, int64(idRotation)); err != nil {
+		return nil, err
+	}
+
+	// Participants may leave on their own, the owner removes anyone
+	if email, found := auth.ForContext(ctx); !found || len(emails) != 1 || emails[0] != email {
+		if err := requireOwner(ctx, &lCtx, int64(idRotation)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := data_interface.RemoveRotationParticipants(ctx, &lCtx, int64(idRotation), &emails); err != nil {
+		return nil, err
+	}
+
+	rotation, err := data_interface.FindRotation(ctx, &lCtx, int64(idRotation))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// AddWebhook is the resolver for the addWebhook field.
+func (r *mutationResolver) AddWebhook(ctx context.Context, input model.NewWebhook) (*model.Webhook, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	webhook, err := data_interface.CreateWebhook(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// RemoveWebhook is the resolver for the removeWebhook field.
+func (r *mutationResolver) RemoveWebhook(ctx context.Context, id int) (*model.Webhook, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, err := data_interface.FindWebhookRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	webhook, err := data_interface.FindWebhook(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err = data_interface.DeleteWebhook(ctx, &lCtx, webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// AcceptInvitation is the resolver for the acceptInvitation field.
+func (r *mutationResolver) AcceptInvitation(ctx context.Context, token string, firstName *string, lastName *string) (*model.Rotation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotation, err := data_interface.AcceptInvitation(ctx, &lCtx, token, firstName, lastName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// DeclineInvitation is the resolver for the declineInvitation field.
+func (r *mutationResolver) DeclineInvitation(ctx context.Context, token string) (*model.Invitation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	invitation, err := data_interface.DeclineInvitation(ctx, &lCtx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// GenerateJoinCode is the resolver for the generateJoinCode field.
+func (r *mutationResolver) GenerateJoinCode(ctx context.Context, input model.NewJoinCode) (*model.GeneratedJoinCode, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	generated, err := data_interface.CreateJoinCode(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return generated, nil
+}
+
+// RevokeJoinCode is the resolver for the revokeJoinCode field.
+func (r *mutationResolver) RevokeJoinCode(ctx context.Context, id int) (*model.JoinCode, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, err := data_interface.FindJoinCodeRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	joinCode, err := data_interface.FindJoinCode(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	joinCode, err = data_interface.RevokeJoinCode(ctx, &lCtx, joinCode)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return joinCode, nil
+}
+
+// SetJoinApproval is the resolver for the setJoinApproval field.
+func (r *mutationResolver) SetJoinApproval(ctx context.Context, idRotation int, required bool) (*model.Rotation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(idRotation)); err != nil {
+		return nil, err
+	}
+
+	rotation, err := data_interface.SetJoinApproval(ctx, &lCtx, int64(idRotation), required)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// ApproveJoinRequest is the resolver for the approveJoinRequest field.
+func (r *mutationResolver) ApproveJoinRequest(ctx context.Context, id int) (*model.JoinRequest, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, err := data_interface.FindJoinRequestRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	joinRequest, err := data_interface.DecideJoinRequest(ctx, &lCtx, int64(id), model.JoinRequestStatusApproved)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return joinRequest, nil
+}
+
+// RejectJoinRequest is the resolver for the rejectJoinRequest field.
+func (r *mutationResolver) RejectJoinRequest(ctx context.Context, id int) (*model.JoinRequest, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, err := data_interface.FindJoinRequestRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	joinRequest, err := data_interface.DecideJoinRequest(ctx, &lCtx, int64(id), model.JoinRequestStatusRejected)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return joinRequest, nil
+}
+
+// JoinRotation is the resolver for the joinRotation field.
+func (r *mutationResolver) JoinRotation(ctx context.Context, code string) (*model.JoinRequest, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	joinRequest, err := data_interface.JoinRotation(ctx, &lCtx, code, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return joinRequest, nil
+}
+
+// RequestSwap is the resolver for the requestSwap field.
+func (r *mutationResolver) RequestSwap(ctx context.Context, input model.NewSwapRequest) (*model.SwapRequest, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	swap, err := data_interface.CreateSwapRequest(ctx, &lCtx, email, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return swap, nil
+}
+
+// AcceptSwap is the resolver for the acceptSwap field.
+func (r *mutationResolver) AcceptSwap(ctx context.Context, id int) (*model.SwapRequest, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	swap, err := data_interface.FindSwapRequest(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	if swap.Target.Email != email {
+		return nil, auth.ErrForbidden
+	}
+
+	swap, err = data_interface.AcceptSwapRequest(ctx, &lCtx, swap)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return swap, nil
+}
+
+// DeclineSwap is the resolver for the declineSwap field.
+func (r *mutationResolver) DeclineSwap(ctx context.Context, id int) (*model.SwapRequest, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	swap, err := data_interface.FindSwapRequest(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	if swap.Target.Email != email {
+		return nil, auth.ErrForbidden
+	}
+
+	swap, err = data_interface.DeclineSwapRequest(ctx, &lCtx, swap)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return swap, nil
+}
+
+// CancelSwap is the resolver for the cancelSwap field.
+func (r *mutationResolver) CancelSwap(ctx context.Context, id int) (*model.SwapRequest, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	swap, err := data_interface.FindSwapRequest(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	if swap.Requester.Email != email {
+		return nil, auth.ErrForbidden
+	}
+
+	swap, err = data_interface.CancelSwapRequest(ctx, &lCtx, swap)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return swap, nil
+}
+
+// AddMeetingPoint is the resolver for the addMeetingPoint field.
+func (r *mutationResolver) AddMeetingPoint(ctx context.Context, input model.NewMeetingPoint) (*model.MeetingPoint, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(input.IDRotation)); err != nil {
+		return nil, err
+	}
+
+	meetingPoint, err := data_interface.CreateMeetingPoint(ctx, &lCtx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return meetingPoint, nil
+}
+
+// RemoveMeetingPoint is the resolver for the removeMeetingPoint field.
+func (r *mutationResolver) RemoveMeetingPoint(ctx context.Context, id int) (*model.MeetingPoint, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, err := data_interface.FindMeetingPointRotation(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	meetingPoint, err := data_interface.FindMeetingPoint(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	meetingPoint, err = data_interface.DeleteMeetingPoint(ctx, &lCtx, meetingPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return meetingPoint, nil
+}
+
+// SetRoute is the resolver for the setRoute field.
+func (r *mutationResolver) SetRoute(ctx context.Context, idRotation int, idMeetingPoints []int) ([]*model.MeetingPoint, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(idRotation)); err != nil {
+		return nil, err
+	}
+
+	route, err := data_interface.SetRoute(ctx, &lCtx, int64(idRotation), idMeetingPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// SetPickup is the resolver for the setPickup field.
+func (r *mutationResolver) SetPickup(ctx context.Context, idRotation int, email string, idMeetingPoint *int) (*model.Pickup, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireSelfOrOwner(ctx, &lCtx, int64(idRotation), email); err != nil {
+		return nil, err
+	}
+
+	pickup, err := data_interface.SetPickup(ctx, &lCtx, int64(idRotation), email, idMeetingPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return pickup, nil
+}
+
+// SetRidePickup is the resolver for the setRidePickup field.
+func (r *mutationResolver) SetRidePickup(ctx context.Context, idRide int, email string, idMeetingPoint *int) (*model.Ride, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, _, err := data_interface.FindRideRotation(ctx, &lCtx, int64(idRide))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireSelfOrOwner(ctx, &lCtx, rotationId, email); err != nil {
+		return nil, err
+	}
+
+	ride, err := data_interface.FindRide(ctx, &lCtx, int64(idRide))
+	if err != nil {
+		return nil, err
+	}
+
+	ride, err = data_interface.SetRidePickup(ctx, &lCtx, ride, email, idMeetingPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return ride, nil
+}
+
+// AddOrganization is the resolver for the addOrganization field.
+func (r *mutationResolver) AddOrganization(ctx context.Context, name string) (*model.Organization, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	organization, err := data_interface.CreateOrganization(ctx, &lCtx, name, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// AddOrganizationMembers is the resolver for the addOrganizationMembers field.
+func (r *mutationResolver) AddOrganizationMembers(ctx context.Context, idOrganization int, emails []string) (*model.Organization, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOrgAdmin(ctx, &lCtx, int64(idOrganization)); err != nil {
+		return nil, err
+	}
+
+	if err := data_interface.AddOrganizationMembers(ctx, &lCtx, int64(idOrganization), emails); err != nil {
+		return nil, err
+	}
+
+	organization, err := data_interface.FindOrganization(ctx, &lCtx, int64(idOrganization))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// RemoveOrganizationMembers is the resolver for the removeOrganizationMembers field.
+func (r *mutationResolver) RemoveOrganizationMembers(ctx context.Context, idOrganization int, emails []string) (*model.Organization, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOrgAdmin(ctx, &lCtx, int64(idOrganization)); err != nil {
+		return nil, err
+	}
+
+	if err := data_interface.RemoveOrganizationMembers(ctx, &lCtx, int64(idOrganization), emails); err != nil {
+		return nil, err
+	}
+
+	organization, err := data_interface.FindOrganization(ctx, &lCtx, int64(idOrganization))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// SetOrganizationRole is the resolver for the setOrganizationRole field.
+func (r *mutationResolver) SetOrganizationRole(ctx context.Context, idOrganization int, email string, role model.OrgRole) (*model.OrgMember, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOrgAdmin(ctx, &lCtx, int64(idOrganization)); err != nil {
+		return nil, err
+	}
+
+	member, err := data_interface.SetOrganizationRole(ctx, &lCtx, int64(idOrganization), email, role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// Members is the resolver for the members field.
+func (r *organizationResolver) Members(ctx context.Context, obj *model.Organization) ([]*model.OrgMember, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOrgMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	members, err := data_interface.FindOrganizationMembers(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// Rotations is the resolver for the rotations field.
+func (r *organizationResolver) Rotations(ctx context.Context, obj *model.Organization) ([]*model.Rotation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOrgMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	rotations, err := data_interface.FindOrganizationRotations(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return rotations, nil
+}
+
+// User is the resolver for the user field.
+func (r *queryResolver) User(ctx context.Context, email string) (*model.User, error) {
+	viewerEmail, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	visible, err := data_interface.IsUserVisible(ctx, &lCtx, viewerEmail, email)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, sql.ErrNoRows
+	}
+
+	user, err := data_interface.FindUser(ctx, &lCtx, &email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Rotations is the resolver for the rotations field.
+func (r *queryResolver) Rotations(ctx context.Context, email *string) ([]*model.Rotation, error) {
+	viewerEmail, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotations, err := data_interface.FindVisibleRotations(ctx, &lCtx, viewerEmail, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return rotations, nil
+}
+
+// Organization is the resolver for the organization field.
+func (r *queryResolver) Organization(ctx context.Context, id int) (*model.Organization, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	// Only the members see their organization
+	if err := requireOrgMember(ctx, &lCtx, int64(id)); err != nil {
+		return nil, err
+	}
+
+	organization, err := data_interface.FindOrganization(ctx, &lCtx, int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, search *string, first *int, after *string) (*model.UserConnection, error) {
+	viewerEmail, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	connection, err := data_interface.SearchUsers(ctx, &lCtx, viewerEmail, search, first, after)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return connection, nil
+}
+
+// APITokens is the resolver for the apiTokens field.
+func (r *queryResolver) APITokens(ctx context.Context) ([]*model.APIToken, error) {
+	email, found := auth.ForContext(ctx)
+	if !found {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	apiTokens, err := data_interface.FindAPITokens(ctx, &lCtx, email, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return apiTokens, nil
+}
+
+// Savings is the resolver for the savings field.
+func (r *rideResolver) Savings(ctx context.Context, obj *model.Ride) (*model.Savings, error) {
+	if obj.DistanceKm == nil {
+		return nil, nil
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	savings, err := data_interface.FindRideSavings(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return savings, nil
+}
+
+// Stops is the resolver for the stops field.
+func (r *rideResolver) Stops(ctx context.Context, obj *model.Ride) ([]*model.Stop, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	stops, err := data_interface.FindRideStops(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
+// SuggestedDriver is the resolver for the suggestedDriver field.
+func (r *rotationResolver) SuggestedDriver(ctx context.Context, obj *model.Rotation, date *time.Time) (*model.User, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	at := time.Now()
+	if date != nil {
+		at = *date
+	}
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	driver, err := data_interface.SuggestDriver(ctx, &lCtx, int64(obj.ID), at)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return driver, nil
+}
+
+// Availability is the resolver for the availability field.
+func (r *rotationResolver) Availability(ctx context.Context, obj *model.Rotation, from time.Time, to time.Time) ([]*model.Availability, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	availabilities, err := data_interface.FindRotationAvailabilities(ctx, &lCtx, int64(obj.ID), from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return availabilities, nil
+}
+
+// CostRule is the resolver for the costRule field.
+func (r *rotationResolver) CostRule(ctx context.Context, obj *model.Rotation) (*model.CostRule, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	costRule, err := data_interface.FindCostRule(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return costRule, nil
+}
+
+// Balances is the resolver for the balances field.
+func (r *rotationResolver) Balances(ctx context.Context, obj *model.Rotation) ([]*model.Balance, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	balances, err := data_interface.FindBalances(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// Ledger is the resolver for the ledger field.
+func (r *rotationResolver) Ledger(ctx context.Context, obj *model.Rotation, email *string) ([]*model.LedgerEntry, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	ledger, err := data_interface.FindLedger(ctx, &lCtx, int64(obj.ID), email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+// Webhooks is the resolver for the webhooks field.
+func (r *rotationResolver) Webhooks(ctx context.Context, obj *model.Rotation) ([]*model.Webhook, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	webhooks, err := data_interface.FindRotationWebhooks(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// PendingInvitations is the resolver for the pendingInvitations field.
+func (r *rotationResolver) PendingInvitations(ctx context.Context, obj *model.Rotation) ([]*model.Invitation, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	invitations, err := data_interface.FindPendingInvitations(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// JoinCodes is the resolver for the joinCodes field.
+func (r *rotationResolver) JoinCodes(ctx context.Context, obj *model.Rotation) ([]*model.JoinCode, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	joinCodes, err := data_interface.FindJoinCodes(ctx, &lCtx, int64(obj.ID), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return joinCodes, nil
+}
+
+// PendingJoinRequests is the resolver for the pendingJoinRequests field.
+func (r *rotationResolver) PendingJoinRequests(ctx context.Context, obj *model.Rotation) ([]*model.JoinRequest, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireOwner(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	joinRequests, err := data_interface.FindPendingJoinRequests(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return joinRequests, nil
+}
+
+// Stats is the resolver for the stats field.
+func (r *rotationResolver) Stats(ctx context.Context, obj *model.Rotation, from time.Time, to time.Time, granularity *model.Granularity) (*model.RotationStats, error) {
+	if granularity == nil {
+		month := model.GranularityMonth
+		granularity = &month
+	}
+
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	stats, err := data_interface.FindRotationStats(ctx, &lCtx, int64(obj.ID), from, to, *granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Savings is the resolver for the savings field.
+func (r *rotationResolver) Savings(ctx context.Context, obj *model.Rotation) (*model.Savings, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	savings, err := data_interface.FindRotationSavings(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return savings, nil
+}
+
+// Route is the resolver for the route field.
+func (r *rotationResolver) Route(ctx context.Context, obj *model.Rotation) ([]*model.MeetingPoint, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	route, err := data_interface.FindRoute(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// Pickups is the resolver for the pickups field.
+func (r *rotationResolver) Pickups(ctx context.Context, obj *model.Rotation) ([]*model.Pickup, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	if err := requireMember(ctx, &lCtx, int64(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	pickups, err := data_interface.FindPickups(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return pickups, nil
+}
+
+// Organization is the resolver for the organization field.
+func (r *rotationResolver) Organization(ctx context.Context, obj *model.Rotation) (*model.Organization, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	organization, err := data_interface.FindRotationOrganization(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// Vehicles is the resolver for the vehicles field.
+func (r *userResolver) Vehicles(ctx context.Context, obj *model.User) ([]*model.Vehicle, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	vehicles, err := data_interface.FindUserVehicles(ctx, &lCtx, obj.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return vehicles, nil
+}
+
+// NotificationPreferences is the resolver for the notificationPreferences field.
+func (r *userResolver) NotificationPreferences(ctx context.Context, obj *model.User) (*model.NotificationPreferences, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	preferences, err := data_interface.FindNotificationPreferences(ctx, &lCtx, obj.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// SwapRequests is the resolver for the swapRequests field.
+func (r *userResolver) SwapRequests(ctx context.Context, obj *model.User) ([]*model.SwapRequest, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	swaps, err := data_interface.FindUserSwapRequests(ctx, &lCtx, obj.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return swaps, nil
+}
+
+// Organizations is the resolver for the organizations field.
+func (r *userResolver) Organizations(ctx context.Context, obj *model.User) ([]*model.Organization, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	organizations, err := data_interface.FindUserOrganizations(ctx, &lCtx, obj.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// Deliveries is the resolver for the deliveries field.
+func (r *webhookResolver) Deliveries(ctx context.Context, obj *model.Webhook, limit *int) ([]*model.WebhookDelivery, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: r.DB, Tx: tx}
+	rotationId, err := data_interface.FindWebhookRotation(ctx, &lCtx, int64(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, &lCtx, rotationId); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := data_interface.FindWebhookDeliveries(ctx, &lCtx, int64(obj.ID), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Mutation returns generated.MutationResolver implementation.
+func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
+
+// Organization returns generated.OrganizationResolver implementation.
+func (r *Resolver) Organization() generated.OrganizationResolver { return &organizationResolver{r} }
+
+// Query returns generated.QueryResolver implementation.
+func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
+
+// Ride returns generated.RideResolver implementation.
+func (r *Resolver) Ride() generated.RideResolver { return &rideResolver{r} }
+
+// Rotation returns generated.RotationResolver implementation.
+func (r *Resolver) Rotation() generated.RotationResolver { return &rotationResolver{r} }
+
+// User returns generated.UserResolver implementation.
+func (r *Resolver) User() generated.UserResolver { return &userResolver{r} }
+
+// Webhook returns generated.WebhookResolver implementation.
+func (r *Resolver) Webhook() generated.WebhookResolver { return &webhookResolver{r} }
 
 type mutationResolver struct{ *Resolver }
+type organizationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type rideResolver struct{ *Resolver }
+type rotationResolver struct{ *Resolver }
+type userResolver struct{ *Resolver }
+type webhookResolver struct{ *Resolver }
@@ -0,0 +1,62 @@
+package graph
+
+import (
+	"context"
+	"whosdriving-be/auth"
+	"whosdriving-be/graph/model"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Mutations allowed to the API tokens with the RIDES_WRITE scope
+var rideMutations = map[string]bool{
+	"addRide":            true,
+	"planRides":          true,
+	"confirmRide":        true,
+	"swapRide":           true,
+	"cancelRide":         true,
+	"addAvailability":    true,
+	"removeAvailability": true,
+	"requestSwap":        true,
+	"acceptSwap":         true,
+	"declineSwap":        true,
+	"cancelSwap":         true,
+	"setRidePickup":      true,
+}
+
+// allowedByScopes tells whether an API token with the scopes may resolve the root field of the operation
+func allowedByScopes(scopes []model.TokenScope, operation ast.Operation, field string) bool {
+	for _, scope := range scopes {
+		switch {
+		case scope == model.TokenScopeAdmin:
+			return true
+		case operation != ast.Mutation:
+			return true
+		case scope == model.TokenScopeRidesWrite && rideMutations[field]:
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects the root fields the API token authenticating the request isn't scoped for,
+// requests authenticated otherwise are left to the resolvers
+func RequireScope(ctx context.Context, next graphql.RootResolver) graphql.Marshaler {
+	scopes, found := auth.ScopesForContext(ctx)
+	if !found {
+		return next(ctx)
+	}
+
+	operation := graphql.GetOperationContext(ctx).Operation.Operation
+	field := graphql.GetRootFieldContext(ctx).Field
+	if !allowedByScopes(scopes, operation, field.Name) {
+		graphql.AddError(ctx, &gqlerror.Error{
+			Message: auth.ErrInsufficientScope.Error(),
+			Path:    ast.Path{ast.PathName(field.Alias)},
+		})
+		return graphql.Null
+	}
+	return next(ctx)
+}
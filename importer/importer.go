@@ -0,0 +1,227 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+)
+
+// Layouts of the dates, without time zone they are in UTC
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Columns of the CSV files
+const (
+	columnDate       = "date"
+	columnDriver     = "driver"
+	columnPassengers = "passengers"
+	columnDirection  = "direction"
+	columnDistanceKm = "distancekm"
+)
+
+// Written by spreadsheets at the start of UTF-8 files
+const byteOrderMark = "\uFEFF"
+
+// Separator of the passengers in a CSV cell
+const passengersSeparator = ";"
+
+var errUnknownFormat = errors.New("unknown import format, expected CSV or JSON")
+
+// FormatOf tells the format from the extension of the file name
+func FormatOf(fileName string) (model.ImportFormat, error) {
+	format := model.ImportFormat(strings.ToUpper(strings.TrimPrefix(filepath.Ext(fileName), ".")))
+	if !format.IsValid() {
+		return "", errUnknownFormat
+	}
+	return format, nil
+}
+
+// rowError is an error of a single row
+type rowError struct {
+	row int
+	err error
+}
+
+func parseDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	for _, layout := range dateLayouts {
+		if parsed, err := time.Parse(layout, date); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected e.g. 2022-10-03 or 2022-10-03T08:30:00Z", date)
+}
+
+func parseDirection(direction string) (*model.Direction, error) {
+	direction = strings.TrimSpace(direction)
+	if direction == "" {
+		return nil, nil
+	}
+	parsed := model.Direction(strings.ToUpper(direction))
+	if !parsed.IsValid() {
+		return nil, fmt.Errorf("invalid direction %q, expected OUTBOUND or RETURN", direction)
+	}
+	return &parsed, nil
+}
+
+// newRide checks the fields of a row
+func newRide(row int, date string, driver string, passengers []string, direction string, distanceKm *float64) (*data_interface.ImportedRide, error) {
+	ride := data_interface.ImportedRide{Row: row, Driver: strings.TrimSpace(driver), DistanceKm: distanceKm}
+	var err error
+	if ride.Date, err = parseDate(date); err != nil {
+		return nil, err
+	}
+	if ride.Driver == "" {
+		return nil, errors.New("missing driver")
+	}
+	if ride.Direction, err = parseDirection(direction); err != nil {
+		return nil, err
+	}
+	for _, passenger := range passengers {
+		if passenger = strings.TrimSpace(passenger); passenger != "" {
+			ride.Passengers = append(ride.Passengers, passenger)
+		}
+	}
+	return &ride, nil
+}
+
+func parseCSV(r io.Reader) ([]*data_interface.ImportedRide, []rowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read the CSV header - %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, byteOrderMark)))] = i
+	}
+	for _, required := range []string{columnDate, columnDriver, columnPassengers} {
+		if _, found := columns[required]; !found {
+			return nil, nil, fmt.Errorf("missing column %s in the CSV header", required)
+		}
+	}
+
+	rides := make([]*data_interface.ImportedRide, 0)
+	var errs []rowError
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, err
+			}
+			errs = append(errs, rowError{row, err})
+			continue
+		}
+
+		cell := func(column string) string {
+			if i, found := columns[column]; found && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		var distanceKm *float64
+		if distance := strings.TrimSpace(cell(columnDistanceKm)); distance != "" {
+			parsed, err := strconv.ParseFloat(distance, 64)
+			if err != nil {
+				errs = append(errs, rowError{row, fmt.Errorf("invalid distance %q", distance)})
+				continue
+			}
+			distanceKm = &parsed
+		}
+
+		ride, err := newRide(row, cell(columnDate), cell(columnDriver), strings.Split(cell(columnPassengers), passengersSeparator), cell(columnDirection), distanceKm)
+		if err != nil {
+			errs = append(errs, rowError{row, err})
+			continue
+		}
+		rides = append(rides, ride)
+	}
+	return rides, errs, nil
+}
+
+type jsonRide struct {
+	Date       string   `json:"date"`
+	Driver     string   `json:"driver"`
+	Passengers []string `json:"passengers"`
+	Direction  string   `json:"direction"`
+	DistanceKm *float64 `json:"distanceKm"`
+}
+
+func parseJSON(r io.Reader) ([]*data_interface.ImportedRide, []rowError, error) {
+	var records []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, nil, fmt.Errorf("expected a JSON array of rides - %w", err)
+	}
+
+	rides := make([]*data_interface.ImportedRide, 0, len(records))
+	var errs []rowError
+	for i, record := range records {
+		row := i + 1
+		var parsed jsonRide
+		if err := json.Unmarshal(record, &parsed); err != nil {
+			errs = append(errs, rowError{row, fmt.Errorf("invalid ride - %w", err)})
+			continue
+		}
+		ride, err := newRide(row, parsed.Date, parsed.Driver, parsed.Passengers, parsed.Direction, parsed.DistanceKm)
+		if err != nil {
+			errs = append(errs, rowError{row, err})
+			continue
+		}
+		rides = append(rides, ride)
+	}
+	return rides, errs, nil
+}
+
+// Import reads the rides of the file and records the valid ones in the rotation, all the errors are reported.
+// The transaction must only be committed when the report has no error and isn't a dry run.
+func Import(ctx context.Context, lCtx *data_interface.LuwContext, rotationId int64, format model.ImportFormat, r io.Reader) (*model.RidesImport, error) {
+	var rides []*data_interface.ImportedRide
+	var errs []rowError
+	var err error
+	switch format {
+	case model.ImportFormatCSV:
+		rides, errs, err = parseCSV(r)
+	case model.ImportFormatJSON:
+		rides, errs, err = parseJSON(r)
+	default:
+		err = errUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := data_interface.ImportRides(ctx, lCtx, rotationId, rides)
+	if err != nil {
+		return nil, err
+	}
+	report.Rows += len(errs)
+	for _, e := range errs {
+		report.Errors = append(report.Errors, &model.ImportRowError{Row: e.row, Message: e.err.Error()})
+	}
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Row < report.Errors[j].Row
+	})
+	return report, nil
+}
@@ -0,0 +1,121 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatOf(t *testing.T) {
+	format, err := FormatOf("rides.CSV")
+	assert.Nil(t, err)
+	assert.Equal(t, model.ImportFormatCSV, format)
+	format, err = FormatOf("/tmp/rides.json")
+	assert.Nil(t, err)
+	assert.Equal(t, model.ImportFormatJSON, format)
+	_, err = FormatOf("rides.xlsx")
+	assert.NotNil(t, err)
+}
+
+func TestParseCSV(t *testing.T) {
+	rides, errs, err := parseCSV(strings.NewReader("\uFEFFDriver, Date ,Passengers,DistanceKm,Direction\n" +
+		"John Doe,2021-03-01 08:15,jane@domain.com; Jack Doe ;,12.5,outbound\n" +
+		"jane@domain.com,2021-03-02T08:00:00+01:00,,,\n" +
+		"jane@domain.com,2021-03-02,,far,\n" +
+		",2021-03-02,,,\n" +
+		"jane@domain.com\n"))
+	assert.Nil(t, err)
+
+	outbound := model.DirectionOutbound
+	distance := 12.5
+	assert.Equal(t, []*data_interface.ImportedRide{
+		{Row: 1, Date: time.Date(2021, 3, 1, 8, 15, 0, 0, time.UTC), Direction: &outbound, DistanceKm: &distance,
+			Driver: "John Doe", Passengers: []string{"jane@domain.com", "Jack Doe"}},
+		{Row: 2, Date: time.Date(2021, 3, 2, 7, 0, 0, 0, time.UTC), Driver: "jane@domain.com"},
+	}, rides)
+
+	assert.Equal(t, 3, len(errs))
+	assert.Equal(t, 3, errs[0].row)
+	assert.Contains(t, errs[0].err.Error(), "distance")
+	assert.Equal(t, 4, errs[1].row)
+	assert.Contains(t, errs[1].err.Error(), "driver")
+	assert.Equal(t, 5, errs[2].row)
+	assert.Contains(t, errs[2].err.Error(), "date")
+
+	_, _, err = parseCSV(strings.NewReader("date,driver\n2021-03-01,John Doe\n"))
+	assert.NotNil(t, err, "Missing passengers column")
+	_, _, err = parseCSV(strings.NewReader(""))
+	assert.NotNil(t, err)
+}
+
+func TestParseJSON(t *testing.T) {
+	rides, errs, err := parseJSON(strings.NewReader(`[
+		{"date": "2021-03-01", "driver": "John Doe", "passengers": ["jane@domain.com"], "direction": "RETURN"},
+		{"date": "2021-03-02", "driver": "John Doe", "passengers": "jane@domain.com"},
+		{"date": "March 3rd", "driver": "John Doe"}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rides))
+	assert.Equal(t, []string{"jane@domain.com"}, rides[0].Passengers)
+	assert.Equal(t, model.DirectionReturn, *rides[0].Direction)
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, 2, errs[0].row)
+	assert.Equal(t, 3, errs[1].row)
+
+	_, _, err = parseJSON(strings.NewReader(`{"rides": []}`))
+	assert.NotNil(t, err)
+}
+
+func TestImport(t *testing.T) {
+	const dbPath = "../test_importer.sqlite3"
+	os.Remove(dbPath)
+
+	db, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	if err := data_interface.Migrate("../assets/ddl.whosdriving-core", db); err != nil {
+		t.Fatalf("Migration error - %s", err)
+	}
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	_, err = data_interface.CreateUser(ctx, &lCtx, &model.NewUser{Email: "jane@domain.com"})
+	assert.Nil(t, err)
+	rotation, err := data_interface.CreateRotation(ctx, &lCtx, &model.NewRotation{Name: "Office", EmailCreator: "jane@domain.com"})
+	assert.Nil(t, err)
+
+	report, err := Import(ctx, &lCtx, int64(rotation.ID), model.ImportFormatCSV, strings.NewReader("date,driver,passengers\n"+
+		"2021-03-01,jane@domain.com,john@domain.com\n"+
+		"2021-03-02,john@domain.com,nobody\n"+
+		"yesterday,john@domain.com,\n"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 3, report.Rows)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, []string{"john@domain.com"}, report.CreatedUsers)
+	assert.Equal(t, 2, len(report.Errors))
+	assert.Equal(t, 2, report.Errors[0].Row, "Sorted by row")
+	assert.Equal(t, 3, report.Errors[1].Row)
+
+	_, err = Import(ctx, &lCtx, int64(rotation.ID), model.ImportFormat("XLSX"), strings.NewReader(""))
+	assert.NotNil(t, err)
+}
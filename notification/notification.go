@@ -0,0 +1,251 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+)
+
+type Event string
+
+const (
+	EventAddedToRotation Event = "ADDED_TO_ROTATION"
+	EventDriverReminder  Event = "DRIVER_REMINDER"
+	EventRideCancelled   Event = "RIDE_CANCELLED"
+	EventInvited         Event = "INVITED"
+)
+
+// Notification of an event to a user, Ride is only set for ride events and Token for invitations.
+// The ride date is given in Timezone, the time zone of the rotation, the local one when empty.
+type Notification struct {
+	Event        Event
+	To           *model.User
+	RotationName string
+	Ride         *model.Ride
+	Timezone     string
+	Token        string
+}
+
+// RideDate is the date of the ride in the time zone of the notification
+func (n *Notification) RideDate() time.Time {
+	if n.Timezone == "" {
+		return n.Ride.Date.In(time.Local)
+	}
+	loc, err := time.LoadLocation(n.Timezone)
+	if err != nil {
+		log.Printf("Error: Unknown time zone %s - %s", n.Timezone, err)
+		return n.Ride.Date.In(time.Local)
+	}
+	return n.Ride.Date.In(loc)
+}
+
+// Notifier delivers notifications to their recipient
+type Notifier interface {
+	Notify(ctx context.Context, notification *Notification) error
+}
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// Every event template defines its subject and body, each one is parsed on its own
+var templates = map[Event]*template.Template{
+	EventAddedToRotation: parseTemplate(EventAddedToRotation),
+	EventDriverReminder:  parseTemplate(EventDriverReminder),
+	EventRideCancelled:   parseTemplate(EventRideCancelled),
+	EventInvited:         parseTemplate(EventInvited),
+}
+
+func parseTemplate(event Event) *template.Template {
+	return template.Must(template.New(string(event)).Funcs(template.FuncMap{
+		"name":       displayName,
+		"date":       func(t time.Time) string { return t.Format("Monday 2 January 2006") },
+		"time":       func(t time.Time) string { return t.Format("15:04 MST") },
+		"passengers": passengers,
+	}).ParseFS(templateFiles, "templates/"+string(event)+".tmpl"))
+}
+
+func displayName(user *model.User) string {
+	names := make([]string, 0, 2)
+	if user.FirstName != nil && *user.FirstName != "" {
+		names = append(names, *user.FirstName)
+	}
+	if user.LastName != nil && *user.LastName != "" {
+		names = append(names, *user.LastName)
+	}
+	if len(names) == 0 {
+		return user.Email
+	}
+	return strings.Join(names, " ")
+}
+
+func passengers(ride *model.Ride) string {
+	names := make([]string, 0, len(ride.Participants))
+	for _, participant := range ride.Participants {
+		if participant.Email != ride.Conductor.Email {
+			names = append(names, displayName(participant))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Render returns the subject and body of the email of a notification
+func Render(notification *Notification) (string, string, error) {
+	tmpl, found := templates[notification.Event]
+	if !found {
+		return "", "", fmt.Errorf("no template for event %s", notification.Event)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", notification); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", notification); err != nil {
+		return "", "", err
+	}
+	return subject.String(), strings.TrimLeft(body.String(), "\n"), nil
+}
+
+func wants(preferences *model.NotificationPreferences, event Event) bool {
+	switch event {
+	case EventAddedToRotation:
+		return preferences.AddedToRotation
+	case EventDriverReminder:
+		return preferences.DriverReminder
+	case EventRideCancelled:
+		return preferences.RideCancelled
+	case EventInvited:
+		// Invited users have no account to opt out from
+		return true
+	}
+	return false
+}
+
+// Send delivers the notifications their recipient did not opt out from, failures are only logged
+func Send(ctx context.Context, db *sql.DB, notifier Notifier, notifications []*Notification) {
+	for _, notification := range notifications {
+		if err := send(ctx, db, notifier, notification); err != nil {
+			log.Printf("Error: Couldn't notify %s to %s - %s", notification.Event, notification.To.Email, err)
+		}
+	}
+}
+
+// send delivers the notification unless its recipient opted out from it
+func send(ctx context.Context, db *sql.DB, notifier Notifier, notification *Notification) error {
+	preferences, err := findPreferences(ctx, db, notification.To.Email)
+	if err != nil {
+		return err
+	}
+	if !wants(preferences, notification.Event) {
+		log.Printf("%s opted out from %s notifications", notification.To.Email, notification.Event)
+		return nil
+	}
+	return notifier.Notify(ctx, notification)
+}
+
+func findPreferences(ctx context.Context, db *sql.DB, email string) (*model.NotificationPreferences, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	preferences, err := data_interface.FindNotificationPreferences(ctx, &lCtx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return preferences, tx.Commit()
+}
+
+// RemindDrivers notifies the conductors of the rides starting within the period, once per ride.
+// A ride is marked as reminded once its conductor was notified, the failed reminders are sent again.
+func RemindDrivers(ctx context.Context, db *sql.DB, notifier Notifier, period time.Duration) error {
+	notifications, err := findReminders(ctx, db, period)
+	if err != nil {
+		return err
+	}
+
+	for _, notification := range notifications {
+		if err := send(ctx, db, notifier, notification); err != nil {
+			log.Printf("Error: Couldn't remind %s of ride %d - %s", notification.To.Email, notification.Ride.ID, err)
+			continue
+		}
+		if err := markReminded(ctx, db, int64(notification.Ride.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findReminders(ctx context.Context, db *sql.DB, period time.Duration) ([]*Notification, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	now := time.Now()
+	rides, err := data_interface.FindRidesToRemind(ctx, &lCtx, now, now.Add(period))
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]*Notification, 0, len(rides))
+	for _, rotationRide := range rides {
+		timezone, err := data_interface.FindRideTimezone(ctx, &lCtx, int64(rotationRide.Ride.ID))
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &Notification{
+			Event:        EventDriverReminder,
+			To:           rotationRide.Ride.Conductor,
+			RotationName: rotationRide.RotationName,
+			Ride:         rotationRide.Ride,
+			Timezone:     timezone,
+		})
+	}
+
+	return notifications, tx.Commit()
+}
+
+func markReminded(ctx context.Context, db *sql.DB, rideId int64) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	if err := data_interface.MarkRideReminded(ctx, &lCtx, rideId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LogNotifier only logs the notifications, used when no SMTP server is configured
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, notification *Notification) error {
+	subject, _, err := Render(notification)
+	if err != nil {
+		return err
+	}
+	log.Printf("Notify %s: %s", notification.To.Email, subject)
+	return nil
+}
@@ -0,0 +1,246 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordNotifier keeps the notifications instead of delivering them
+type recordNotifier struct {
+	notifications []*Notification
+	// err fails the deliveries when set
+	err error
+}
+
+func (n *recordNotifier) Notify(ctx context.Context, notification *Notification) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+// fakeSMTPServer accepts every mail and keeps its recipients and data
+type fakeSMTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	rcpts    []string
+	data     []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen - %s", err)
+	}
+	server := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.mu.Lock()
+			s.data = append(s.data, data.String())
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func newTestDb(t *testing.T, dbPath string) *sql.DB {
+	os.Remove(dbPath)
+
+	db, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+
+	if err := data_interface.Migrate("../assets/ddl.whosdriving-core", db); err != nil {
+		t.Fatalf("Migration error - %s", err)
+	}
+	return db
+}
+
+func TestRender(t *testing.T) {
+	firstName := "John"
+	rideDate := time.Date(2022, time.September, 5, 7, 45, 0, 0, time.Local)
+	conductor := &model.User{Email: "john@domain.com", FirstName: &firstName}
+	ride := &model.Ride{
+		ID:           1,
+		Date:         rideDate,
+		Conductor:    conductor,
+		Participants: []*model.User{conductor, {Email: "test@domain.com"}},
+	}
+
+	subject, body, err := Render(&Notification{Event: EventDriverReminder, To: conductor, RotationName: "Office", Ride: ride})
+	assert.Nil(t, err, "")
+	assert.Equal(t, "You are driving for Office on Monday 5 September 2022", subject)
+	assert.True(t, strings.HasPrefix(body, "Hello John,\n"))
+	assert.Contains(t, body, "at 07:45")
+	assert.Contains(t, body, "Passengers: test@domain.com\n")
+
+	// in the time zone of the rotation
+	ride.Date = time.Date(2022, time.September, 5, 5, 45, 0, 0, time.UTC)
+	_, body, err = Render(&Notification{Event: EventDriverReminder, To: conductor, RotationName: "Office", Ride: ride, Timezone: "Europe/Paris"})
+	assert.Nil(t, err, "")
+	assert.Contains(t, body, "at 07:45 CEST")
+
+	subject, body, err = Render(&Notification{Event: EventAddedToRotation, To: &model.User{Email: "test@domain.com"}, RotationName: "Office"})
+	assert.Nil(t, err, "")
+	assert.Equal(t, "You joined the rotation Office", subject)
+	assert.True(t, strings.HasPrefix(body, "Hello test@domain.com,\n"))
+
+	_, _, err = Render(&Notification{Event: "UNKNOWN", To: conductor})
+	assert.NotNil(t, err, "")
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	notifier, err := NewSMTPNotifier(host, port, "", "", "Who's driving <whosdriving@domain.com>")
+	assert.Nil(t, err, "")
+
+	_, err = NewSMTPNotifier(host, port, "", "", "not an address")
+	assert.NotNil(t, err, "")
+
+	firstName := "Jöhn"
+	err = notifier.Notify(context.Background(), &Notification{
+		Event:        EventAddedToRotation,
+		To:           &model.User{Email: "john@domain.com", FirstName: &firstName},
+		RotationName: "Office",
+	})
+	assert.Nil(t, err, "")
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.Equal(t, []string{"john@domain.com"}, server.rcpts)
+	assert.Equal(t, 1, len(server.data))
+	assert.Contains(t, server.data[0], "Subject: You joined the rotation Office\r\n")
+	assert.Contains(t, server.data[0], "To: =?utf-8?q?J=C3=B6hn?= <john@domain.com>\r\n")
+	assert.Contains(t, server.data[0], "\r\nHello Jöhn,\r\n")
+}
+
+func TestRemindDrivers(t *testing.T) {
+	db := newTestDb(t, "../test_notification.sqlite3")
+	defer db.Close()
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	for _, email := range []string{"test@domain.com", "john@domain.com", "other@domain.com"} {
+		_, err = data_interface.CreateUser(ctx, &lCtx, &model.NewUser{Email: email})
+		assert.Nil(t, err, "")
+	}
+
+	_, err = data_interface.CreateRotation(ctx, &lCtx, &model.NewRotation{
+		Name:              "Office",
+		EmailCreator:      "test@domain.com",
+		EmailParticipants: []string{"test@domain.com", "john@domain.com", "other@domain.com"},
+	})
+	assert.Nil(t, err, "")
+
+	soon := time.Now().Add(2 * time.Hour)
+	later := time.Now().Add(72 * time.Hour)
+	for _, newRide := range []*model.NewRide{
+		{IDRotation: 1, Date: &soon, EmailConductor: "john@domain.com", EmailParticipants: []string{"john@domain.com", "test@domain.com"}},
+		{IDRotation: 1, Date: &soon, EmailConductor: "other@domain.com", EmailParticipants: []string{"other@domain.com"}},
+		{IDRotation: 1, Date: &later, EmailConductor: "test@domain.com", EmailParticipants: []string{"test@domain.com"}},
+	} {
+		_, err = data_interface.AddRide(ctx, &lCtx, newRide)
+		assert.Nil(t, err, "")
+	}
+
+	// other opts out from the reminders
+	optOut := false
+	_, err = data_interface.UpdateNotificationPreferences(ctx, &lCtx, &model.NewNotificationPreferences{Email: "other@domain.com", DriverReminder: &optOut})
+	assert.Nil(t, err, "")
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+
+	// failed reminders are sent again
+	notifier := &recordNotifier{err: errors.New("SMTP server unavailable")}
+	err = RemindDrivers(ctx, db, notifier, 24*time.Hour)
+	assert.Nil(t, err, "")
+
+	notifier = &recordNotifier{}
+	err = RemindDrivers(ctx, db, notifier, 24*time.Hour)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, len(notifier.notifications))
+	assert.Equal(t, EventDriverReminder, notifier.notifications[0].Event)
+	assert.Equal(t, "john@domain.com", notifier.notifications[0].To.Email)
+	assert.Equal(t, "Office", notifier.notifications[0].RotationName)
+
+	// reminded only once
+	notifier = &recordNotifier{}
+	err = RemindDrivers(ctx, db, notifier, 24*time.Hour)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 0, len(notifier.notifications))
+}
@@ -0,0 +1,54 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPNotifier sends the notifications as plain text emails
+type SMTPNotifier struct {
+	addr string
+	auth smtp.Auth
+	from mail.Address
+}
+
+// NewSMTPNotifier authenticates only when a username is given
+func NewSMTPNotifier(host string, port string, username string, password string, from string) (*SMTPNotifier, error) {
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q - %s", from, err)
+	}
+
+	notifier := &SMTPNotifier{addr: net.JoinHostPort(host, port), from: *fromAddress}
+	if username != "" {
+		notifier.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return notifier, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, notification *Notification) error {
+	subject, body, err := Render(notification)
+	if err != nil {
+		return err
+	}
+
+	to := mail.Address{Name: displayName(notification.To), Address: notification.To.Email}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to.String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(bytes.ReplaceAll([]byte(body), []byte("\n"), []byte("\r\n")))
+
+	return smtp.SendMail(n.addr, n.auth, n.from.Address, []string{notification.To.Email}, msg.Bytes())
+}
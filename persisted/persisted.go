@@ -0,0 +1,120 @@
+package persisted
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/mitchellh/mapstructure"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	errPersistedQueryNotFound     = "PersistedQueryNotFound"
+	errPersistedQueryNotFoundCode = "PERSISTED_QUERY_NOT_FOUND"
+	errOperationNotAllowedCode    = "OPERATION_NOT_ALLOWED"
+)
+
+// Hash is the hex encoded SHA-256 of the query, as sent by the clients in the persistedQuery extension
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// AllowList only executes the queries of its manifest, the clients send either their hash or their text
+type AllowList struct {
+	queries map[string]string
+}
+
+var _ interface {
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = &AllowList{}
+
+// LoadAllowList reads a manifest of the allowed queries by hash, {"<sha256>": "<query>", ...}
+func LoadAllowList(path string) (*AllowList, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries map[string]string
+	if err := json.Unmarshal(content, &queries); err != nil {
+		return nil, fmt.Errorf("invalid query manifest %s - %w", path, err)
+	}
+	for hash, query := range queries {
+		if Hash(query) != hash {
+			return nil, fmt.Errorf("invalid query manifest %s - hash %s doesn't match its query", path, hash)
+		}
+	}
+	return &AllowList{queries: queries}, nil
+}
+
+// Len is the number of allowed queries
+func (a *AllowList) Len() int {
+	return len(a.queries)
+}
+
+func (a *AllowList) ExtensionName() string {
+	return "AllowList"
+}
+
+func (a *AllowList) Validate(schema graphql.ExecutableSchema) error {
+	if a.queries == nil {
+		return fmt.Errorf("AllowList must be loaded")
+	}
+	return nil
+}
+
+// persistedQueryHash is the hash of the persistedQuery extension, if any
+func persistedQueryHash(rawParams *graphql.RawParams) (string, *gqlerror.Error) {
+	if rawParams.Extensions["persistedQuery"] == nil {
+		return "", nil
+	}
+
+	var extension struct {
+		Sha256  string `mapstructure:"sha256Hash"`
+		Version int64  `mapstructure:"version"`
+	}
+	if err := mapstructure.Decode(rawParams.Extensions["persistedQuery"], &extension); err != nil {
+		return "", gqlerror.Errorf("invalid APQ extension data")
+	}
+	if extension.Version != 1 {
+		return "", gqlerror.Errorf("unsupported APQ version")
+	}
+	return extension.Sha256, nil
+}
+
+// MutateOperationParameters replaces the hash by its query, and refuses the queries out of the manifest
+func (a *AllowList) MutateOperationParameters(ctx context.Context, rawParams *graphql.RawParams) *gqlerror.Error {
+	hash, gqlErr := persistedQueryHash(rawParams)
+	if gqlErr != nil {
+		return gqlErr
+	}
+
+	if rawParams.Query == "" {
+		query, found := a.queries[hash]
+		if !found {
+			err := gqlerror.Errorf(errPersistedQueryNotFound)
+			errcode.Set(err, errPersistedQueryNotFoundCode)
+			return err
+		}
+		rawParams.Query = query
+		return nil
+	}
+
+	if hash != "" && Hash(rawParams.Query) != hash {
+		return gqlerror.Errorf("provided APQ hash does not match query")
+	}
+	if _, found := a.queries[Hash(rawParams.Query)]; !found {
+		err := gqlerror.Errorf("operation isn't in the allow-list")
+		errcode.Set(err, errOperationNotAllowedCode)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package persisted
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"whosdriving-be/graph"
+	"whosdriving-be/graph/generated"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+type response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func TestLoadAllowList(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, manifest string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(manifest), 0600); err != nil {
+			t.Fatalf("Couldn't write %s - %s", path, err)
+		}
+		return path
+	}
+
+	allowList, err := LoadAllowList(write("valid.json", `{"`+Hash("{ __typename }")+`": "{ __typename }"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, allowList.Len())
+
+	_, err = LoadAllowList(write("forged.json", `{"`+Hash("{ __typename }")+`": "mutation { __typename }"}`))
+	assert.NotNil(t, err)
+	_, err = LoadAllowList(write("invalid.json", `["{ __typename }"]`))
+	assert.NotNil(t, err)
+	_, err = LoadAllowList(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestAllowList(t *testing.T) {
+	const allowed = "query Allowed { __typename }"
+	const introspection = "{ __schema { queryType { name } } }"
+	allowList := &AllowList{queries: map[string]string{Hash(allowed): allowed, Hash(introspection): introspection}}
+
+	srv := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: &graph.Resolver{}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(allowList)
+
+	post := func(params map[string]interface{}) response {
+		body, _ := json.Marshal(params)
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		srv.ServeHTTP(res, req)
+
+		var decoded response
+		json.NewDecoder(res.Body).Decode(&decoded)
+		return decoded
+	}
+	persistedQuery := func(hash string) map[string]interface{} {
+		return map[string]interface{}{"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash}}
+	}
+
+	// By hash or by text
+	body := post(map[string]interface{}{"extensions": persistedQuery(Hash(allowed))})
+	assert.Empty(t, body.Errors)
+	assert.Equal(t, "Query", body.Data["__typename"])
+	body = post(map[string]interface{}{"query": allowed})
+	assert.Empty(t, body.Errors)
+
+	body = post(map[string]interface{}{"query": "{ __typename }"})
+	assert.Equal(t, 1, len(body.Errors))
+	assert.Equal(t, "OPERATION_NOT_ALLOWED", body.Errors[0].Extensions["code"])
+	body = post(map[string]interface{}{"extensions": persistedQuery(Hash("{ __typename }"))})
+	assert.Equal(t, "PERSISTED_QUERY_NOT_FOUND", body.Errors[0].Extensions["code"])
+
+	// The hash of an allowed query doesn't let another one through
+	body = post(map[string]interface{}{"query": "{ __typename }", "extensions": persistedQuery(Hash(allowed))})
+	assert.Equal(t, 1, len(body.Errors))
+	assert.Nil(t, body.Data)
+
+	// Introspection stays off without the extension, even when allowed
+	body = post(map[string]interface{}{"query": introspection})
+	assert.Equal(t, 1, len(body.Errors))
+}
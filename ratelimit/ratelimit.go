@@ -0,0 +1,267 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"whosdriving-be/auth"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Class of requests sharing a limit
+type Class string
+
+const (
+	Query    Class = "query"
+	Mutation Class = "mutation"
+	// Creates users, limited by client address even once authenticated
+	Login Class = "login"
+)
+
+// Mutations counted as logins
+var loginFields = map[string]bool{
+	"findOrCreateUser": true,
+}
+
+// Limit allows Events requests per Period, all of them at once at most
+type Limit struct {
+	Events int
+	Period time.Duration
+}
+
+var periods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseLimit reads a limit written <events>/<period> with s, m or h as period, e.g. 600/m
+func ParseLimit(limit string) (Limit, error) {
+	parts := strings.SplitN(strings.TrimSpace(limit), "/", 2)
+	if len(parts) == 2 {
+		events, err := strconv.Atoi(parts[0])
+		if period, found := periods[parts[1]]; err == nil && found && events > 0 {
+			return Limit{Events: events, Period: period}, nil
+		}
+	}
+	return Limit{}, fmt.Errorf("invalid rate limit %q, expected <events>/<s|m|h>", limit)
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Events, l.Period)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per key, each refilled with the events of the limit over its period
+type Limiter struct {
+	limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{limit: limit, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of key, otherwise returns the delay until the next one
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	return l.AllowN(key, 1, now)
+}
+
+// AllowN takes n tokens at once from the bucket of key, otherwise returns the delay until there are enough.
+// More tokens than the bucket holds are never allowed, the delay is then the whole period.
+func (l *Limiter) AllowN(key string, n int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(l.limit.Events)
+	rate := capacity / float64(l.limit.Period)
+
+	// Buckets refilled since are the same as new ones
+	if now.Sub(l.lastPurge) > l.limit.Period {
+		for bucketKey, b := range l.buckets {
+			if now.Sub(b.last) > l.limit.Period {
+				delete(l.buckets, bucketKey)
+			}
+		}
+		l.lastPurge = now
+	}
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)*rate)
+		b.last = now
+	}
+
+	if float64(n) > capacity {
+		return false, l.limit.Period
+	}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return true, 0
+	}
+	return false, time.Duration(math.Ceil((float64(n) - b.tokens) / rate))
+}
+
+type request struct {
+	clientIP string
+	header   http.Header
+}
+
+type requestKey struct{}
+
+// RateLimiter limits the requests of each user, or of each client address when anonymous
+type RateLimiter struct {
+	limiters map[Class]*Limiter
+	// Header holding the client address set by the reverse proxy, the remote address otherwise
+	ipHeader string
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = &RateLimiter{}
+
+// New limits the classes of requests given, the other ones are unlimited
+func New(limits map[Class]Limit, ipHeader string) *RateLimiter {
+	limiters := make(map[Class]*Limiter, len(limits))
+	for class, limit := range limits {
+		limiters[class] = NewLimiter(limit)
+	}
+	return &RateLimiter{limiters: limiters, ipHeader: ipHeader}
+}
+
+func (l *RateLimiter) clientIP(r *http.Request) string {
+	if l.ipHeader != "" {
+		// The proxy appends the address it received the request from
+		if forwarded := strings.Split(r.Header.Get(l.ipHeader), ","); strings.TrimSpace(forwarded[len(forwarded)-1]) != "" {
+			return strings.TrimSpace(forwarded[len(forwarded)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware passes the client address and the response headers to the extension
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requestKey{}, &request{clientIP: l.clientIP(r), header: w.Header()})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Handler limits the plain HTTP requests of the class by client address
+func (l *RateLimiter) Handler(class Class, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed, retryAfter := l.allow(class, "ip:"+l.clientIP(r), 1, time.Now()); !allowed {
+			w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *RateLimiter) allow(class Class, key string, tokens int, now time.Time) (bool, time.Duration) {
+	limiter, found := l.limiters[class]
+	if !found {
+		return true, 0
+	}
+	return limiter.AllowN(key, tokens, now)
+}
+
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
+// operationClass tells the class of a GraphQL operation and the tokens it takes.
+// A mutation with login fields is a login taking a token per login field, aliased or in fragments.
+func operationClass(operation *ast.OperationDefinition) (Class, int) {
+	if operation.Operation != ast.Mutation {
+		return Query, 1
+	}
+	if logins := countLoginFields(operation.SelectionSet); logins > 0 {
+		return Login, logins
+	}
+	return Mutation, 1
+}
+
+// countLoginFields counts the login fields of a root selection set, through its fragments
+func countLoginFields(selectionSet ast.SelectionSet) int {
+	count := 0
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if loginFields[selection.Name] {
+				count++
+			}
+		case *ast.InlineFragment:
+			count += countLoginFields(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			if selection.Definition != nil {
+				count += countLoginFields(selection.Definition.SelectionSet)
+			}
+		}
+	}
+	return count
+}
+
+func (l *RateLimiter) ExtensionName() string {
+	return "RateLimiter"
+}
+
+func (l *RateLimiter) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// MutateOperationContext refuses the operation once the limit of its class is reached
+func (l *RateLimiter) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	req, _ := ctx.Value(requestKey{}).(*request)
+	class, tokens := operationClass(rc.Operation)
+
+	var key string
+	if email, found := auth.ForContext(ctx); found && class != Login {
+		key = "user:" + email
+	} else if req != nil {
+		key = "ip:" + req.clientIP
+	} else {
+		return nil
+	}
+
+	allowed, retryAfter := l.allow(class, key, tokens, time.Now())
+	if allowed {
+		return nil
+	}
+	if req != nil {
+		req.header.Set("Retry-After", retryAfterSeconds(retryAfter))
+	}
+	return &gqlerror.Error{
+		Message: fmt.Sprintf("too many %s requests, retry in %s seconds", class, retryAfterSeconds(retryAfter)),
+		Extensions: map[string]interface{}{
+			"code":       "RATE_LIMITED",
+			"retryAfter": math.Ceil(retryAfter.Seconds()),
+		},
+	}
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"whosdriving-be/auth"
+	"whosdriving-be/graph"
+	"whosdriving-be/graph/generated"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("600/m")
+	assert.Nil(t, err)
+	assert.Equal(t, Limit{Events: 600, Period: time.Minute}, limit)
+	limit, err = ParseLimit(" 5/h ")
+	assert.Nil(t, err)
+	assert.Equal(t, Limit{Events: 5, Period: time.Hour}, limit)
+
+	for _, invalid := range []string{"", "600", "600/d", "0/s", "-1/s", "a/s"} {
+		_, err := ParseLimit(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(Limit{Events: 2, Period: time.Second})
+	now := time.Now()
+
+	// The burst is the whole bucket
+	allowed, _ := limiter.Allow("a", now)
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("a", now)
+	assert.True(t, allowed)
+	allowed, retryAfter := limiter.Allow("a", now)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// Buckets are independent
+	allowed, _ = limiter.Allow("b", now)
+	assert.True(t, allowed)
+
+	// Several tokens at once, never more than the bucket holds
+	allowed, _ = limiter.AllowN("d", 2, now)
+	assert.True(t, allowed)
+	allowed, retryAfter = limiter.AllowN("d", 2, now)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+	allowed, retryAfter = limiter.AllowN("e", 3, now)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// Refilled over the period
+	allowed, _ = limiter.Allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("a", now.Add(500*time.Millisecond))
+	assert.False(t, allowed)
+	allowed, _ = limiter.Allow("a", now.Add(10*time.Second))
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("a", now.Add(10*time.Second))
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("a", now.Add(10*time.Second))
+	assert.False(t, allowed, "No more than the burst after a long pause")
+
+	// Idle buckets are purged
+	limiter.Allow("c", now.Add(time.Minute))
+	assert.Equal(t, 1, len(limiter.buckets))
+}
+
+func TestOperationClass(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		type Query { a: Int }
+		type Mutation { findOrCreateUser: Int, addRide: Int }`})
+	type classTokens struct {
+		class  Class
+		tokens int
+	}
+	for query, expected := range map[string]classTokens{
+		"{ a }":                                 {Query, 1},
+		"mutation { addRide }":                  {Mutation, 1},
+		"mutation { addRide findOrCreateUser }": {Login, 1},
+		// every login field takes a token, aliased or in fragments
+		"mutation { a: findOrCreateUser b: findOrCreateUser }":                                                               {Login, 2},
+		"mutation { ... on Mutation { findOrCreateUser } }":                                                                  {Login, 1},
+		"mutation { ...logins c: findOrCreateUser } fragment logins on Mutation { a: findOrCreateUser b: findOrCreateUser }": {Login, 3},
+		"mutation { ... on Mutation { ... on Mutation { addRide } } }":                                                       {Mutation, 1},
+	} {
+		doc := gqlparser.MustLoadQuery(schema, query)
+		class, tokens := operationClass(doc.Operations[0])
+		assert.Equal(t, expected, classTokens{class, tokens}, query)
+	}
+}
+
+type response struct {
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := New(map[Class]Limit{
+		Query:    {Events: 2, Period: time.Hour},
+		Mutation: {Events: 1, Period: time.Hour},
+	}, "X-Forwarded-For")
+
+	srv := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: &graph.Resolver{}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(limiter)
+	server := httptest.NewServer(auth.ProxyHeader("X-Email", limiter.Middleware(srv)))
+	defer server.Close()
+
+	post := func(query string, header map[string]string) (*http.Response, response) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"query": "`+query+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Couldn't post - %s", err)
+		}
+		defer res.Body.Close()
+
+		var body response
+		json.NewDecoder(res.Body).Decode(&body)
+		return res, body
+	}
+
+	client := map[string]string{"X-Forwarded-For": "10.0.0.1, 192.168.0.1"}
+	for i := 0; i < 2; i++ {
+		_, body := post("{ __typename }", client)
+		assert.Empty(t, body.Errors)
+	}
+	res, body := post("{ __typename }", client)
+	assert.Equal(t, 1, len(body.Errors))
+	assert.Equal(t, "RATE_LIMITED", body.Errors[0].Extensions["code"])
+	assert.Equal(t, "1800", res.Header.Get("Retry-After"))
+
+	// Mutations have their own limit
+	_, body = post("mutation { __typename }", client)
+	assert.Empty(t, body.Errors)
+	_, body = post("mutation { __typename }", client)
+	assert.Equal(t, "RATE_LIMITED", body.Errors[0].Extensions["code"])
+
+	// Other clients and authenticated users have their own buckets
+	_, body = post("{ __typename }", map[string]string{"X-Forwarded-For": "10.0.0.1, 192.168.0.2"})
+	assert.Empty(t, body.Errors)
+	client["X-Email"] = "john.doe@domain.com"
+	_, body = post("{ __typename }", client)
+	assert.Empty(t, body.Errors)
+
+	// Including for mutations
+	_, body = post("mutation { __typename }", client)
+	assert.Empty(t, body.Errors)
+
+	// Plain HTTP endpoints
+	login := limiter.Handler(Mutation, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	req.RemoteAddr = "10.0.0.3:1234"
+	recorder := httptest.NewRecorder()
+	login.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = httptest.NewRecorder()
+	login.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "3600", recorder.Header().Get("Retry-After"))
+}
@@ -0,0 +1,103 @@
+package security
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Methods and headers the browsers may use cross-origin
+const (
+	allowedMethods = "GET, POST, OPTIONS"
+	allowedHeaders = "Authorization, Content-Type"
+)
+
+// Duration the browsers may cache a preflight response
+const preflightMaxAge = 10 * time.Minute
+
+// CORS lets the pages of the allowed origins call the API from the browser
+type CORS struct {
+	origins map[string]bool
+	// Any origin is allowed
+	any bool
+	// The browsers send the cookies along
+	credentials bool
+}
+
+// NewCORS allows the origins given, scheme://host[:port], or any of them with *.
+// Credentials can't be allowed to any origin.
+func NewCORS(origins []string, credentials bool) (*CORS, error) {
+	c := CORS{origins: make(map[string]bool), credentials: credentials}
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "":
+			continue
+		case origin == "*":
+			c.any = true
+		default:
+			parsed, err := url.Parse(origin)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+				return nil, errors.New("invalid CORS origin " + origin + ", expected scheme://host[:port]")
+			}
+			c.origins[parsed.Scheme+"://"+parsed.Host] = true
+		}
+	}
+	if c.any && credentials {
+		return nil, errors.New("CORS credentials can't be allowed to any origin")
+	}
+	return &c, nil
+}
+
+// AllowedOrigin tells whether the origin is allowed
+func (c *CORS) AllowedOrigin(origin string) bool {
+	return c.any || c.origins[origin]
+}
+
+// Handler adds the CORS headers to the responses to the allowed origins, and answers their preflight requests
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !c.AllowedOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.any {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(preflightMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckOrigin accepts the websocket upgrades from the same origin or an allowed one.
+// Clients out of a browser send no origin.
+func (c *CORS) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host) || c.AllowedOrigin(origin)
+}
@@ -0,0 +1,89 @@
+package security
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCORS(t *testing.T) {
+	cors, err := NewCORS([]string{"https://app.domain.com", " http://localhost:3000/", ""}, true)
+	assert.Nil(t, err)
+	assert.True(t, cors.AllowedOrigin("https://app.domain.com"))
+	assert.True(t, cors.AllowedOrigin("http://localhost:3000"))
+	assert.False(t, cors.AllowedOrigin("https://evil.com"))
+
+	cors, err = NewCORS([]string{"*"}, false)
+	assert.Nil(t, err)
+	assert.True(t, cors.AllowedOrigin("https://evil.com"))
+
+	for _, origins := range [][]string{{"app.domain.com"}, {"https://app.domain.com/path"}, {"*"}} {
+		_, err := NewCORS(origins, true)
+		assert.NotNil(t, err, origins)
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	cors, _ := NewCORS([]string{"https://app.domain.com"}, true)
+	called := false
+	handler := cors.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/query", nil)
+	req.Header.Set("Origin", "https://app.domain.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.False(t, called, "Preflight requests are answered")
+	assert.Equal(t, "https://app.domain.com", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, res.Header().Get("Access-Control-Allow-Headers"), "Content-Type")
+
+	req = httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.Header.Set("Origin", "https://app.domain.com")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.True(t, called)
+	assert.Equal(t, "https://app.domain.com", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", res.Header().Get("Vary"))
+
+	// Other origins get no header, the browser blocks the response
+	req.Header.Set("Origin", "https://evil.com")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, "", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", res.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCheckOrigin(t *testing.T) {
+	cors, _ := NewCORS([]string{"https://app.domain.com"}, false)
+	req := httptest.NewRequest(http.MethodGet, "http://api.domain.com/query", nil)
+	assert.True(t, cors.CheckOrigin(req), "No origin out of a browser")
+	req.Header.Set("Origin", "http://api.domain.com")
+	assert.True(t, cors.CheckOrigin(req))
+	req.Header.Set("Origin", "https://app.domain.com")
+	assert.True(t, cors.CheckOrigin(req))
+	req.Header.Set("Origin", "https://evil.com")
+	assert.False(t, cors.CheckOrigin(req))
+}
+
+func TestHeaders(t *testing.T) {
+	handler := Headers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "nosniff", res.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", res.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "", res.Header().Get("Strict-Transport-Security"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, "max-age=31536000", res.Header().Get("Strict-Transport-Security"))
+}
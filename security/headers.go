@@ -0,0 +1,26 @@
+package security
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Duration the browsers only use HTTPS once they got a response over TLS
+const hstsMaxAge = 365 * 24 * time.Hour
+
+// Headers adds the standard security headers to the responses, HSTS when served over TLS
+func Headers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Content-Security-Policy", "frame-ancestors 'none'")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if r.TLS != nil {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hstsMaxAge.Seconds())))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,38 @@
+package security
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, address := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"} {
+		assert.True(t, IsPublicIP(net.ParseIP(address)), address)
+	}
+	for _, address := range []string{"127.0.0.1", "::1", "0.0.0.0", "10.1.2.3", "172.16.0.1", "192.168.1.1",
+		"169.254.169.254", "fe80::1", "fd00::1", "::ffff:127.0.0.1"} {
+		assert.False(t, IsPublicIP(net.ParseIP(address)), address)
+	}
+}
+
+func TestCheckPublicHost(t *testing.T) {
+	assert.Nil(t, CheckPublicHost("hooks.domain.com"))
+	assert.Nil(t, CheckPublicHost("93.184.216.34"))
+	for _, host := range []string{"localhost", "LOCALHOST.", "api.localhost", "127.0.0.1", "::1", "169.254.169.254", "10.0.0.1"} {
+		assert.True(t, errors.Is(CheckPublicHost(host), ErrPrivateAddress), host)
+	}
+}
+
+func TestPublicTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: PublicTransport()}
+	_, err := client.Get(server.URL)
+	assert.True(t, errors.Is(err, ErrPrivateAddress), err)
+}
@@ -0,0 +1,100 @@
+package security
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Minimum interval between two checks of the certificate files
+const certCheckInterval = 10 * time.Second
+
+// CertReloader serves the certificate of its files, reloaded once they change
+type CertReloader struct {
+	certPath string
+	keyPath  string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	lastCheck   time.Time
+}
+
+// NewCertReloader loads the PEM encoded certificate and key
+func NewCertReloader(certPath string, keyPath string) (*CertReloader, error) {
+	c := CertReloader{certPath: certPath, keyPath: keyPath}
+	if err := c.load(time.Now()); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// filesModTime is the latest modification of the files
+func (c *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certPath, c.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *CertReloader) load(now time.Time) error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+	c.certificate = &certificate
+	c.modTime = modTime
+	c.lastCheck = now
+	return nil
+}
+
+// reload loads the files again if they changed since the last load, the current certificate is kept on error
+func (c *CertReloader) reload(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastCheck) < certCheckInterval {
+		return
+	}
+	c.lastCheck = now
+	modTime, err := c.filesModTime()
+	if err != nil || modTime.Equal(c.modTime) {
+		return
+	}
+	if err := c.load(now); err != nil {
+		// The files may be half written, retried on the next check
+		log.Printf("Error: Couldn't reload certificate %s - %s", c.certPath, err)
+		return
+	}
+	log.Printf("Reloaded certificate %s", c.certPath)
+}
+
+// GetCertificate is the tls.Config hook
+func (c *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.reload(time.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.certificate, nil
+}
+
+// TLSConfig serves the certificate, TLS 1.2 at least
+func (c *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
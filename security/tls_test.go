@@ -0,0 +1,82 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes a self-signed certificate for the name
+func writeCertificate(t *testing.T, certPath string, keyPath string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Couldn't generate key - %s", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Couldn't create certificate - %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Couldn't marshal key - %s", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, reloader *CertReloader) string {
+	certificate, err := reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.Nil(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	_, err := NewCertReloader(certPath, keyPath)
+	assert.NotNil(t, err)
+
+	writeCertificate(t, certPath, keyPath, "first")
+	reloader, err := NewCertReloader(certPath, keyPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", commonName(t, reloader))
+
+	// Renewed files are loaded on the next check
+	writeCertificate(t, certPath, keyPath, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certPath, later, later)
+	assert.Equal(t, "first", commonName(t, reloader))
+	reloader.reload(time.Now().Add(certCheckInterval))
+	assert.Equal(t, "second", commonName(t, reloader))
+
+	// Broken files don't replace the certificate
+	os.WriteFile(keyPath, []byte("garbage"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyPath, later, later)
+	reloader.reload(time.Now().Add(2 * certCheckInterval))
+	assert.Equal(t, "second", commonName(t, reloader))
+}
@@ -1,32 +1,111 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
+	"whosdriving-be/auth"
+	"whosdriving-be/avatar"
+	"whosdriving-be/backup"
+	"whosdriving-be/calendar"
 	"whosdriving-be/data_interface"
 	"whosdriving-be/graph"
 	"whosdriving-be/graph/generated"
+	"whosdriving-be/notification"
+	"whosdriving-be/persisted"
+	"whosdriving-be/ratelimit"
+	"whosdriving-be/security"
+	"whosdriving-be/webhook"
 
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/gorilla/websocket"
 )
 
 const defaultHost = "127.0.0.1"
 const defaultPort = "8080"
 const defaultDbHostPath = "/app/data/whosdriving"
 const defaultDdlPath = "/app/assets/ddl.whosdriving-core"
+const defaultAvatarDir = "/app/data/avatars"
+const defaultBackupDir = "/app/data/backups"
+const defaultBackupRetain = 7
+const defaultPlanHorizonDays = 14
+const defaultSmtpPort = "25"
+const defaultSmtpFrom = "whosdriving@localhost"
+const defaultMaxComplexity = 20000
+const defaultMaxDepth = 10
+const defaultApqCacheSize = 100
+
+// Parsed queries kept to skip parsing and validating them again
+const queryCacheSize = 1000
+
+// Interval between two pings of the websocket clients
+const websocketKeepAlive = 10 * time.Second
+
+// Requests allowed to each user, or to each client address when anonymous
+var defaultRateLimits = map[ratelimit.Class]string{
+	ratelimit.Query:    "600/m",
+	ratelimit.Mutation: "120/m",
+	ratelimit.Login:    "10/m",
+}
+
+// Interval between two materialisations of the scheduled rides
+const planInterval = time.Hour
+
+// Drivers are reminded of the rides starting within this period
+const remindPeriod = 24 * time.Hour
+const remindInterval = time.Hour
+
+// Interval between two runs of the webhook deliveries, and the timeout of each post
+const webhookInterval = 30 * time.Second
+const webhookTimeout = 10 * time.Second
+
+// Timeout of the requests to the OpenID provider
+const oidcTimeout = 10 * time.Second
 
 type Config struct {
-	host    string
-	port    string
-	dbPath  string
-	ddlPath string
+	host            string
+	port            string
+	dbPath          string
+	ddlPath         string
+	avatarDir       string
+	planHorizonDays int
+	smtpHost        string
+	smtpPort        string
+	smtpUsername    string
+	smtpPassword    string
+	smtpFrom        string
+	authHeader      string
+	oidc            auth.OIDCConfig
+	rateLimits      map[ratelimit.Class]ratelimit.Limit
+	rateLimitHeader string
+	maxComplexity   int
+	maxDepth        int
+	apqCacheSize    int
+	// Manifest of the only queries allowed, arbitrary ones are allowed without
+	queryAllowList string
+	// Origins of the web pages allowed to call the API, the same origin only without
+	corsOrigins     []string
+	corsCredentials bool
+	// Served over HTTPS with both
+	tlsCertFile  string
+	tlsKeyFile   string
+	backupDir    string
+	backupRetain int
+	// Without, the database is only backed up on demand
+	backupInterval time.Duration
 }
 
 func checkFileExists(filePath string) bool {
@@ -58,7 +137,134 @@ func createConfig() Config {
 		config.ddlPath = defaultDdlPath
 	}
 
-	log.Printf("%q", config)
+	config.avatarDir, found = os.LookupEnv("AVATAR_DIR")
+	if !found {
+		config.avatarDir = defaultAvatarDir
+	}
+
+	config.backupDir, found = os.LookupEnv("BACKUP_DIR")
+	if !found {
+		config.backupDir = defaultBackupDir
+	}
+	config.backupRetain = defaultBackupRetain
+	if backupRetain, found := os.LookupEnv("BACKUP_RETAIN"); found {
+		retain, err := strconv.Atoi(backupRetain)
+		if err != nil || retain < 1 {
+			log.Fatalf("Invalid BACKUP_RETAIN %s, expected a positive number", backupRetain)
+		}
+		config.backupRetain = retain
+	}
+	if backupInterval := os.Getenv("BACKUP_INTERVAL"); backupInterval != "" {
+		interval, err := time.ParseDuration(backupInterval)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid BACKUP_INTERVAL %s, expected a duration such as 24h", backupInterval)
+		}
+		config.backupInterval = interval
+	}
+
+	config.planHorizonDays = defaultPlanHorizonDays
+	if planHorizonDays, found := os.LookupEnv("PLAN_HORIZON_DAYS"); found {
+		days, err := strconv.Atoi(planHorizonDays)
+		if err != nil {
+			log.Fatalf("Invalid PLAN_HORIZON_DAYS %s - %s", planHorizonDays, err)
+		}
+		config.planHorizonDays = days
+	}
+
+	config.maxComplexity = defaultMaxComplexity
+	if maxComplexity, found := os.LookupEnv("MAX_COMPLEXITY"); found {
+		complexity, err := strconv.Atoi(maxComplexity)
+		if err != nil {
+			log.Fatalf("Invalid MAX_COMPLEXITY %s - %s", maxComplexity, err)
+		}
+		config.maxComplexity = complexity
+	}
+
+	config.maxDepth = defaultMaxDepth
+	if maxDepth, found := os.LookupEnv("MAX_DEPTH"); found {
+		depth, err := strconv.Atoi(maxDepth)
+		if err != nil {
+			log.Fatalf("Invalid MAX_DEPTH %s - %s", maxDepth, err)
+		}
+		config.maxDepth = depth
+	}
+
+	config.apqCacheSize = defaultApqCacheSize
+	if apqCacheSize, found := os.LookupEnv("APQ_CACHE_SIZE"); found {
+		size, err := strconv.Atoi(apqCacheSize)
+		if err != nil || size <= 0 {
+			log.Fatalf("Invalid APQ_CACHE_SIZE %s, expected a positive number", apqCacheSize)
+		}
+		config.apqCacheSize = size
+	}
+	config.queryAllowList = os.Getenv("QUERY_ALLOWLIST")
+
+	if corsOrigins := os.Getenv("CORS_ALLOWED_ORIGINS"); corsOrigins != "" {
+		config.corsOrigins = strings.Split(corsOrigins, ",")
+	}
+	if corsCredentials, found := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); found {
+		credentials, err := strconv.ParseBool(corsCredentials)
+		if err != nil {
+			log.Fatalf("Invalid CORS_ALLOW_CREDENTIALS %s - %s", corsCredentials, err)
+		}
+		config.corsCredentials = credentials
+	}
+
+	config.tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	config.tlsKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (config.tlsCertFile == "") != (config.tlsKeyFile == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	// Without SMTP_HOST the notifications are only logged
+	config.smtpHost = os.Getenv("SMTP_HOST")
+	config.smtpPort, found = os.LookupEnv("SMTP_PORT")
+	if !found {
+		config.smtpPort = defaultSmtpPort
+	}
+	config.smtpUsername = os.Getenv("SMTP_USERNAME")
+	config.smtpPassword = os.Getenv("SMTP_PASSWORD")
+	config.smtpFrom, found = os.LookupEnv("SMTP_FROM")
+	if !found {
+		config.smtpFrom = defaultSmtpFrom
+	}
+
+	// Header holding the email of the user authenticated by the reverse proxy, none by default
+	config.authHeader = os.Getenv("AUTH_HEADER")
+
+	// OpenID Connect login is enabled with OIDC_ISSUER
+	config.oidc.Issuer = os.Getenv("OIDC_ISSUER")
+	config.oidc.ClientID = os.Getenv("OIDC_CLIENT_ID")
+	config.oidc.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	config.oidc.RedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+
+	// RATE_LIMIT_<CLASS> as <events>/<s|m|h>, empty to disable the limit
+	config.rateLimits = make(map[ratelimit.Class]ratelimit.Limit)
+	for class, defaultLimit := range defaultRateLimits {
+		limit, found := os.LookupEnv("RATE_LIMIT_" + strings.ToUpper(string(class)))
+		if !found {
+			limit = defaultLimit
+		}
+		if limit == "" {
+			continue
+		}
+		parsed, err := ratelimit.ParseLimit(limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.rateLimits[class] = parsed
+	}
+	// Header holding the client address set by the reverse proxy, e.g. X-Forwarded-For
+	config.rateLimitHeader = os.Getenv("RATE_LIMIT_IP_HEADER")
+
+	logged := config
+	if logged.smtpPassword != "" {
+		logged.smtpPassword = "****"
+	}
+	if logged.oidc.ClientSecret != "" {
+		logged.oidc.ClientSecret = "****"
+	}
+	log.Printf("%+v", logged)
 	return config
 }
 
@@ -71,31 +277,205 @@ func newDb(dbPath string, ddlPath string) *sql.DB {
 		log.Fatal(err)
 	}
 
-	// Migration this is a new DB
-	if newDb && checkFileExists(ddlPath) {
-		log.Printf("Migrate database %s", ddlPath)
+	version, err := data_interface.FindSchemaVersion(db)
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+
+	// Migration this is a new DB, or an existing one behind the version of the DDL
+	switch {
+	case (newDb || version < data_interface.SchemaVersion) && checkFileExists(ddlPath):
+		log.Printf("Migrate database %s from version %d to %d with %s", dbPath, version, data_interface.SchemaVersion, ddlPath)
 		err := data_interface.Migrate(ddlPath, db)
 		if err != nil {
 			db.Close()
 			log.Fatal(err)
 		}
+	case !newDb && version < data_interface.SchemaVersion:
+		db.Close()
+		log.Fatalf("The schema of the database is at version %d of %d, run migrate up", version, data_interface.SchemaVersion)
 	}
 
 	return db
 }
 
-func main() {
-	config := createConfig()
+func newNotifier(config Config) notification.Notifier {
+	if config.smtpHost == "" {
+		log.Printf("No SMTP server configured, notifications are only logged")
+		return notification.LogNotifier{}
+	}
+
+	notifier, err := notification.NewSMTPNotifier(config.smtpHost, config.smtpPort, config.smtpUsername, config.smtpPassword, config.smtpFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return notifier
+}
+
+// Keep the planned rides of every schedule materialised up to the horizon
+func planRides(db *sql.DB, horizon time.Duration) {
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		log.Printf("Error: Couldn't start txn - %s", err)
+		return
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	rides, err := data_interface.PlanAllRides(ctx, &lCtx, time.Now().Add(horizon))
+	if err != nil {
+		log.Printf("Error: Couldn't plan rides - %s", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error: Could not commit - %s", err)
+		return
+	}
+	log.Printf("Planned %d rides", len(rides))
+}
+
+func serve(config Config) {
 	db := newDb(config.dbPath, config.ddlPath)
 	defer db.Close()
 
+	planHorizon := time.Duration(config.planHorizonDays) * 24 * time.Hour
+	go func() {
+		for {
+			planRides(db, planHorizon)
+			time.Sleep(planInterval)
+		}
+	}()
+
+	notifier := newNotifier(config)
+	go func() {
+		for {
+			if err := notification.RemindDrivers(context.Background(), db, notifier, remindPeriod); err != nil {
+				log.Printf("Error: Couldn't remind drivers - %s", err)
+			}
+			time.Sleep(remindInterval)
+		}
+	}()
+
+	go func() {
+		// The receivers are only reached on public addresses
+		client := &http.Client{Timeout: webhookTimeout, Transport: security.PublicTransport()}
+		for {
+			if _, err := webhook.Deliver(context.Background(), db, client); err != nil {
+				log.Printf("Error: Couldn't deliver webhooks - %s", err)
+			}
+			time.Sleep(webhookInterval)
+		}
+	}()
+
+	avatars, err := avatar.NewStore(config.avatarDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backups, err := backup.NewStore(config.backupDir, config.backupRetain)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.backupInterval > 0 {
+		log.Printf("Back up the database every %s in %s", config.backupInterval, config.backupDir)
+		go func() {
+			for {
+				time.Sleep(config.backupInterval)
+				if _, err := backups.Create(context.Background(), db, time.Now()); err != nil {
+					log.Printf("Error: Couldn't back up the database - %s", err)
+				}
+			}
+		}()
+	}
+
 	log.Println("Prepare graphQL resolver")
-	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: &graph.Resolver{DB: db}}))
+	srv := handler.New(generated.NewExecutableSchema(generated.Config{
+		Resolvers:  &graph.Resolver{DB: db, PlanHorizon: planHorizon, Notifier: notifier, Avatars: avatars, Backups: backups},
+		Complexity: graph.NewComplexity(),
+	}))
+	cors, err := security.NewCORS(config.corsOrigins, config.corsCredentials)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: websocketKeepAlive,
+		Upgrader:              websocket.Upgrader{CheckOrigin: cors.CheckOrigin},
+	})
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+	srv.SetQueryCache(lru.New(queryCacheSize))
+	if config.queryAllowList != "" {
+		allowList, err := persisted.LoadAllowList(config.queryAllowList)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Only allow the %d queries of %s", allowList.Len(), config.queryAllowList)
+		srv.Use(allowList)
+	} else {
+		srv.Use(extension.Introspection{})
+		srv.Use(extension.AutomaticPersistedQuery{Cache: lru.New(config.apqCacheSize)})
+	}
+	srv.Use(extension.FixedComplexityLimit(config.maxComplexity))
+	srv.Use(graph.DepthLimit{Max: config.maxDepth})
+	srv.AroundResponses(graph.ReportCost)
+	srv.AroundRootFields(graph.RequireScope)
+	limiter := ratelimit.New(config.rateLimits, config.rateLimitHeader)
+	srv.Use(limiter)
 
 	log.Println("Setup router")
-	http.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	http.Handle("/query", srv)
+	if config.queryAllowList == "" {
+		http.Handle("/", playground.Handler("GraphQL playground", "/query"))
+	}
+	// The API token prevails over the other authentications
+	query := auth.BearerToken(db, srv)
+	if config.oidc.Issuer != "" {
+		log.Printf("Log users in with %s", config.oidc.Issuer)
+		oidc, err := auth.NewOIDC(context.Background(), config.oidc, db, &http.Client{Timeout: oidcTimeout})
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle("/auth/login", limiter.Handler(ratelimit.Login, oidc.LoginHandler()))
+		http.Handle("/auth/callback", limiter.Handler(ratelimit.Login, oidc.CallbackHandler()))
+		http.Handle("/auth/logout", auth.LogoutHandler(db))
+		query = auth.Session(db, query)
+	}
+	if config.authHeader != "" {
+		log.Printf("Trust the user authenticated by the proxy in %s", config.authHeader)
+		query = auth.ProxyHeader(config.authHeader, query)
+	}
+	http.Handle("/query", limiter.Middleware(query))
+	http.Handle("/calendar/", calendar.Handler(db, "/calendar/"))
+	http.Handle(avatar.Prefix, avatars.Handler())
+
+	server := &http.Server{
+		Addr:    ":" + config.port,
+		Handler: security.Headers(cors.Handler(http.DefaultServeMux)),
+	}
+	scheme := "http"
+	if config.tlsCertFile != "" {
+		certificates, err := security.NewCertReloader(config.tlsCertFile, config.tlsKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = certificates.TLSConfig()
+		scheme = "https"
+	}
 
-	log.Printf("Connect to http://%s:%s/ for GraphQL playground", config.host, config.port)
-	log.Fatal(http.ListenAndServe(":"+config.port, nil))
+	if config.queryAllowList == "" {
+		log.Printf("Connect to %s://%s:%s/ for GraphQL playground", scheme, config.host, config.port)
+	}
+	if server.TLSConfig != nil {
+		// The certificate comes from the TLS config
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(server.ListenAndServe())
+}
+
+func main() {
+	os.Exit(run(os.Args, os.Stdout, os.Stderr))
 }
@@ -3,6 +3,9 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/ratelimit"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -69,7 +72,14 @@ func TestNewDb(t *testing.T) {
 func TestCreateNewDb(t *testing.T) {
 	os.Remove("./test_new_db.sqlite3")
 
-	expected := []string{"Users", "RefRole", "Rotations", "RotationParticipants", "Rides", "RideParticipants"}
+	expected := []string{"Users", "RefRole", "RefRideStatus", "RefDirection", "RefAvailabilityKind", "RefFuelType",
+		"RefCostRuleKind", "RefLedgerEntryKind", "Vehicles", "Rotations", "RotationParticipants", "Schedules", "Rides",
+		"RideParticipants", "Availabilities", "CostRules", "RideCostRules", "Expenses", "Payments", "LedgerEntries",
+		"RefWebhookEvent", "RefDeliveryStatus", "Webhooks", "WebhookDeliveries",
+		"RefInvitationStatus", "Invitations", "JoinCodes", "RefJoinRequestStatus", "JoinRequests",
+		"RefSwapStatus", "SwapRequests", "MeetingPoints", "RefOrgRole", "Organizations", "OrganizationMembers",
+		"UsersSearch", "UsersSearch_content", "UsersSearch_segments", "UsersSearch_segdir", "UsersSearch_docsize", "UsersSearch_stat",
+		"Sessions", "RefTokenScope", "ApiTokens", "ApiTokenScopes"}
 
 	db := newDb("./test_new_db.sqlite3", "./assets/ddl.whosdriving-core")
 	defer db.Close()
@@ -96,17 +106,64 @@ func TestCreateNewDb(t *testing.T) {
 	}
 }
 
+func TestMigrateExistingDb(t *testing.T) {
+	os.Remove("./test_existing_db.sqlite3")
+
+	db := newDb("./test_existing_db.sqlite3", "./assets/ddl.whosdriving-core")
+	_, err := db.Exec("PRAGMA user_version = 1")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db = newDb("./test_existing_db.sqlite3", "./assets/ddl.whosdriving-core")
+	defer db.Close()
+
+	version, err := data_interface.FindSchemaVersion(db)
+	assert.Nil(t, err, "")
+	assert.Equal(t, data_interface.SchemaVersion, version, "Migrated when the server starts")
+}
+
 func TestCustomConfig(t *testing.T) {
 	var expectedConfig Config
 	expectedConfig.host = "BBBB"
 	expectedConfig.port = "AAAA"
 	expectedConfig.dbPath = "CCCC"
 	expectedConfig.ddlPath = "DDDD"
+	expectedConfig.planHorizonDays = 7
+	expectedConfig.smtpHost = "EEEE"
+	expectedConfig.smtpPort = "FFFF"
+	expectedConfig.smtpUsername = "GGGG"
+	expectedConfig.smtpPassword = "HHHH"
+	expectedConfig.smtpFrom = "IIII"
+	expectedConfig.authHeader = "JJJJ"
 
 	os.Setenv("HOST", expectedConfig.host)
 	os.Setenv("PORT", expectedConfig.port)
 	os.Setenv("DB_PATH", expectedConfig.dbPath)
 	os.Setenv("DDL_PATH", expectedConfig.ddlPath)
+	os.Setenv("PLAN_HORIZON_DAYS", "7")
+	os.Setenv("SMTP_HOST", expectedConfig.smtpHost)
+	os.Setenv("SMTP_PORT", expectedConfig.smtpPort)
+	os.Setenv("SMTP_USERNAME", expectedConfig.smtpUsername)
+	os.Setenv("SMTP_PASSWORD", expectedConfig.smtpPassword)
+	os.Setenv("SMTP_FROM", expectedConfig.smtpFrom)
+	os.Setenv("AUTH_HEADER", expectedConfig.authHeader)
+	os.Setenv("RATE_LIMIT_QUERY", "30/s")
+	os.Setenv("RATE_LIMIT_MUTATION", "")
+	os.Setenv("RATE_LIMIT_LOGIN", "5/h")
+	os.Setenv("RATE_LIMIT_IP_HEADER", "X-Forwarded-For")
+	os.Setenv("MAX_COMPLEXITY", "500")
+	os.Setenv("MAX_DEPTH", "4")
+	os.Setenv("APQ_CACHE_SIZE", "50")
+	os.Setenv("QUERY_ALLOWLIST", "KKKK")
+	os.Setenv("CORS_ALLOWED_ORIGINS", "https://app.domain.com,http://localhost:3000")
+	os.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	os.Setenv("TLS_CERT_FILE", "LLLL")
+	os.Setenv("TLS_KEY_FILE", "MMMM")
+	os.Setenv("BACKUP_DIR", "NNNN")
+	os.Setenv("BACKUP_RETAIN", "3")
+	os.Setenv("BACKUP_INTERVAL", "12h")
 
 	config := createConfig()
 	t.Log(config)
@@ -114,6 +171,29 @@ func TestCustomConfig(t *testing.T) {
 	assert.EqualValues(t, config.port, expectedConfig.port)
 	assert.EqualValues(t, config.dbPath, expectedConfig.dbPath)
 	assert.EqualValues(t, config.ddlPath, expectedConfig.ddlPath)
+	assert.EqualValues(t, config.planHorizonDays, expectedConfig.planHorizonDays)
+	assert.EqualValues(t, config.smtpHost, expectedConfig.smtpHost)
+	assert.EqualValues(t, config.smtpPort, expectedConfig.smtpPort)
+	assert.EqualValues(t, config.smtpUsername, expectedConfig.smtpUsername)
+	assert.EqualValues(t, config.smtpPassword, expectedConfig.smtpPassword)
+	assert.EqualValues(t, config.smtpFrom, expectedConfig.smtpFrom)
+	assert.EqualValues(t, config.authHeader, expectedConfig.authHeader)
+	assert.Equal(t, map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.Query: {Events: 30, Period: time.Second},
+		ratelimit.Login: {Events: 5, Period: time.Hour},
+	}, config.rateLimits)
+	assert.EqualValues(t, config.rateLimitHeader, "X-Forwarded-For")
+	assert.EqualValues(t, config.maxComplexity, 500)
+	assert.EqualValues(t, config.maxDepth, 4)
+	assert.EqualValues(t, config.apqCacheSize, 50)
+	assert.EqualValues(t, config.queryAllowList, "KKKK")
+	assert.Equal(t, []string{"https://app.domain.com", "http://localhost:3000"}, config.corsOrigins)
+	assert.True(t, config.corsCredentials)
+	assert.EqualValues(t, config.tlsCertFile, "LLLL")
+	assert.EqualValues(t, config.tlsKeyFile, "MMMM")
+	assert.EqualValues(t, config.backupDir, "NNNN")
+	assert.EqualValues(t, config.backupRetain, 3)
+	assert.EqualValues(t, config.backupInterval, 12*time.Hour)
 }
 
 func TestDefaultConfig(t *testing.T) {
@@ -121,6 +201,28 @@ func TestDefaultConfig(t *testing.T) {
 	os.Unsetenv("PORT")
 	os.Unsetenv("DB_PATH")
 	os.Unsetenv("DDL_PATH")
+	os.Unsetenv("PLAN_HORIZON_DAYS")
+	os.Unsetenv("SMTP_HOST")
+	os.Unsetenv("SMTP_PORT")
+	os.Unsetenv("SMTP_USERNAME")
+	os.Unsetenv("SMTP_PASSWORD")
+	os.Unsetenv("SMTP_FROM")
+	os.Unsetenv("AUTH_HEADER")
+	os.Unsetenv("RATE_LIMIT_QUERY")
+	os.Unsetenv("RATE_LIMIT_MUTATION")
+	os.Unsetenv("RATE_LIMIT_LOGIN")
+	os.Unsetenv("RATE_LIMIT_IP_HEADER")
+	os.Unsetenv("MAX_COMPLEXITY")
+	os.Unsetenv("MAX_DEPTH")
+	os.Unsetenv("APQ_CACHE_SIZE")
+	os.Unsetenv("QUERY_ALLOWLIST")
+	os.Unsetenv("CORS_ALLOWED_ORIGINS")
+	os.Unsetenv("CORS_ALLOW_CREDENTIALS")
+	os.Unsetenv("TLS_CERT_FILE")
+	os.Unsetenv("TLS_KEY_FILE")
+	os.Unsetenv("BACKUP_DIR")
+	os.Unsetenv("BACKUP_RETAIN")
+	os.Unsetenv("BACKUP_INTERVAL")
 
 	config := createConfig()
 	t.Log(config)
@@ -128,4 +230,25 @@ func TestDefaultConfig(t *testing.T) {
 	assert.EqualValues(t, config.port, defaultPort)
 	assert.EqualValues(t, config.dbPath, defaultDbHostPath)
 	assert.EqualValues(t, config.ddlPath, defaultDdlPath)
+	assert.EqualValues(t, config.planHorizonDays, defaultPlanHorizonDays)
+	assert.EqualValues(t, config.smtpHost, "")
+	assert.EqualValues(t, config.smtpPort, defaultSmtpPort)
+	assert.EqualValues(t, config.smtpFrom, defaultSmtpFrom)
+	assert.EqualValues(t, config.authHeader, "")
+	assert.Equal(t, map[ratelimit.Class]ratelimit.Limit{
+		ratelimit.Query:    {Events: 600, Period: time.Minute},
+		ratelimit.Mutation: {Events: 120, Period: time.Minute},
+		ratelimit.Login:    {Events: 10, Period: time.Minute},
+	}, config.rateLimits)
+	assert.EqualValues(t, config.rateLimitHeader, "")
+	assert.EqualValues(t, config.maxComplexity, defaultMaxComplexity)
+	assert.EqualValues(t, config.maxDepth, defaultMaxDepth)
+	assert.EqualValues(t, config.apqCacheSize, defaultApqCacheSize)
+	assert.EqualValues(t, config.queryAllowList, "")
+	assert.Empty(t, config.corsOrigins)
+	assert.False(t, config.corsCredentials)
+	assert.EqualValues(t, config.tlsCertFile, "")
+	assert.EqualValues(t, config.backupDir, defaultBackupDir)
+	assert.EqualValues(t, config.backupRetain, defaultBackupRetain)
+	assert.EqualValues(t, config.backupInterval, 0)
 }
@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+)
+
+const (
+	EventHeader     = "X-Whosdriving-Event"
+	DeliveryHeader  = "X-Whosdriving-Delivery"
+	SignatureHeader = "X-Whosdriving-Signature"
+)
+
+// A delivery is given up after this many attempts
+const MaxAttempts = 8
+
+// Retries wait baseDelay, then twice longer each time up to maxDelay
+const baseDelay = 30 * time.Second
+const maxDelay = 6 * time.Hour
+
+// Deliveries posted per run of the worker
+const batchSize = 50
+
+// Only the beginning of a failed response body is kept in the log
+const maxErrorLength = 512
+
+// Sign returns the hex HMAC-SHA256 of the payload prefixed with its algorithm, as in "sha256=..."
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature in constant time, for the receivers written in go
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
+
+// Backoff returns the delay before the retry following the given number of attempts
+func Backoff(attempts int) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
+}
+
+// Deliver posts the due deliveries and logs their outcome, it returns how many were attempted
+func Deliver(ctx context.Context, db *sql.DB, client *http.Client) (int, error) {
+	deliveries, err := findDueDeliveries(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		responseStatus, err := post(ctx, client, delivery)
+		status := model.DeliveryStatusDelivered
+		var lastError *string
+		var nextAttempt *time.Time
+		if err != nil {
+			message := err.Error()
+			lastError = &message
+			status = model.DeliveryStatusFailed
+			if delivery.Attempts+1 < MaxAttempts {
+				status = model.DeliveryStatusPending
+				next := time.Now().Add(Backoff(delivery.Attempts + 1))
+				nextAttempt = &next
+			}
+			log.Printf("Error: Couldn't deliver %d to %s - %s", delivery.ID, delivery.URL, err)
+		}
+
+		if err := recordAttempt(ctx, db, delivery.ID, status, responseStatus, lastError, nextAttempt); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+func post(ctx context.Context, client *http.Client, delivery *data_interface.PendingDelivery) (*int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whosdriving-webhook")
+	req.Header.Set(EventHeader, delivery.Event.String())
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	responseStatus := res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorLength))
+		return &responseStatus, fmt.Errorf("%s %s", res.Status, bytes.TrimSpace(body))
+	}
+	return &responseStatus, nil
+}
+
+func findDueDeliveries(ctx context.Context, db *sql.DB) ([]*data_interface.PendingDelivery, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	deliveries, err := data_interface.FindDueDeliveries(ctx, &lCtx, time.Now(), batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, tx.Commit()
+}
+
+func recordAttempt(ctx context.Context, db *sql.DB, id int64, status model.DeliveryStatus,
+	responseStatus *int, lastError *string, nextAttempt *time.Time) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lCtx := data_interface.LuwContext{Conn: db, Tx: tx}
+	if err := data_interface.RecordDeliveryAttempt(ctx, &lCtx, id, status, responseStatus, lastError, nextAttempt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,242 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"whosdriving-be/data_interface"
+	"whosdriving-be/graph/model"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver answers with the queued status codes, then 200, and keeps what was posted
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	events   []string
+	payloads [][]byte
+	valid    []bool
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.events = append(rcv.events, r.Header.Get(EventHeader))
+	rcv.payloads = append(rcv.payloads, body)
+	rcv.valid = append(rcv.valid, Verify("s3cret", body, r.Header.Get(SignatureHeader)))
+
+	status := http.StatusOK
+	if len(rcv.statuses) > 0 {
+		status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func withTx(t *testing.T, db *sql.DB, do func(ctx context.Context, lCtx *data_interface.LuwContext)) {
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		t.Fatalf("Could't create transaction - %s", err)
+	}
+	defer tx.Rollback()
+
+	do(ctx, &data_interface.LuwContext{Conn: db, Tx: tx})
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error on commit - %s", err)
+	}
+}
+
+// makeDue moves the next attempt of the pending deliveries to now
+func makeDue(t *testing.T, db *sql.DB) {
+	_, err := db.Exec("UPDATE WebhookDeliveries set nextAttemptTmstmp=? where nextAttemptTmstmp is not null", time.Now().UTC().Add(-time.Second))
+	assert.Nil(t, err, "")
+}
+
+func TestDeliver(t *testing.T) {
+	const dbPath = "../test_webhook.sqlite3"
+	os.Remove(dbPath)
+
+	db, err := data_interface.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Could't create connection %s - %s", dbPath, err)
+	}
+	defer db.Close()
+
+	if err := data_interface.Migrate("../assets/ddl.whosdriving-core", db); err != nil {
+		t.Fatalf("Migration error - %s", err)
+	}
+
+	rcv := &receiver{statuses: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	// The receiver is registered under a public name, the client dials the test server instead
+	const hookURL = "http://hooks.domain.com/whosdriving"
+	dialer := &net.Dialer{}
+	transport := &http.Transport{DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+	}}
+
+	var webhook *model.Webhook
+	withTx(t, db, func(ctx context.Context, lCtx *data_interface.LuwContext) {
+		for _, email := range []string{"test@domain.com", "john@domain.com", "other@domain.com"} {
+			_, err := data_interface.CreateUser(ctx, lCtx, &model.NewUser{Email: email})
+			assert.Nil(t, err, "")
+		}
+
+		_, err := data_interface.CreateRotation(ctx, lCtx, &model.NewRotation{
+			Name:              "Office",
+			EmailCreator:      "test@domain.com",
+			EmailParticipants: []string{"test@domain.com", "john@domain.com"},
+		})
+		assert.Nil(t, err, "")
+
+		_, err = data_interface.CreateWebhook(ctx, lCtx, &model.NewWebhook{IDRotation: 1, URL: "ftp://domain.com", Secret: "s3cret",
+			Events: []model.WebhookEvent{model.WebhookEventRideCreated}})
+		assert.NotNil(t, err, "")
+		_, err = data_interface.CreateWebhook(ctx, lCtx, &model.NewWebhook{IDRotation: 1, URL: hookURL, Secret: "s3cret"})
+		assert.NotNil(t, err, "")
+		// the local host and network are out of reach
+		for _, url := range []string{server.URL, "http://localhost:8080/hook", "http://169.254.169.254/latest", "http://[::1]/hook"} {
+			_, err = data_interface.CreateWebhook(ctx, lCtx, &model.NewWebhook{IDRotation: 1, URL: url, Secret: "s3cret",
+				Events: []model.WebhookEvent{model.WebhookEventRideCreated}})
+			assert.NotNil(t, err, url)
+		}
+
+		webhook, err = data_interface.CreateWebhook(ctx, lCtx, &model.NewWebhook{
+			IDRotation: 1,
+			URL:        hookURL,
+			Secret:     "s3cret",
+			Events:     []model.WebhookEvent{model.WebhookEventRideCreated, model.WebhookEventMemberAdded},
+		})
+		assert.Nil(t, err, "")
+
+		// ride created and member added are subscribed, member removed is not
+		_, err = data_interface.AddRide(ctx, lCtx, &model.NewRide{
+			IDRotation:        1,
+			EmailConductor:    "john@domain.com",
+			EmailParticipants: []string{"john@domain.com", "test@domain.com"},
+		})
+		assert.Nil(t, err, "")
+		err = data_interface.CreateRotationParticipants(ctx, lCtx, 1, &[]string{"other@domain.com"})
+		assert.Nil(t, err, "")
+		err = data_interface.RemoveRotationParticipants(ctx, lCtx, 1, &[]string{"other@domain.com"})
+		assert.Nil(t, err, "")
+	})
+
+	client := &http.Client{Timeout: time.Second, Transport: transport}
+	ctx := context.Background()
+
+	// first post of the ride fails and is retried later
+	count, err := Deliver(ctx, db, client)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 2, count)
+
+	count, err = Deliver(ctx, db, client)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 0, count, "retry not due yet")
+
+	makeDue(t, db)
+	count, err = Deliver(ctx, db, client)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 1, count)
+
+	rcv.mu.Lock()
+	assert.Equal(t, []string{"RIDE_CREATED", "MEMBER_ADDED", "RIDE_CREATED"}, rcv.events)
+	assert.Equal(t, []bool{true, true, true}, rcv.valid)
+	var payload struct {
+		Event    string `json:"event"`
+		Rotation struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"rotation"`
+		Ride struct {
+			ID           int      `json:"id"`
+			Status       string   `json:"status"`
+			Conductor    string   `json:"conductor"`
+			Participants []string `json:"participants"`
+		} `json:"ride"`
+	}
+	assert.Nil(t, json.Unmarshal(rcv.payloads[2], &payload), "")
+	rcv.mu.Unlock()
+	assert.Equal(t, "RIDE_CREATED", payload.Event)
+	assert.Equal(t, "Office", payload.Rotation.Name)
+	assert.Equal(t, 1, payload.Ride.ID)
+	assert.Equal(t, "RECORDED", payload.Ride.Status)
+	assert.Equal(t, "john@domain.com", payload.Ride.Conductor)
+	assert.Equal(t, []string{"john@domain.com", "test@domain.com"}, payload.Ride.Participants)
+
+	withTx(t, db, func(ctx context.Context, lCtx *data_interface.LuwContext) {
+		deliveries, err := data_interface.FindWebhookDeliveries(ctx, lCtx, int64(webhook.ID), nil)
+		assert.Nil(t, err, "")
+		assert.Equal(t, 2, len(deliveries))
+		for _, delivery := range deliveries {
+			assert.Equal(t, model.DeliveryStatusDelivered, delivery.Status)
+			assert.Equal(t, http.StatusOK, *delivery.ResponseStatus)
+			assert.Nil(t, delivery.NextAttemptAt)
+		}
+		assert.Equal(t, model.WebhookEventRideCreated, deliveries[1].Event)
+		assert.Equal(t, 2, deliveries[1].Attempts)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+	})
+
+	// an unreachable receiver is given up after MaxAttempts
+	server.Close()
+	withTx(t, db, func(ctx context.Context, lCtx *data_interface.LuwContext) {
+		err := data_interface.CreateRotationParticipants(ctx, lCtx, 1, &[]string{"other@domain.com"})
+		assert.Nil(t, err, "")
+	})
+	for i := 0; i < MaxAttempts; i++ {
+		count, err = Deliver(ctx, db, client)
+		assert.Nil(t, err, "")
+		assert.Equal(t, 1, count)
+		makeDue(t, db)
+	}
+	count, err = Deliver(ctx, db, client)
+	assert.Nil(t, err, "")
+	assert.Equal(t, 0, count)
+
+	limit := 1
+	withTx(t, db, func(ctx context.Context, lCtx *data_interface.LuwContext) {
+		deliveries, err := data_interface.FindWebhookDeliveries(ctx, lCtx, int64(webhook.ID), &limit)
+		assert.Nil(t, err, "")
+		assert.Equal(t, 1, len(deliveries))
+		assert.Equal(t, model.DeliveryStatusFailed, deliveries[0].Status)
+		assert.Equal(t, MaxAttempts, deliveries[0].Attempts)
+		assert.NotNil(t, deliveries[0].LastError)
+
+		negative := -1
+		_, err = data_interface.FindWebhookDeliveries(ctx, lCtx, int64(webhook.ID), &negative)
+		assert.NotNil(t, err, "")
+	})
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"event":"RIDE_CREATED"}' | openssl dgst -sha256 -hmac s3cret
+	payload := []byte(`{"event":"RIDE_CREATED"}`)
+	signature := Sign("s3cret", payload)
+	assert.Equal(t, "sha256=5e8f37dd77cf015420a6b3bb9aa2592c55425ca861261771e15b889d4d7ea5dd", signature)
+	assert.True(t, Verify("s3cret", payload, signature))
+	assert.False(t, Verify("other", payload, signature))
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 2*time.Minute, Backoff(3))
+	assert.Equal(t, maxDelay, Backoff(20))
+}